@@ -0,0 +1,172 @@
+// Command metric-proxy-cli queries a metric-proxy over gRPC, printing
+// instance metrics the way `cf app` does, or as JSON.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/log-cache/pkg/rpc/logcache_v1"
+	"code.cloudfoundry.org/metric-proxy/pkg/rpc/metricproxy_v1"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+const usage = `Usage: metric-proxy-cli [flags] <command> [args]
+
+Commands:
+  read <source-id>                     instance metrics of one app, or its
+                                       rollup with a ":rollup" suffix
+  batch-read <source-id>...            instance metrics of several apps
+  aggregate (--space|--org) <guid>     usage summed over a space or org
+  meta                                 log-cache metadata; metric-proxy
+                                       keeps no history, so this is empty
+
+Flags:
+`
+
+type options struct {
+	addr    string
+	timeout time.Duration
+	json    bool
+
+	tls                bool
+	caFile             string
+	certFile           string
+	keyFile            string
+	serverName         string
+	insecureSkipVerify bool
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout, stderr io.Writer) error {
+	var opts options
+	flags := flag.NewFlagSet("metric-proxy-cli", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+	flags.StringVar(&opts.addr, "addr", "localhost:8080", "metric-proxy gRPC address")
+	flags.DurationVar(&opts.timeout, "timeout", 10*time.Second, "request timeout")
+	flags.BoolVar(&opts.json, "json", false, "print responses as JSON")
+	flags.BoolVar(&opts.tls, "tls", false, "connect with TLS; implied by the other TLS flags")
+	flags.StringVar(&opts.caFile, "ca", "", "CA certificate file to verify the server with")
+	flags.StringVar(&opts.certFile, "cert", "", "client certificate file")
+	flags.StringVar(&opts.keyFile, "key", "", "client key file")
+	flags.StringVar(&opts.serverName, "server-name", "", "server name to verify the certificate against")
+	flags.BoolVar(&opts.insecureSkipVerify, "insecure-skip-verify", false, "don't verify the server certificate")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("no command given")
+	}
+
+	dialOpt, err := transportCredentials(opts)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.timeout)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, opts.addr, dialOpt, grpc.WithBlock())
+	if err != nil {
+		return fmt.Errorf("cannot connect to %s: %w", opts.addr, err)
+	}
+	defer conn.Close()
+
+	command, commandArgs := flags.Arg(0), flags.Args()[1:]
+	resp, err := call(ctx, conn, command, commandArgs)
+	if err != nil {
+		return err
+	}
+
+	if opts.json {
+		if err := (&jsonpb.Marshaler{OrigName: true, Indent: "  "}).Marshal(stdout, resp); err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(stdout)
+		return err
+	}
+	return printTable(stdout, resp)
+}
+
+func call(ctx context.Context, conn *grpc.ClientConn, command string, args []string) (proto.Message, error) {
+	switch command {
+	case "read":
+		if len(args) != 1 {
+			return nil, errors.New("usage: read <source-id>")
+		}
+		return logcache_v1.NewEgressClient(conn).Read(ctx, &logcache_v1.ReadRequest{SourceId: args[0]})
+	case "batch-read":
+		if len(args) == 0 {
+			return nil, errors.New("usage: batch-read <source-id>...")
+		}
+		return metricproxy_v1.NewEgressClient(conn).BatchRead(ctx, &metricproxy_v1.BatchReadRequest{SourceIds: args})
+	case "aggregate":
+		if len(args) != 2 || (args[0] != "--space" && args[0] != "--org") {
+			return nil, errors.New("usage: aggregate (--space|--org) <guid>")
+		}
+		req := &metricproxy_v1.AggregateReadRequest{SpaceGuid: args[1]}
+		if args[0] == "--org" {
+			req = &metricproxy_v1.AggregateReadRequest{OrgGuid: args[1]}
+		}
+		return metricproxy_v1.NewAggregatorClient(conn).AggregateRead(ctx, req)
+	case "meta":
+		return logcache_v1.NewEgressClient(conn).Meta(ctx, &logcache_v1.MetaRequest{})
+	default:
+		return nil, fmt.Errorf("unknown command %q, expected one of: %s", command, strings.Join([]string{"read", "batch-read", "aggregate", "meta"}, ", "))
+	}
+}
+
+func transportCredentials(opts options) (grpc.DialOption, error) {
+	if !opts.tls && opts.caFile == "" && opts.certFile == "" && opts.keyFile == "" && !opts.insecureSkipVerify {
+		return grpc.WithInsecure(), nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         opts.serverName,
+		InsecureSkipVerify: opts.insecureSkipVerify,
+	}
+
+	if opts.caFile != "" {
+		ca, err := ioutil.ReadFile(opts.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", opts.caFile)
+		}
+	}
+
+	if opts.certFile != "" || opts.keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.certFile, opts.keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)), nil
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/log-cache/pkg/rpc/logcache_v1"
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics"
+	"code.cloudfoundry.org/metric-proxy/pkg/rpc/metricproxy_v1"
+	"github.com/golang/protobuf/proto"
+)
+
+// printTable prints resp the way the cf CLI would.
+func printTable(w io.Writer, resp proto.Message) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+
+	switch resp := resp.(type) {
+	case *logcache_v1.ReadResponse:
+		printInstances(tw, resp.GetEnvelopes().GetBatch())
+	case *metricproxy_v1.BatchReadResponse:
+		sourceIDs := make([]string, 0, len(resp.GetEnvelopes()))
+		for sourceID := range resp.GetEnvelopes() {
+			sourceIDs = append(sourceIDs, sourceID)
+		}
+		sort.Strings(sourceIDs)
+		for i, sourceID := range sourceIDs {
+			if i > 0 {
+				fmt.Fprintln(tw)
+			}
+			fmt.Fprintf(tw, "%s:\n", sourceID)
+			printInstances(tw, resp.GetEnvelopes()[sourceID].GetBatch())
+		}
+	case *metricproxy_v1.AggregateReadResponse:
+		printUsage(tw, resp)
+	case *logcache_v1.MetaResponse:
+		printMeta(tw, resp)
+	default:
+		return fmt.Errorf("cannot print %T as a table", resp)
+	}
+
+	return tw.Flush()
+}
+
+// instanceRow is one instance's gauges merged across its envelopes.
+type instanceRow struct {
+	id        string
+	timestamp int64
+	gauges    map[string]float64
+}
+
+// printInstances prints one row per instance, like the instance rows of
+// `cf app`. Rollup envelopes are printed after the instances, labelled by
+// their statistic.
+func printInstances(w io.Writer, envelopes []*loggregator_v2.Envelope) {
+	rows := map[string]*instanceRow{}
+	for _, e := range envelopes {
+		id := "#" + e.GetInstanceId()
+		if statistic, ok := e.GetTags()[metrics.RollupTag]; ok {
+			id = statistic
+		}
+		row, ok := rows[id]
+		if !ok {
+			row = &instanceRow{id: id, gauges: map[string]float64{}}
+			rows[id] = row
+		}
+		if e.GetTimestamp() > row.timestamp {
+			row.timestamp = e.GetTimestamp()
+		}
+		for name, gauge := range e.GetGauge().GetMetrics() {
+			row.gauges[name] = gauge.GetValue()
+		}
+	}
+
+	sorted := make([]*instanceRow, 0, len(rows))
+	for _, row := range rows {
+		sorted = append(sorted, row)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return instanceLess(sorted[i].id, sorted[j].id)
+	})
+
+	fmt.Fprintln(w, "\tstate\tsince\tcpu\tmemory\tdisk\tdetails")
+	for _, row := range sorted {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%.1f%%\t%s\t%s\t\n",
+			row.id,
+			row.state(),
+			row.since(),
+			row.gauges["cpu"],
+			ofQuota(row.gauges, "memory"),
+			ofQuota(row.gauges, "disk"),
+		)
+	}
+}
+
+func (r *instanceRow) state() string {
+	state, ok := r.gauges["state"]
+	if !ok {
+		return "-"
+	}
+	return strings.ToLower(metrics.InstanceState(state).String())
+}
+
+// since is when the instance started, going by its container_age gauge.
+// Instances that aren't running have no age, and rollups of container_age
+// don't make a start time.
+func (r *instanceRow) since() string {
+	age := r.gauges["container_age"]
+	if age <= 0 || r.timestamp == 0 || !strings.HasPrefix(r.id, "#") {
+		return "-"
+	}
+	started := time.Unix(0, r.timestamp).Add(-time.Duration(age))
+	return started.UTC().Format(time.RFC3339)
+}
+
+// instanceLess orders numbered instances numerically, before rollups.
+func instanceLess(a, b string) bool {
+	ai, aErr := strconv.Atoi(strings.TrimPrefix(a, "#"))
+	bi, bErr := strconv.Atoi(strings.TrimPrefix(b, "#"))
+	switch {
+	case aErr == nil && bErr == nil:
+		return ai < bi
+	case aErr == nil || bErr == nil:
+		return aErr == nil
+	default:
+		return a < b
+	}
+}
+
+func ofQuota(gauges map[string]float64, name string) string {
+	usage := byteSize(uint64(gauges[name]))
+	if quota, ok := gauges[name+"_quota"]; ok {
+		return fmt.Sprintf("%s of %s", usage, byteSize(uint64(quota)))
+	}
+	return usage
+}
+
+func printUsage(w io.Writer, resp *metricproxy_v1.AggregateReadResponse) {
+	sourceIDs := make([]string, 0, len(resp.GetSources()))
+	for sourceID := range resp.GetSources() {
+		sourceIDs = append(sourceIDs, sourceID)
+	}
+	sort.Strings(sourceIDs)
+
+	fmt.Fprintln(w, "source id\tinstances\tcpu\tmemory\tdisk\t")
+	for _, sourceID := range sourceIDs {
+		printUsageRow(w, sourceID, resp.GetSources()[sourceID])
+	}
+	printUsageRow(w, "total", resp.GetTotal())
+}
+
+func printUsageRow(w io.Writer, name string, u *metricproxy_v1.Usage) {
+	memory := byteSize(u.GetMemoryBytes())
+	if u.GetMemoryQuotaBytes() > 0 {
+		memory += " of " + byteSize(u.GetMemoryQuotaBytes())
+	}
+	disk := byteSize(u.GetDiskBytes())
+	if u.GetDiskQuotaBytes() > 0 {
+		disk += " of " + byteSize(u.GetDiskQuotaBytes())
+	}
+	fmt.Fprintf(w, "%s\t%d/%d\t%.1f%%\t%s\t%s\t\n",
+		name, u.GetRunningInstances(), u.GetInstances(), u.GetCpuPercentage(), memory, disk)
+}
+
+func printMeta(w io.Writer, resp *logcache_v1.MetaResponse) {
+	sourceIDs := make([]string, 0, len(resp.GetMeta()))
+	for sourceID := range resp.GetMeta() {
+		sourceIDs = append(sourceIDs, sourceID)
+	}
+	sort.Strings(sourceIDs)
+
+	fmt.Fprintln(w, "source id\tcount\t")
+	for _, sourceID := range sourceIDs {
+		fmt.Fprintf(w, "%s\t%d\t\n", sourceID, resp.GetMeta()[sourceID].GetCount())
+	}
+}
+
+// byteSize formats bytes the way the cf CLI does, e.g. 27.2M or 1G.
+func byteSize(bytes uint64) string {
+	units := []struct {
+		suffix string
+		size   uint64
+	}{
+		{"T", 1 << 40},
+		{"G", 1 << 30},
+		{"M", 1 << 20},
+		{"K", 1 << 10},
+	}
+
+	for _, unit := range units {
+		if bytes >= unit.size {
+			value := strconv.FormatFloat(float64(bytes)/float64(unit.size), 'f', 1, 64)
+			return strings.TrimSuffix(value, ".0") + unit.suffix
+		}
+	}
+	if bytes == 0 {
+		return "0"
+	}
+	return fmt.Sprintf("%dB", bytes)
+}
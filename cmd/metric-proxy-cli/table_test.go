@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/log-cache/pkg/rpc/logcache_v1"
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics"
+	"code.cloudfoundry.org/metric-proxy/pkg/rpc/metricproxy_v1"
+	. "github.com/onsi/gomega"
+)
+
+func TestPrintTable(t *testing.T) {
+	now := time.Date(2020, 3, 9, 15, 33, 37, 0, time.UTC)
+	envelope := func(instanceID string, tags map[string]string, gauges map[string]float64) *loggregator_v2.Envelope {
+		metrics := map[string]*loggregator_v2.GaugeValue{}
+		for name, value := range gauges {
+			metrics[name] = &loggregator_v2.GaugeValue{Value: value}
+		}
+		return &loggregator_v2.Envelope{
+			Timestamp:  now.UnixNano(),
+			SourceId:   "app-guid",
+			InstanceId: instanceID,
+			Tags:       tags,
+			Message:    &loggregator_v2.Envelope_Gauge{Gauge: &loggregator_v2.Gauge{Metrics: metrics}},
+		}
+	}
+	lines := func(buf *bytes.Buffer) []string {
+		var trimmed []string
+		for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n") {
+			trimmed = append(trimmed, strings.TrimRight(line, " "))
+		}
+		return trimmed
+	}
+
+	t.Run("it prints instances like cf app", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		var buf bytes.Buffer
+		err := printTable(&buf, &logcache_v1.ReadResponse{Envelopes: &loggregator_v2.EnvelopeBatch{Batch: []*loggregator_v2.Envelope{
+			envelope("10", nil, map[string]float64{"cpu": 1.25, "memory": 300 << 20, "disk": 0}),
+			envelope("10", nil, map[string]float64{"state": float64(metrics.InstanceStateCrashed), "container_age": 0}),
+			envelope("0", nil, map[string]float64{
+				"cpu":           0.3,
+				"memory":        27.2 * (1 << 20),
+				"memory_quota":  1 << 30,
+				"disk":          1536,
+				"disk_quota":    1 << 30,
+				"state":         float64(metrics.InstanceStateRunning),
+				"container_age": float64(90 * time.Second),
+			}),
+			envelope("", map[string]string{metrics.RollupTag: "sum"}, map[string]float64{"cpu": 1.55}),
+		}}})
+		g.Expect(err).ToNot(HaveOccurred())
+
+		g.Expect(lines(&buf)).To(Equal([]string{
+			"      state     since                  cpu    memory        disk         details",
+			"#0    running   2020-03-09T15:32:07Z   0.3%   27.2M of 1G   1.5K of 1G",
+			"#10   crashed   -                      1.2%   300M          0",
+			"sum   -         -                      1.6%   0             0",
+		}))
+	})
+
+	t.Run("it prints each source of a batch read", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		var buf bytes.Buffer
+		err := printTable(&buf, &metricproxy_v1.BatchReadResponse{Envelopes: map[string]*loggregator_v2.EnvelopeBatch{
+			"b": {Batch: []*loggregator_v2.Envelope{envelope("0", nil, map[string]float64{"cpu": 2})}},
+			"a": {Batch: []*loggregator_v2.Envelope{envelope("0", nil, map[string]float64{"cpu": 1})}},
+		}})
+		g.Expect(err).ToNot(HaveOccurred())
+
+		output := lines(&buf)
+		g.Expect(output).To(HaveLen(7))
+		g.Expect(output[0]).To(Equal("a:"))
+		g.Expect(output[2]).To(ContainSubstring("1.0%"))
+		g.Expect(output[4]).To(Equal("b:"))
+		g.Expect(output[6]).To(ContainSubstring("2.0%"))
+	})
+
+	t.Run("it prints aggregate usage with a total", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		var buf bytes.Buffer
+		err := printTable(&buf, &metricproxy_v1.AggregateReadResponse{
+			Total: &metricproxy_v1.Usage{CpuPercentage: 12.5, MemoryBytes: 2 << 30, MemoryQuotaBytes: 4 << 30, Instances: 3, RunningInstances: 2},
+			Sources: map[string]*metricproxy_v1.Usage{
+				"app-guid": {CpuPercentage: 12.5, MemoryBytes: 2 << 30, MemoryQuotaBytes: 4 << 30, Instances: 3, RunningInstances: 2},
+			},
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+
+		g.Expect(lines(&buf)).To(Equal([]string{
+			"source id   instances   cpu     memory     disk",
+			"app-guid    2/3         12.5%   2G of 4G   0",
+			"total       2/3         12.5%   2G of 4G   0",
+		}))
+	})
+}
+
+func TestByteSize(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	g.Expect(byteSize(0)).To(Equal("0"))
+	g.Expect(byteSize(512)).To(Equal("512B"))
+	g.Expect(byteSize(1024)).To(Equal("1K"))
+	g.Expect(byteSize(27*(1<<20) + 200*(1<<10))).To(Equal("27.2M"))
+	g.Expect(byteSize(1 << 30)).To(Equal("1G"))
+	g.Expect(byteSize(3 << 40)).To(Equal("3T"))
+}
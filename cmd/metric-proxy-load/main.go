@@ -0,0 +1,312 @@
+// Command metric-proxy-load drives concurrent gRPC Reads against an
+// in-process metric-proxy serving synthetic apps, and reports latency
+// percentiles, allocations and how often each upstream was called.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"code.cloudfoundry.org/log-cache/pkg/rpc/logcache_v1"
+	"code.cloudfoundry.org/metric-proxy/pkg/logging"
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics"
+	"code.cloudfoundry.org/metric-proxy/pkg/selfmetrics"
+	"code.cloudfoundry.org/metric-proxy/pkg/synthetic"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+type options struct {
+	apps            int
+	instances       int
+	concurrency     int
+	duration        time.Duration
+	upstreamLatency time.Duration
+	cacheTTL        time.Duration
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, stdout, stderr io.Writer) error {
+	var opts options
+	flags := flag.NewFlagSet("metric-proxy-load", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.IntVar(&opts.apps, "apps", 500, "number of synthetic apps")
+	flags.IntVar(&opts.instances, "instances", 2, "instances per app")
+	flags.IntVar(&opts.concurrency, "concurrency", 10, "concurrent readers")
+	flags.DurationVar(&opts.duration, "duration", 10*time.Second, "how long to read for")
+	flags.DurationVar(&opts.upstreamLatency, "upstream-latency", 5*time.Millisecond, "added to every upstream call")
+	flags.DurationVar(&opts.cacheTTL, "cache-ttl", time.Second, "metrics cache TTL, as METRICS_CACHE_TTL")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if opts.apps < 1 || opts.instances < 1 || opts.concurrency < 1 {
+		return errors.New("apps, instances and concurrency must be at least 1")
+	}
+
+	backend := newCountingBackend(opts)
+	addr, stop, err := serve(opts, backend)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(stderr, "reading %d apps of %d instances with %d readers for %s\n",
+		opts.apps, opts.instances, opts.concurrency, opts.duration)
+	res := load(logcache_v1.NewEgressClient(conn), backend.generator.GUIDs(), opts)
+	res.upstream = backend.counts()
+
+	return res.print(stdout)
+}
+
+// serve starts the Egress API on a local port, wired the way main.go wires
+// it, minus rate limiting and counters.
+func serve(opts options, b *countingBackend) (string, func(), error) {
+	loggr := logging.New(ioutil.Discard, logging.Error)
+	selfMetrics := selfmetrics.New(prometheus.NewRegistry(), []float64{1})
+	proxy := metrics.NewProxy(
+		loggr,
+		selfMetrics,
+		metrics.NewMetricsCache(selfMetrics, cache.NewExpiring(), opts.cacheTTL),
+		b.Metrics,
+		b.Pods,
+		b,
+		b,
+		metrics.ProxyOptions{},
+	)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	s := grpc.NewServer()
+	logcache_v1.RegisterEgressServer(s, proxy)
+	go s.Serve(lis)
+
+	return lis.Addr().String(), s.Stop, nil
+}
+
+type result struct {
+	requests  int
+	errors    int
+	elapsed   time.Duration
+	latencies []time.Duration
+
+	allocs     uint64
+	allocBytes uint64
+	peakHeap   uint64
+
+	upstream map[string]int64
+}
+
+// load reads guids round robin from opts.concurrency goroutines until
+// opts.duration has passed.
+func load(client logcache_v1.EgressClient, guids []string, opts options) *result {
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration
+		failures  int
+		next      int64
+		wg        sync.WaitGroup
+	)
+
+	var before runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	peak := make(chan uint64)
+	done := make(chan struct{})
+	go samplePeakHeap(done, peak)
+
+	start := time.Now()
+	deadline := start.Add(opts.duration)
+	for i := 0; i < opts.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var own []time.Duration
+			ownFailures := 0
+			for time.Now().Before(deadline) {
+				guid := guids[int(atomic.AddInt64(&next, 1)-1)%len(guids)]
+				readStart := time.Now()
+				_, err := client.Read(context.Background(), &logcache_v1.ReadRequest{SourceId: guid})
+				own = append(own, time.Since(readStart))
+				if err != nil {
+					ownFailures++
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			latencies = append(latencies, own...)
+			failures += ownFailures
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	close(done)
+
+	var after runtime.MemStats
+	runtime.ReadMemStats(&after)
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	return &result{
+		requests:   len(latencies),
+		errors:     failures,
+		elapsed:    elapsed,
+		latencies:  latencies,
+		allocs:     after.Mallocs - before.Mallocs,
+		allocBytes: after.TotalAlloc - before.TotalAlloc,
+		peakHeap:   <-peak,
+	}
+}
+
+// samplePeakHeap sends the largest in-use heap seen until done is closed.
+func samplePeakHeap(done <-chan struct{}, peak chan<- uint64) {
+	var max uint64
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		if stats.HeapInuse > max {
+			max = stats.HeapInuse
+		}
+
+		select {
+		case <-done:
+			peak <- max
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// percentile returns the p-th percentile of sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted))*p/100+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+func (r *result) print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "requests\t%d (%d errors)\n", r.requests, r.errors)
+	fmt.Fprintf(tw, "throughput\t%.1f/s\n", float64(r.requests)/r.elapsed.Seconds())
+	for _, p := range []float64{50, 90, 99} {
+		fmt.Fprintf(tw, "p%g\t%s\n", p, percentile(r.latencies, p))
+	}
+	if r.requests > 0 {
+		fmt.Fprintf(tw, "max\t%s\n", r.latencies[len(r.latencies)-1])
+		// The client runs in the same process, so this includes its
+		// allocations too.
+		fmt.Fprintf(tw, "allocs/read\t%d (%d B)\n", r.allocs/uint64(r.requests), r.allocBytes/uint64(r.requests))
+	}
+	fmt.Fprintf(tw, "peak heap in use\t%.1f MiB\n", float64(r.peakHeap)/(1<<20))
+
+	names := make([]string, 0, len(r.upstream))
+	for name := range r.upstream {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(tw, "upstream %s\t%d\n", name, r.upstream[name])
+	}
+
+	return tw.Flush()
+}
+
+// countingBackend serves synthetic apps, counting calls and adding latency
+// the way the Kubernetes APIs would.
+type countingBackend struct {
+	// The counters come first to be 64-bit aligned for atomic access.
+	metrics, pods, podGets, diskUsage int64
+
+	generator *synthetic.Generator
+	latency   time.Duration
+}
+
+func newCountingBackend(opts options) *countingBackend {
+	return &countingBackend{
+		generator: synthetic.NewGenerator(synthetic.Config{
+			Apps:       opts.apps,
+			Instances:  opts.instances,
+			Seed:       1,
+			AppLabel:   "cloudfoundry.org/app_guid",
+			SpaceLabel: "cloudfoundry.org/space_guid",
+			OrgLabel:   "cloudfoundry.org/org_guid",
+		}, clock.RealClock{}),
+		latency: opts.upstreamLatency,
+	}
+}
+
+func (b *countingBackend) Metrics(ctx context.Context, guid string) (*v1beta1.PodMetricsList, error) {
+	atomic.AddInt64(&b.metrics, 1)
+	time.Sleep(b.latency)
+	return b.generator.Metrics(ctx, guid)
+}
+
+// Pods isn't delayed: in a cluster it reads the informer's cache.
+func (b *countingBackend) Pods(guid string) ([]*v1.Pod, error) {
+	atomic.AddInt64(&b.pods, 1)
+	return b.generator.Pods(guid)
+}
+
+func (b *countingBackend) Get(ctx context.Context, podName string) (*v1.Pod, error) {
+	atomic.AddInt64(&b.podGets, 1)
+	time.Sleep(b.latency)
+	return b.generator.Get(ctx, podName)
+}
+
+// DiskUsage stands in for the node summary fetcher, without its cache.
+func (b *countingBackend) DiskUsage(ctx context.Context, podName string) (int64, error) {
+	atomic.AddInt64(&b.diskUsage, 1)
+	time.Sleep(b.latency)
+	return b.generator.DiskUsage(ctx, podName)
+}
+
+func (b *countingBackend) counts() map[string]int64 {
+	return map[string]int64{
+		selfmetrics.DependencyMetricsAPI:  atomic.LoadInt64(&b.metrics),
+		selfmetrics.DependencyPods:        atomic.LoadInt64(&b.podGets),
+		selfmetrics.DependencyNodeSummary: atomic.LoadInt64(&b.diskUsage),
+		"pod-lister":                      atomic.LoadInt64(&b.pods),
+	}
+}
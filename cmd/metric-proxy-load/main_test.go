@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestRun(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	var stdout bytes.Buffer
+	err := run([]string{"--apps", "3", "--concurrency", "2", "--duration", "100ms", "--upstream-latency", "0"}, &stdout, ioutil.Discard)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	g.Expect(stdout.String()).To(MatchRegexp(`requests\s+\d+ \(0 errors\)`))
+	g.Expect(stdout.String()).To(MatchRegexp(`p99\s+\d`))
+	g.Expect(stdout.String()).To(MatchRegexp(`upstream metrics-api\s+[1-9]`))
+}
+
+func TestPercentile(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	var latencies []time.Duration
+	for i := 1; i <= 100; i++ {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+
+	g.Expect(percentile(latencies, 50)).To(Equal(50 * time.Millisecond))
+	g.Expect(percentile(latencies, 99)).To(Equal(99 * time.Millisecond))
+	g.Expect(percentile(latencies, 100)).To(Equal(100 * time.Millisecond))
+	g.Expect(percentile(latencies[:1], 99)).To(Equal(time.Millisecond))
+	g.Expect(percentile(nil, 50)).To(BeZero())
+}
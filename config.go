@@ -1,32 +1,433 @@
 package main
 
 import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
 	"code.cloudfoundry.org/go-envstruct"
+	"code.cloudfoundry.org/metric-proxy/pkg/emitter"
+	"code.cloudfoundry.org/metric-proxy/pkg/logging"
+	"code.cloudfoundry.org/metric-proxy/pkg/tracing"
+	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
-// Config is the configuration for a LogCache.
+// Config is the configuration for a LogCache. Each setting may be given in
+// the optional YAML file named by CONFIG_FILE, using the yaml key, or in
+// the environment, which takes precedence.
 type Config struct {
-	Addr        string `env:"ADDR, required, report"`
-	AppSelector string `env:"APP_SELECTOR, required, report"`
-	Namespace   string `env:"NAMESPACE"`
-	NodeCacheTTL string `env:"NODE_CACHE_TTL"`
+	// ConfigFile is watched for changes every ConfigReloadInterval. See
+	// reloadableSettings for the settings that apply without a restart.
+	ConfigFile           string        `env:"CONFIG_FILE, report" yaml:"-"`
+	ConfigReloadInterval time.Duration `env:"CONFIG_RELOAD_INTERVAL, report" yaml:"-"`
+
+	Addr         string `env:"ADDR, report" yaml:"addr"`
+	AppSelector  string `env:"APP_SELECTOR, report" yaml:"app_selector"`
+	Namespace    string `env:"NAMESPACE" yaml:"namespace"`
+	NodeCacheTTL string `env:"NODE_CACHE_TTL" yaml:"node_cache_ttl"`
+
+	// Namespaces lists the namespaces to discover app pods in, overriding
+	// Namespace. With neither set, every namespace is served.
+	Namespaces []string `env:"NAMESPACES, report" yaml:"namespaces"`
+	// NamespaceSelector further limits discovery to namespaces matching this
+	// label selector.
+	NamespaceSelector string `env:"NAMESPACE_SELECTOR, report" yaml:"namespace_selector"`
 
 	// QueryTimeout sets the maximum allowed runtime for a single PromQL query.
 	// Smaller timeouts are recommended.
-	QueryTimeout int64 `env:"QUERY_TIMEOUT, report"`
+	QueryTimeout int64 `env:"QUERY_TIMEOUT, report" yaml:"query_timeout"`
+
+	// HTTPAddr serves the HTTP batch read API.
+	HTTPAddr string `env:"HTTP_ADDR, report" yaml:"http_addr"`
+
+	// SpaceLabel and OrgLabel are the pod labels holding an app's space and
+	// org guids, used by the aggregate API.
+	SpaceLabel string `env:"SPACE_LABEL, report" yaml:"space_label"`
+	OrgLabel   string `env:"ORG_LABEL, report" yaml:"org_label"`
+
+	// AppMetricsAddr, when set, serves every app instance's metrics for
+	// Prometheus at /app-metrics. The name annotations label them with the
+	// app, space and org names.
+	AppMetricsAddr string `env:"APP_METRICS_ADDR, report" yaml:"app_metrics_addr"`
+	// AppMetricsTimeout bounds reading every app for one scrape, and
+	// AppMetricsCacheTTL is how long scrapes reuse the last read.
+	AppMetricsTimeout   time.Duration `env:"APP_METRICS_TIMEOUT, report" yaml:"app_metrics_timeout"`
+	AppMetricsCacheTTL  time.Duration `env:"APP_METRICS_CACHE_TTL, report" yaml:"app_metrics_cache_ttl"`
+	AppNameAnnotation   string        `env:"APP_NAME_ANNOTATION, report" yaml:"app_name_annotation"`
+	SpaceNameAnnotation string        `env:"SPACE_NAME_ANNOTATION, report" yaml:"space_name_annotation"`
+	OrgNameAnnotation   string        `env:"ORG_NAME_ANNOTATION, report" yaml:"org_name_annotation"`
+
+	// EmitInterval is how often app envelopes are pushed to the loggregator
+	// agent at LoggregatorIngressAddr, FirehoseSyslogDrains and the apps' own
+	// drains. With none of them set, nothing is pushed.
+	EmitInterval time.Duration `env:"EMIT_INTERVAL, report" yaml:"emit_interval"`
+	// LoggregatorIngressAddr is a loggregator agent's v2 Ingress API, which
+	// requires mutual TLS with the CA, cert and key files.
+	LoggregatorIngressAddr string `env:"LOGGREGATOR_INGRESS_ADDR, report" yaml:"loggregator_ingress_addr"`
+	LoggregatorCAPath      string `env:"LOGGREGATOR_CA_PATH, report" yaml:"loggregator_ca_path"`
+	LoggregatorCertPath    string `env:"LOGGREGATOR_CERT_PATH, report" yaml:"loggregator_cert_path"`
+	LoggregatorKeyPath     string `env:"LOGGREGATOR_KEY_PATH, report" yaml:"loggregator_key_path"`
+	// FirehoseSyslogDrains are syslog:// or syslog-tls:// URLs that receive
+	// every app's envelopes, so they must belong to the operator.
+	FirehoseSyslogDrains []string `env:"FIREHOSE_SYSLOG_DRAINS, report" yaml:"firehose_syslog_drains,omitempty"`
+	// SyslogDrainAnnotation, when set, is the pod annotation listing an
+	// app's own drain URLs, which receive only that app's envelopes.
+	SyslogDrainAnnotation string `env:"SYSLOG_DRAIN_ANNOTATION, report" yaml:"syslog_drain_annotation"`
+	// SyslogCAPath verifies syslog-tls drains. The system roots are used
+	// when unset.
+	SyslogCAPath string `env:"SYSLOG_CA_PATH, report" yaml:"syslog_ca_path"`
+
+	// ScrapeInterval is how often app instances annotated with
+	// prometheus.io/scrape are scraped. Their metrics are read alongside
+	// their container metrics. Zero disables scraping.
+	ScrapeInterval time.Duration `env:"SCRAPE_INTERVAL, report" yaml:"scrape_interval"`
+	// ScrapeTimeout bounds each instance's scrape.
+	ScrapeTimeout time.Duration `env:"SCRAPE_TIMEOUT, report" yaml:"scrape_timeout"`
+
+	// HealthAddr serves the /healthz and /readyz HTTP probes.
+	HealthAddr string `env:"HEALTH_ADDR, report" yaml:"health_addr"`
+	// HealthCheckInterval is how often the Kubernetes APIs are checked.
+	HealthCheckInterval time.Duration `env:"HEALTH_CHECK_INTERVAL, report" yaml:"health_check_interval"`
+	// UnreachableTimeout is how long the Kubernetes APIs may be unreachable
+	// before the proxy reports NOT_SERVING.
+	UnreachableTimeout time.Duration `env:"UNREACHABLE_TIMEOUT, report" yaml:"unreachable_timeout"`
+
+	// LogLevel is one of debug, info, warn or error.
+	LogLevel string `env:"LOG_LEVEL, report" yaml:"log_level"`
+
+	// TracingExporter is "none" or "stdout".
+	TracingExporter string `env:"TRACING_EXPORTER, report" yaml:"tracing_exporter"`
+	// TracingSampleRatio is the fraction of new traces that are sampled.
+	// Incoming trace context always decides for itself.
+	TracingSampleRatio float64 `env:"TRACING_SAMPLE_RATIO, report" yaml:"tracing_sample_ratio"`
+
+	// MetricsPort serves metric-proxy's own Prometheus metrics.
+	MetricsPort int `env:"METRICS_PORT, report" yaml:"metrics_port"`
+	// DurationBuckets are the histogram buckets, in seconds, for gRPC
+	// request and upstream call durations.
+	DurationBuckets []float64 `env:"DURATION_BUCKETS, report" yaml:"duration_buckets"`
+
+	// MetricsCacheTTL is how long metrics-server responses are reused for
+	// identical Read requests. Zero disables the cache.
+	MetricsCacheTTL time.Duration `env:"METRICS_CACHE_TTL, report" yaml:"metrics_cache_ttl"`
+
+	// DrainTimeout bounds how long shutdown waits for in-flight requests.
+	DrainTimeout time.Duration `env:"DRAIN_TIMEOUT, report" yaml:"drain_timeout"`
+
+	// RateLimitClientRPS and RateLimitClientBurst limit Read calls per client
+	// identity. CAPI polls every app through one client, so keep this high.
+	// Zero disables the limit.
+	RateLimitClientRPS   float64 `env:"RATE_LIMIT_CLIENT_RPS, report" yaml:"rate_limit_client_rps"`
+	RateLimitClientBurst int     `env:"RATE_LIMIT_CLIENT_BURST, report" yaml:"rate_limit_client_burst"`
+	// RateLimitSourceRPS and RateLimitSourceBurst limit Read calls per
+	// source ID. Zero disables the limit.
+	RateLimitSourceRPS   float64 `env:"RATE_LIMIT_SOURCE_RPS, report" yaml:"rate_limit_source_rps"`
+	RateLimitSourceBurst int     `env:"RATE_LIMIT_SOURCE_BURST, report" yaml:"rate_limit_source_burst"`
+
+	// Synthetic serves made-up metrics for SyntheticApps apps of
+	// SyntheticInstances instances each instead of reading the Kubernetes
+	// APIs, for local development. The same seed makes up the same apps.
+	Synthetic          bool  `env:"SYNTHETIC, report" yaml:"synthetic"`
+	SyntheticApps      int   `env:"SYNTHETIC_APPS, report" yaml:"synthetic_apps"`
+	SyntheticInstances int   `env:"SYNTHETIC_INSTANCES, report" yaml:"synthetic_instances"`
+	SyntheticSeed      int64 `env:"SYNTHETIC_SEED, report" yaml:"synthetic_seed"`
+	// SyntheticCrashRate is the chance an instance crashes in any minute.
+	SyntheticCrashRate float64 `env:"SYNTHETIC_CRASH_RATE, report" yaml:"synthetic_crash_rate"`
+
+	// RecordDir, when set, receives a fixture of every metrics, pod and node
+	// summary response from the Kubernetes APIs. ReplayDir serves such
+	// fixtures instead of reading the Kubernetes APIs.
+	RecordDir string `env:"RECORD_DIR, report" yaml:"record_dir"`
+	ReplayDir string `env:"REPLAY_DIR, report" yaml:"replay_dir"`
 }
 
-// LoadConfig creates Config object from environment variables
+// LoadConfig creates Config object from the config file, if any, and
+// environment variables
 func LoadConfig() (*Config, error) {
+	return loadConfig(os.Getenv("CONFIG_FILE"))
+}
+
+func loadConfig(path string) (*Config, error) {
 	c := Config{
 		//Addr:         ":8080",
 		NodeCacheTTL: "30s",
 		QueryTimeout: 10,
+
+		ConfigFile:           path,
+		ConfigReloadInterval: 10 * time.Second,
+
+		HTTPAddr:            ":8082",
+		SpaceLabel:          "cloudfoundry.org/space_guid",
+		OrgLabel:            "cloudfoundry.org/org_guid",
+		AppNameAnnotation:   "cloudfoundry.org/application_name",
+		SpaceNameAnnotation: "cloudfoundry.org/space_name",
+		OrgNameAnnotation:   "cloudfoundry.org/org_name",
+		AppMetricsTimeout:   30 * time.Second,
+		AppMetricsCacheTTL:  10 * time.Second,
+		EmitInterval:        15 * time.Second,
+		ScrapeTimeout:       5 * time.Second,
+		HealthAddr:          ":8081",
+		HealthCheckInterval: 10 * time.Second,
+		UnreachableTimeout:  30 * time.Second,
+		DrainTimeout:        10 * time.Second,
+		MetricsCacheTTL:     time.Second,
+		LogLevel:            "info",
+		TracingExporter:     "none",
+		TracingSampleRatio:  1,
+		MetricsPort:         9090,
+		DurationBuckets:     []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+
+		RateLimitClientRPS:   100,
+		RateLimitClientBurst: 200,
+		RateLimitSourceRPS:   5,
+		RateLimitSourceBurst: 10,
+
+		SyntheticApps:      5,
+		SyntheticInstances: 2,
+		SyntheticSeed:      1,
+		SyntheticCrashRate: 0.05,
+	}
+
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read config file: %w", err)
+		}
+		if err := yaml.UnmarshalStrict(data, &c); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+		}
 	}
 
 	if err := envstruct.Load(&c); err != nil {
 		return nil, err
 	}
 
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
 	return &c, nil
 }
+
+// Validate checks every setting and reports all problems at once, each
+// named by its yaml key and environment variable.
+func (c *Config) Validate() error {
+	var problems []string
+	problem := func(key, format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("%s (%s): ", key, strings.ToUpper(key))+fmt.Sprintf(format, args...))
+	}
+
+	for key, addr := range map[string]string{"addr": c.Addr, "http_addr": c.HTTPAddr, "health_addr": c.HealthAddr} {
+		if err := validateAddr(addr); err != nil {
+			problem(key, "%s", err)
+		}
+	}
+	if c.AppMetricsAddr != "" {
+		if err := validateAddr(c.AppMetricsAddr); err != nil {
+			problem("app_metrics_addr", "%s", err)
+		}
+		if c.AppMetricsTimeout <= 0 {
+			problem("app_metrics_timeout", "must be positive, got %s", c.AppMetricsTimeout)
+		}
+		if c.AppMetricsCacheTTL < 0 {
+			problem("app_metrics_cache_ttl", "must not be negative, got %s", c.AppMetricsCacheTTL)
+		}
+	}
+	if c.LoggregatorIngressAddr != "" {
+		if err := validateAddr(c.LoggregatorIngressAddr); err != nil {
+			problem("loggregator_ingress_addr", "%s", err)
+		}
+		for key, path := range map[string]string{
+			"loggregator_ca_path":   c.LoggregatorCAPath,
+			"loggregator_cert_path": c.LoggregatorCertPath,
+			"loggregator_key_path":  c.LoggregatorKeyPath,
+		} {
+			if path == "" {
+				problem(key, "is required with loggregator_ingress_addr")
+			}
+		}
+		if c.LoggregatorCAPath != "" {
+			if _, err := loadCertPool(c.LoggregatorCAPath); err != nil {
+				problem("loggregator_ca_path", "%s", err)
+			}
+		}
+		if c.LoggregatorCertPath != "" && c.LoggregatorKeyPath != "" {
+			if _, err := tls.LoadX509KeyPair(c.LoggregatorCertPath, c.LoggregatorKeyPath); err != nil {
+				problem("loggregator_cert_path", "cannot load the certificate and key: %s", err)
+			}
+		}
+	}
+	if c.SyslogCAPath != "" {
+		if _, err := loadCertPool(c.SyslogCAPath); err != nil {
+			problem("syslog_ca_path", "%s", err)
+		}
+	}
+	for _, drain := range c.FirehoseSyslogDrains {
+		if _, err := emitter.NewSyslogDestination(drain, nil); err != nil {
+			problem("firehose_syslog_drains", "%s", err)
+		}
+	}
+	if c.SyslogDrainAnnotation != "" {
+		if errs := validation.IsQualifiedName(c.SyslogDrainAnnotation); len(errs) > 0 {
+			problem("syslog_drain_annotation", "%q is not a valid annotation key: %s", c.SyslogDrainAnnotation, strings.Join(errs, ", "))
+		}
+	}
+	if (c.LoggregatorIngressAddr != "" || len(c.FirehoseSyslogDrains) > 0 || c.SyslogDrainAnnotation != "") && c.EmitInterval <= 0 {
+		problem("emit_interval", "must be positive, got %s", c.EmitInterval)
+	}
+	if c.MetricsPort < 1 || c.MetricsPort > 65535 {
+		problem("metrics_port", "must be between 1 and 65535, got %d", c.MetricsPort)
+	}
+
+	for key, label := range map[string]string{"app_selector": c.AppSelector, "space_label": c.SpaceLabel, "org_label": c.OrgLabel} {
+		if label == "" {
+			problem(key, "is required")
+			continue
+		}
+		if errs := validation.IsQualifiedName(label); len(errs) > 0 {
+			problem(key, "%q is not a valid label key: %s", label, strings.Join(errs, ", "))
+		}
+	}
+	for key, annotation := range map[string]string{"app_name_annotation": c.AppNameAnnotation, "space_name_annotation": c.SpaceNameAnnotation, "org_name_annotation": c.OrgNameAnnotation} {
+		if errs := validation.IsQualifiedName(annotation); len(errs) > 0 {
+			problem(key, "%q is not a valid annotation key: %s", annotation, strings.Join(errs, ", "))
+		}
+	}
+	for _, namespace := range append([]string{c.Namespace}, c.Namespaces...) {
+		if namespace == "" {
+			continue
+		}
+		if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+			problem("namespaces", "%q is not a valid namespace name: %s", namespace, strings.Join(errs, ", "))
+		}
+	}
+	if _, err := labels.Parse(c.NamespaceSelector); err != nil {
+		problem("namespace_selector", "%s", err)
+	}
+
+	if ttl, err := time.ParseDuration(c.NodeCacheTTL); err != nil {
+		problem("node_cache_ttl", "%s, e.g. \"30s\"", err)
+	} else if ttl <= 0 {
+		problem("node_cache_ttl", "must be positive, got %s", c.NodeCacheTTL)
+	}
+	if c.QueryTimeout <= 0 {
+		problem("query_timeout", "must be a positive number of seconds, got %d", c.QueryTimeout)
+	}
+	for key, d := range map[string]time.Duration{
+		"config_reload_interval": c.ConfigReloadInterval,
+		"health_check_interval":  c.HealthCheckInterval,
+		"unreachable_timeout":    c.UnreachableTimeout,
+		"drain_timeout":          c.DrainTimeout,
+	} {
+		if d <= 0 {
+			problem(key, "must be positive, got %s", d)
+		}
+	}
+	if c.ScrapeInterval < 0 {
+		problem("scrape_interval", "must not be negative, got %s", c.ScrapeInterval)
+	}
+	if c.ScrapeInterval > 0 && (c.ScrapeTimeout <= 0 || c.ScrapeTimeout > c.ScrapeInterval) {
+		problem("scrape_timeout", "must be positive and at most scrape_interval, got %s", c.ScrapeTimeout)
+	}
+	if c.MetricsCacheTTL < 0 {
+		problem("metrics_cache_ttl", "must not be negative, got %s", c.MetricsCacheTTL)
+	}
+
+	if _, err := logging.ParseLevel(c.LogLevel); err != nil {
+		problem("log_level", "%s", err)
+	}
+	if c.TracingExporter != "" && c.TracingExporter != tracing.ExporterNone && c.TracingExporter != tracing.ExporterStdout {
+		problem("tracing_exporter", "must be %q or %q, got %q", tracing.ExporterNone, tracing.ExporterStdout, c.TracingExporter)
+	}
+	if c.TracingSampleRatio < 0 || c.TracingSampleRatio > 1 {
+		problem("tracing_sample_ratio", "must be between 0 and 1, got %v", c.TracingSampleRatio)
+	}
+	// Prometheus panics on the first observation if a bucket repeats.
+	increasing := len(c.DurationBuckets) > 0
+	for i := 1; i < len(c.DurationBuckets); i++ {
+		if c.DurationBuckets[i] <= c.DurationBuckets[i-1] {
+			increasing = false
+		}
+	}
+	if !increasing {
+		problem("duration_buckets", "must be in increasing order, got %v", c.DurationBuckets)
+	}
+
+	for _, limit := range []struct {
+		name  string
+		rps   float64
+		burst int
+	}{
+		{"client", c.RateLimitClientRPS, c.RateLimitClientBurst},
+		{"source", c.RateLimitSourceRPS, c.RateLimitSourceBurst},
+	} {
+		if limit.rps < 0 {
+			problem("rate_limit_"+limit.name+"_rps", "must not be negative, got %v", limit.rps)
+		}
+		if limit.rps > 0 && limit.burst < 1 {
+			problem("rate_limit_"+limit.name+"_burst", "must be at least 1 when the rate is set, got %d", limit.burst)
+		}
+	}
+
+	if c.Synthetic {
+		if c.SyntheticApps < 1 {
+			problem("synthetic_apps", "must be at least 1, got %d", c.SyntheticApps)
+		}
+		if c.SyntheticInstances < 1 {
+			problem("synthetic_instances", "must be at least 1, got %d", c.SyntheticInstances)
+		}
+		if c.SyntheticCrashRate < 0 || c.SyntheticCrashRate > 1 {
+			problem("synthetic_crash_rate", "must be between 0 and 1, got %v", c.SyntheticCrashRate)
+		}
+	}
+
+	if c.ReplayDir != "" {
+		if c.Synthetic {
+			problem("replay_dir", "cannot be used with synthetic")
+		}
+		if c.RecordDir != "" {
+			problem("replay_dir", "cannot be used with record_dir")
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+}
+
+func validateAddr(addr string) error {
+	if addr == "" {
+		return errors.New("is required")
+	}
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("must be host:port, e.g. \":8080\": %s", err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// WriteConfig writes the effective configuration as YAML that can be used
+// as a config file.
+func WriteConfig(w io.Writer, c *Config) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
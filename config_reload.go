@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/metric-proxy/pkg/logging"
+)
+
+// reloadableSettings are the yaml keys of settings applied to a running
+// proxy when the config file changes. Changes to any other setting are
+// logged and take effect on the next restart.
+var reloadableSettings = map[string]bool{
+	"log_level":               true,
+	"metrics_cache_ttl":       true,
+	"rate_limit_client_rps":   true,
+	"rate_limit_client_burst": true,
+	"rate_limit_source_rps":   true,
+	"rate_limit_source_burst": true,
+}
+
+// configWatcher polls the config file and calls apply with the running
+// config, updated with the reloadable settings, whenever the file's content
+// changes. The file is polled rather
+// than watched for events because Kubernetes updates mounted ConfigMaps by
+// swapping a symlink. A config that fails to load is logged and the current
+// one kept.
+type configWatcher struct {
+	loggr   *logging.Logger
+	current *Config
+	apply   func(*Config)
+	last    []byte
+}
+
+// newConfigWatcher remembers the config file's current content, so only
+// later changes are applied.
+func newConfigWatcher(loggr *logging.Logger, current *Config, apply func(*Config)) *configWatcher {
+	last, _ := ioutil.ReadFile(current.ConfigFile)
+	return &configWatcher{
+		loggr:   loggr,
+		current: current,
+		apply:   apply,
+		last:    last,
+	}
+}
+
+// Run polls every ConfigReloadInterval until stop is closed. It returns
+// immediately when there is no config file.
+func (w *configWatcher) Run(stop <-chan struct{}) {
+	if w.current.ConfigFile == "" {
+		return
+	}
+
+	ticker := time.NewTicker(w.current.ConfigReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			w.reload()
+		}
+	}
+}
+
+func (w *configWatcher) reload() {
+	path := w.current.ConfigFile
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		w.loggr.Error("cannot read config file", "path", path, "error", err)
+		return
+	}
+	if bytes.Equal(data, w.last) {
+		return
+	}
+	w.last = data
+
+	next, err := loadConfig(path)
+	if err != nil {
+		w.loggr.Error("ignoring invalid config file", "path", path, "error", err)
+		return
+	}
+
+	for _, setting := range restartRequired(w.current, next) {
+		w.loggr.Warn("config setting changed but requires a restart", "setting", setting)
+	}
+	w.loggr.Info("reloaded config file", "path", path)
+	w.current = withReloadableSettings(w.current, next)
+	w.apply(w.current)
+}
+
+// withReloadableSettings returns a copy of running with the reloadable
+// settings of next, so it keeps describing what the process runs with and
+// settings that need a restart are reported again on every reload.
+func withReloadableSettings(running, next *Config) *Config {
+	merged := *running
+	mergedValue, nextValue := reflect.ValueOf(&merged).Elem(), reflect.ValueOf(*next)
+	for i := 0; i < mergedValue.NumField(); i++ {
+		name := strings.Split(mergedValue.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		if reloadableSettings[name] {
+			mergedValue.Field(i).Set(nextValue.Field(i))
+		}
+	}
+	return &merged
+}
+
+// restartRequired returns the yaml keys of settings that differ between
+// the configs and can't be reloaded.
+func restartRequired(old, new *Config) []string {
+	var changed []string
+	oldValue, newValue := reflect.ValueOf(*old), reflect.ValueOf(*new)
+	for i := 0; i < oldValue.NumField(); i++ {
+		name := strings.Split(oldValue.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" || reloadableSettings[name] {
+			continue
+		}
+		if !reflect.DeepEqual(oldValue.Field(i).Interface(), newValue.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
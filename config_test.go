@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/metric-proxy/pkg/logging"
+	. "github.com/onsi/gomega"
+)
+
+func TestLoadConfig(t *testing.T) {
+	writeConfig := func(g *GomegaWithT, content string) string {
+		dir, err := ioutil.TempDir("", "metric-proxy-config")
+		g.Expect(err).ToNot(HaveOccurred())
+		path := filepath.Join(dir, "config.yml")
+		g.Expect(ioutil.WriteFile(path, []byte(content), 0600)).To(Succeed())
+		return path
+	}
+
+	t.Run("it reads settings from the config file", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		path := writeConfig(g, `
+addr: ":8080"
+app_selector: cloudfoundry.org/app_guid
+namespaces: [cf-workloads, iso-seg]
+metrics_cache_ttl: 5s
+rate_limit_source_rps: 2.5
+`)
+		defer os.RemoveAll(filepath.Dir(path))
+
+		cfg, err := loadConfig(path)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(cfg.Addr).To(Equal(":8080"))
+		g.Expect(cfg.AppSelector).To(Equal("cloudfoundry.org/app_guid"))
+		g.Expect(cfg.Namespaces).To(Equal([]string{"cf-workloads", "iso-seg"}))
+		g.Expect(cfg.MetricsCacheTTL).To(Equal(5 * time.Second))
+		g.Expect(cfg.RateLimitSourceRPS).To(Equal(2.5))
+		g.Expect(cfg.RateLimitSourceBurst).To(Equal(10))
+	})
+
+	t.Run("environment variables override the config file", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		path := writeConfig(g, "addr: \":8080\"\napp_selector: guid\nlog_level: info\n")
+		defer os.RemoveAll(filepath.Dir(path))
+		defer setenv("LOG_LEVEL", "debug")()
+
+		cfg, err := loadConfig(path)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(cfg.LogLevel).To(Equal("debug"))
+	})
+
+	t.Run("it rejects unknown keys", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		path := writeConfig(g, "addr: \":8080\"\napp_selector: guid\nmetrics_cache: 5s\n")
+		defer os.RemoveAll(filepath.Dir(path))
+
+		_, err := loadConfig(path)
+		g.Expect(err).To(MatchError(ContainSubstring("field metrics_cache not found")))
+	})
+
+	t.Run("it rejects invalid settings", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		path := writeConfig(g, "addr: \":8080\"\nlog_level: verbose\n")
+		defer os.RemoveAll(filepath.Dir(path))
+
+		_, err := loadConfig(path)
+		g.Expect(err).To(MatchError(ContainSubstring("app_selector (APP_SELECTOR): is required")))
+		g.Expect(err).To(MatchError(ContainSubstring(`log_level (LOG_LEVEL): unknown log level "verbose"`)))
+	})
+
+	t.Run("a missing config file is an error", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		_, err := loadConfig("/does/not/exist.yml")
+		g.Expect(err).To(MatchError(ContainSubstring("cannot read config file")))
+	})
+}
+
+func TestValidate(t *testing.T) {
+	validConfig := func(g *GomegaWithT) *Config {
+		defer setenv("ADDR", ":8080")()
+		defer setenv("APP_SELECTOR", "cloudfoundry.org/app_guid")()
+		cfg, err := loadConfig("")
+		g.Expect(err).ToNot(HaveOccurred())
+		return cfg
+	}
+
+	t.Run("the defaults are valid", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		g.Expect(validConfig(g).Validate()).To(Succeed())
+	})
+
+	t.Run("it reports every problem", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		cfg := validConfig(g)
+		cfg.Addr = "8080"
+		cfg.HTTPAddr = ":http-api"
+		cfg.AppMetricsAddr = "app-metrics"
+		cfg.AppMetricsTimeout = 0
+		cfg.OrgNameAnnotation = "org name"
+		cfg.Namespaces = []string{"cf-workloads", "Not_A_Namespace"}
+		cfg.NamespaceSelector = "env in (prod"
+		cfg.NodeCacheTTL = "30"
+		cfg.QueryTimeout = 0
+		cfg.UnreachableTimeout = 0
+		cfg.DurationBuckets = []float64{1, 0.5}
+		cfg.RateLimitSourceBurst = 0
+		cfg.LoggregatorIngressAddr = "localhost:3458"
+		cfg.LoggregatorCertPath = "cert.pem"
+		cfg.LoggregatorKeyPath = "key.pem"
+		cfg.FirehoseSyslogDrains = []string{"https://drain.example.com"}
+		cfg.SyslogDrainAnnotation = "syslog drains"
+		cfg.SyslogCAPath = "missing-ca.pem"
+		cfg.EmitInterval = 0
+		cfg.ScrapeInterval = 10 * time.Second
+		cfg.ScrapeTimeout = time.Minute
+
+		err := cfg.Validate()
+		g.Expect(err).To(HaveOccurred())
+		for _, problem := range []string{
+			`addr (ADDR): must be host:port`,
+			`http_addr (HTTP_ADDR): invalid port "http-api"`,
+			`app_metrics_addr (APP_METRICS_ADDR): must be host:port`,
+			`app_metrics_timeout (APP_METRICS_TIMEOUT): must be positive, got 0s`,
+			`org_name_annotation (ORG_NAME_ANNOTATION): "org name" is not a valid annotation key`,
+			`namespaces (NAMESPACES): "Not_A_Namespace" is not a valid namespace name`,
+			`namespace_selector (NAMESPACE_SELECTOR): unable to parse requirement`,
+			`node_cache_ttl (NODE_CACHE_TTL): time: missing unit in duration`,
+			`query_timeout (QUERY_TIMEOUT): must be a positive number of seconds, got 0`,
+			`unreachable_timeout (UNREACHABLE_TIMEOUT): must be positive, got 0s`,
+			`duration_buckets (DURATION_BUCKETS): must be in increasing order, got [1 0.5]`,
+			`rate_limit_source_burst (RATE_LIMIT_SOURCE_BURST): must be at least 1 when the rate is set, got 0`,
+			`loggregator_ca_path (LOGGREGATOR_CA_PATH): is required with loggregator_ingress_addr`,
+			`loggregator_cert_path (LOGGREGATOR_CERT_PATH): cannot load the certificate and key: open cert.pem: no such file or directory`,
+			`syslog_ca_path (SYSLOG_CA_PATH): cannot read CA file: open missing-ca.pem: no such file or directory`,
+			`firehose_syslog_drains (FIREHOSE_SYSLOG_DRAINS): syslog drain "https://drain.example.com" has no port`,
+			`syslog_drain_annotation (SYSLOG_DRAIN_ANNOTATION): "syslog drains" is not a valid annotation key`,
+			`emit_interval (EMIT_INTERVAL): must be positive, got 0s`,
+			`scrape_timeout (SCRAPE_TIMEOUT): must be positive and at most scrape_interval, got 1m0s`,
+		} {
+			g.Expect(err.Error()).To(ContainSubstring(problem))
+		}
+		g.Expect(err.Error()).ToNot(ContainSubstring("cf-workloads"))
+	})
+
+	t.Run("it rejects repeated duration buckets", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		defer setenv("ADDR", ":8080")()
+		defer setenv("APP_SELECTOR", "cloudfoundry.org/app_guid")()
+		defer setenv("DURATION_BUCKETS", "1,1")()
+
+		_, err := loadConfig("")
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring(`duration_buckets (DURATION_BUCKETS): must be in increasing order, got [1 1]`))
+	})
+
+	t.Run("replay excludes the other backends", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		cfg := validConfig(g)
+		cfg.ReplayDir = "fixtures"
+		g.Expect(cfg.Validate()).To(Succeed())
+
+		cfg.Synthetic = true
+		cfg.RecordDir = "recorded"
+		err := cfg.Validate()
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(err.Error()).To(ContainSubstring("replay_dir (REPLAY_DIR): cannot be used with synthetic"))
+		g.Expect(err.Error()).To(ContainSubstring("replay_dir (REPLAY_DIR): cannot be used with record_dir"))
+	})
+
+	t.Run("the effective config can be loaded as a config file", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		cfg := validConfig(g)
+		cfg.MetricsCacheTTL = 5 * time.Second
+		cfg.Namespaces = []string{"cf-workloads", "iso-seg"}
+
+		var buf bytes.Buffer
+		g.Expect(WriteConfig(&buf, cfg)).To(Succeed())
+		g.Expect(buf.String()).To(ContainSubstring("metrics_cache_ttl: 5s\n"))
+
+		dir, err := ioutil.TempDir("", "metric-proxy-config")
+		g.Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "config.yml")
+		g.Expect(ioutil.WriteFile(path, buf.Bytes(), 0600)).To(Succeed())
+
+		loaded, err := loadConfig(path)
+		g.Expect(err).ToNot(HaveOccurred())
+		loaded.ConfigFile = ""
+		g.Expect(loaded).To(Equal(cfg))
+	})
+}
+
+func TestConfigWatcher(t *testing.T) {
+	t.Run("it applies changes and warns about settings that need a restart", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		dir, err := ioutil.TempDir("", "metric-proxy-config")
+		g.Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "config.yml")
+		g.Expect(ioutil.WriteFile(path, []byte("addr: \":8080\"\napp_selector: guid\n"), 0600)).To(Succeed())
+
+		cfg, err := loadConfig(path)
+		g.Expect(err).ToNot(HaveOccurred())
+		cfg.ConfigReloadInterval = 10 * time.Millisecond
+
+		var buf bytes.Buffer
+		applied := make(chan *Config, 1)
+		stop := make(chan struct{})
+		defer close(stop)
+		watcher := newConfigWatcher(logging.New(&buf, logging.Info), cfg, func(next *Config) { applied <- next })
+		go watcher.Run(stop)
+
+		g.Expect(ioutil.WriteFile(path, []byte("addr: \":9090\"\napp_selector: guid\nmetrics_cache_ttl: 0s\n"), 0600)).To(Succeed())
+
+		var next *Config
+		g.Eventually(applied).Should(Receive(&next))
+		g.Expect(next.MetricsCacheTTL).To(BeZero())
+		g.Expect(buf.String()).To(ContainSubstring(`"setting":"addr"`))
+		g.Expect(next.Addr).To(Equal(":8080"))
+		g.Expect(buf.String()).ToNot(ContainSubstring(`"setting":"metrics_cache_ttl"`))
+
+		buf.Reset()
+		g.Expect(ioutil.WriteFile(path, []byte("addr: \":9090\"\napp_selector: guid\nmetrics_cache_ttl: 1s\n"), 0600)).To(Succeed())
+
+		g.Eventually(applied).Should(Receive(&next))
+		g.Expect(next.MetricsCacheTTL).To(Equal(time.Second))
+		g.Expect(buf.String()).To(ContainSubstring(`"setting":"addr"`))
+	})
+}
+
+func setenv(key, value string) func() {
+	os.Setenv(key, value)
+	return func() { os.Unsetenv(key) }
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/log-cache/pkg/client"
+	"code.cloudfoundry.org/metric-proxy/pkg/fakecluster"
+	"code.cloudfoundry.org/metric-proxy/pkg/logging"
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics"
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics/diskusage"
+	"code.cloudfoundry.org/metric-proxy/pkg/selfmetrics"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+func TestEndToEnd(t *testing.T) {
+	appPod := func(name string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "cf-workloads",
+				Name:      name,
+				Labels:    map[string]string{"cloudfoundry.org/app_guid": "app-guid"},
+			},
+			Spec: corev1.PodSpec{
+				NodeName: "node-1",
+				Containers: []corev1.Container{
+					{
+						Name: "opi",
+						Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{
+							corev1.ResourceMemory:           resource.MustParse("1Gi"),
+							corev1.ResourceEphemeralStorage: resource.MustParse("2Gi"),
+						}},
+					},
+					{Name: "istio-proxy"},
+				},
+			},
+			Status: corev1.PodStatus{
+				Phase: corev1.PodRunning,
+				ContainerStatuses: []corev1.ContainerStatus{{
+					Name:  "opi",
+					Ready: true,
+					State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+				}},
+			},
+		}
+	}
+
+	t.Run("it reads an app's metrics from the Kubernetes APIs", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		cluster := fakecluster.New()
+		defer cluster.Close()
+		cluster.AddPod(appPod("app-guid-0"))
+		cluster.AddPod(&corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Namespace: "other",
+			Name:      "other-app-0",
+			Labels:    map[string]string{"cloudfoundry.org/app_guid": "app-guid"},
+		}})
+		cluster.SetPodMetrics(&v1beta1.PodMetrics{
+			ObjectMeta: appPod("app-guid-0").ObjectMeta,
+			Containers: []v1beta1.ContainerMetrics{
+				{Name: "opi", Usage: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("420m"),
+					corev1.ResourceMemory: resource.MustParse("27Mi"),
+				}},
+				{Name: "istio-proxy", Usage: corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("100m"),
+				}},
+			},
+		})
+		cluster.SetNodeSummary("node-1", diskusage.NodeDiskUsage{Pods: []diskusage.PodDiskUsage{{
+			PodRef: diskusage.PodRef{Name: "app-guid-0", Namespace: "cf-workloads"},
+			Containers: []diskusage.ContainerDiskUsage{
+				{Name: "opi", RootFS: diskusage.DiskUsage{UsedBytes: 1000}, Logs: diskusage.DiskUsage{UsedBytes: 24}},
+				{Name: "istio-proxy", RootFS: diskusage.DiskUsage{UsedBytes: 5000}},
+			},
+		}}})
+		cluster.SetNodeIOStats("node-1", diskusage.NodeIOStats{Pods: []diskusage.PodIOStats{
+			{
+				PodRef: diskusage.PodRef{Name: "app-guid-0", Namespace: "cf-workloads"},
+				Containers: []diskusage.ContainerIOStats{
+					{Name: "opi", ReadBytes: 4096, WriteBytes: 512},
+					{Name: "istio-proxy", ReadBytes: 9999, WriteBytes: 9999},
+				},
+			},
+			{
+				PodRef:     diskusage.PodRef{Name: "unrelated-0", Namespace: "cf-workloads"},
+				Containers: []diskusage.ContainerIOStats{{Name: "opi", ReadBytes: 9999, WriteBytes: 9999}},
+			},
+		}})
+
+		logCache, stop := startEndToEndServer(g, cluster)
+		defer stop()
+
+		var envelopes []*loggregator_v2.Envelope
+		g.Eventually(func() []*loggregator_v2.Envelope {
+			envelopes, _ = logCache.Read(context.Background(), "app-guid", time.Unix(0, 0))
+			return envelopes
+		}, 5*time.Second).ShouldNot(BeEmpty())
+
+		g.Expect(gauges(envelopes, "0")).To(And(
+			HaveKeyWithValue("cpu", BeNumerically("~", 42, 0.001)),
+			HaveKeyWithValue("memory", BeNumerically("==", 27*1024*1024)),
+			HaveKeyWithValue("disk", BeNumerically("==", 1024)),
+			HaveKeyWithValue("memory_quota", BeNumerically("==", 1024*1024*1024)),
+			HaveKeyWithValue("disk_quota", BeNumerically("==", 2*1024*1024*1024)),
+			HaveKeyWithValue("state", BeNumerically("==", metrics.InstanceStateRunning)),
+		))
+		g.Expect(counters(envelopes, "0")).To(And(
+			HaveKeyWithValue(metrics.DiskReadBytesCounter, BeEquivalentTo(4096)),
+			HaveKeyWithValue(metrics.DiskWriteBytesCounter, BeEquivalentTo(512)),
+		))
+
+		g.Expect(cluster.Requests()).To(ContainElement(
+			"GET /apis/metrics.k8s.io/v1beta1/namespaces/cf-workloads/pods?labelSelector=cloudfoundry.org%2Fapp_guid%3Dapp-guid&timeout=10s&timeoutSeconds=10",
+		))
+		g.Expect(cluster.Requests()).To(ContainElement("GET /api/v1/nodes/node-1/proxy/stats/summary"))
+		g.Expect(cluster.Requests()).To(ContainElement("GET /api/v1/nodes/node-1/proxy/metrics/cadvisor"))
+		g.Expect(cluster.Requests()).ToNot(ContainElement(ContainSubstring("namespaces/other")))
+	})
+
+	t.Run("it serves synthetic metrics without a cluster", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		var guids []string
+		logCache, stop := serveEndToEnd(g, func(cfg *Config, _ *selfmetrics.Metrics, _ *metrics.MetricsCache, _ <-chan struct{}) *backend {
+			cfg.SyntheticCrashRate = 0
+			b := newSyntheticBackend(cfg, quietLogger())
+			guids, _ = b.sourceLister(map[string]string{})
+			return b
+		})
+		defer stop()
+		g.Expect(guids).To(HaveLen(5))
+
+		envelopes, err := logCache.Read(context.Background(), guids[0], time.Unix(0, 0))
+		g.Expect(err).ToNot(HaveOccurred())
+		for _, instanceID := range []string{"0", "1"} {
+			g.Expect(gauges(envelopes, instanceID)).To(And(
+				HaveKeyWithValue("cpu", BeNumerically(">", 0)),
+				HaveKeyWithValue("memory", BeNumerically(">", 0)),
+				HaveKeyWithValue("disk", BeNumerically(">", 0)),
+				HaveKeyWithValue("state", BeNumerically("==", metrics.InstanceStateRunning)),
+			))
+		}
+	})
+
+	t.Run("it reports instances the informer sees before metrics-server does", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		cluster := fakecluster.New()
+		defer cluster.Close()
+		cluster.AddPod(appPod("app-guid-0"))
+
+		logCache, stop := startEndToEndServer(g, cluster)
+		defer stop()
+
+		read := func() map[string]float64 {
+			envelopes, _ := logCache.Read(context.Background(), "app-guid", time.Unix(0, 0))
+			return gauges(envelopes, "1")
+		}
+		g.Eventually(read, 5*time.Second).Should(BeEmpty())
+
+		cluster.AddPod(appPod("app-guid-1"))
+		g.Eventually(read, 5*time.Second).Should(And(
+			HaveKeyWithValue("state", BeNumerically("==", metrics.InstanceStateRunning)),
+			HaveKeyWithValue("cpu", BeZero()),
+		))
+	})
+}
+
+// startEndToEndServer serves metric-proxy, wired to cluster, on a local
+// port and returns a log-cache client for it.
+func startEndToEndServer(g *GomegaWithT, cluster *fakecluster.Cluster) (*client.Client, func()) {
+	defer setenv("NAMESPACES", "cf-workloads")()
+
+	return serveEndToEnd(g, func(cfg *Config, selfMetrics *selfmetrics.Metrics, metricsCache *metrics.MetricsCache, stop <-chan struct{}) *backend {
+		b, err := newKubernetesBackend(cfg, cluster.RESTConfig(), quietLogger(), selfMetrics, metricsCache, stop)
+		g.Expect(err).ToNot(HaveOccurred())
+		return b
+	})
+}
+
+func serveEndToEnd(g *GomegaWithT, newBackend func(*Config, *selfmetrics.Metrics, *metrics.MetricsCache, <-chan struct{}) *backend) (*client.Client, func()) {
+	defer setenv("ADDR", "127.0.0.1:0")()
+	defer setenv("APP_SELECTOR", "cloudfoundry.org/app_guid")()
+	cfg, err := loadConfig("")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	registry := prometheus.NewRegistry()
+	selfMetrics := selfmetrics.New(registry, cfg.DurationBuckets)
+	metricsCache := metrics.NewMetricsCache(selfMetrics, cache.NewExpiring(), cfg.MetricsCacheTTL)
+	stop := make(chan struct{})
+	srv := newServer(cfg, newBackend(cfg, selfMetrics, metricsCache, stop), quietLogger(), registry, selfMetrics, metricsCache, nil, nil)
+
+	lis, err := net.Listen("tcp", cfg.Addr)
+	g.Expect(err).ToNot(HaveOccurred())
+	go srv.grpc.Serve(lis)
+
+	return client.NewClient(lis.Addr().String(), client.WithViaGRPC(grpc.WithInsecure())), func() {
+		srv.grpc.Stop()
+		close(stop)
+	}
+}
+
+func quietLogger() *logging.Logger {
+	return logging.New(ioutil.Discard, logging.Error)
+}
+
+// gauges flattens the gauge values of one instance's envelopes.
+func gauges(envelopes []*loggregator_v2.Envelope, instanceID string) map[string]float64 {
+	values := map[string]float64{}
+	for _, e := range envelopes {
+		if e.InstanceId != instanceID {
+			continue
+		}
+		for name, value := range e.GetGauge().GetMetrics() {
+			values[name] = value.Value
+		}
+	}
+	return values
+}
+
+// counters flattens the counter totals of one instance's envelopes.
+func counters(envelopes []*loggregator_v2.Envelope, instanceID string) map[string]uint64 {
+	totals := map[string]uint64{}
+	for _, e := range envelopes {
+		if e.InstanceId != instanceID || e.GetCounter() == nil {
+			continue
+		}
+		totals[e.GetCounter().GetName()] = e.GetCounter().GetTotal()
+	}
+	return totals
+}
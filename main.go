@@ -2,141 +2,564 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"io/ioutil"
 	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"code.cloudfoundry.org/go-envstruct"
-	"code.cloudfoundry.org/log-cache/pkg/rpc/logcache_v1"
+	"code.cloudfoundry.org/metric-proxy/pkg/discovery"
+	"code.cloudfoundry.org/metric-proxy/pkg/emitter"
+	"code.cloudfoundry.org/metric-proxy/pkg/health"
+	"code.cloudfoundry.org/metric-proxy/pkg/logging"
 	"code.cloudfoundry.org/metric-proxy/pkg/metrics"
 	"code.cloudfoundry.org/metric-proxy/pkg/metrics/diskusage"
+	"code.cloudfoundry.org/metric-proxy/pkg/ratelimit"
+	"code.cloudfoundry.org/metric-proxy/pkg/selfmetrics"
+	"code.cloudfoundry.org/metric-proxy/pkg/tracing"
 
-	metricRegistry "code.cloudfoundry.org/go-metric-registry"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
 	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
 	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
 	"k8s.io/metrics/pkg/client/clientset/versioned"
 )
 
-var (
-	version          = "dev-build"
-	requestDurations metricRegistry.Histogram
-)
+var version = "dev-build"
 
 func main() {
-	loggr := log.New(os.Stderr, "", log.LstdFlags)
-	loggr.Printf("starting metric-proxy %s...\n", version)
-	defer loggr.Println("exiting metric-proxy...")
+	checkConfig := flag.Bool("check-config", false, "validate the configuration, print it as YAML and exit")
+	flag.Parse()
+
+	if *checkConfig {
+		os.Exit(runCheckConfig(os.Stdout, os.Stderr))
+	}
+
+	loggr := logging.New(os.Stderr, logging.Info)
+	loggr.Info("starting metric-proxy", "version", version)
 
 	cfg, err := LoadConfig()
 	if err != nil {
-		loggr.Fatalf("invalid configuration: %s", err)
+		loggr.Fatal("invalid configuration", "error", err)
 	}
 
-	err = envstruct.WriteReport(cfg)
+	level, err := logging.ParseLevel(cfg.LogLevel)
 	if err != nil {
-		loggr.Fatalf("cannot report envstruct config: %v", err)
+		loggr.Fatal("invalid configuration", "error", err)
 	}
+	loggr = logging.New(os.Stderr, level)
+	defer loggr.Info("exiting metric-proxy")
 
-	fetcher, err := createMetricsFetcher(cfg)
+	tracerProvider, err := tracing.NewTracerProvider(cfg.TracingExporter, cfg.TracingSampleRatio, os.Stdout, version)
 	if err != nil {
-		loggr.Fatalf("cannot initialize metric fetcher: %v", err)
+		loggr.Fatal("cannot initialize tracing", "error", err)
+	}
+	if tracerProvider != nil {
+		otel.SetTracerProvider(tracerProvider)
 	}
 
-	diskUsageFetcher, err := createDiskUsageFetcher(cfg)
+	err = envstruct.WriteReport(cfg)
 	if err != nil {
-		loggr.Fatalf("cannot initialize disk usage fetcher: %v", err)
+		loggr.Fatal("cannot report envstruct config", "error", err)
 	}
 
-	c := metrics.NewProxy(loggr, fetcher, diskUsageFetcher)
-	setupAndStartMetricServer(loggr)
+	stop := make(chan struct{})
 
-	s := grpc.NewServer(
-		grpc.UnaryInterceptor(requestTimer),
-	)
-	logcache_v1.RegisterEgressServer(s, c)
+	registry := prometheus.NewRegistry()
+	selfMetrics := selfmetrics.New(registry, cfg.DurationBuckets)
+
+	// The cache is created even when disabled so a config reload can
+	// enable it.
+	metricsCache := metrics.NewMetricsCache(selfMetrics, cache.NewExpiring(), cfg.MetricsCacheTTL)
+
+	var b *backend
+	switch {
+	case cfg.Synthetic:
+		b = newSyntheticBackend(cfg, loggr)
+	case cfg.ReplayDir != "":
+		b, err = newReplayBackend(cfg, loggr, selfMetrics)
+		if err != nil {
+			loggr.Fatal("cannot initialize metric-proxy", "error", err)
+		}
+	default:
+		restConfig, err := rest.InClusterConfig()
+		if err != nil {
+			loggr.Fatal("cannot load in-cluster config", "error", err)
+		}
+		restConfig.Timeout = kubernetesTimeout
+
+		b, err = newKubernetesBackend(cfg, restConfig, loggr, selfMetrics, metricsCache, stop)
+		if err != nil {
+			loggr.Fatal("cannot initialize metric-proxy", "error", err)
+		}
+	}
+	destinations, appDrains, closeDestinations, err := createEmitDestinations(cfg, loggr, b.podLister)
+	if err != nil {
+		loggr.Fatal("cannot initialize emitter", "error", err)
+	}
+	defer closeDestinations()
+
+	srv := newServer(cfg, b, loggr, registry, selfMetrics, metricsCache, destinations, appDrains)
+	if srv.emitter != nil {
+		loggr.Info("emitting app metrics", "interval", cfg.EmitInterval, "destinations", len(destinations), "app_drains", appDrains != nil)
+		go srv.emitter.Run(cfg.EmitInterval, stop)
+	}
+	if srv.scraper != nil {
+		loggr.Info("scraping app metrics", "interval", cfg.ScrapeInterval)
+		go srv.scraper.Run(cfg.ScrapeInterval, stop)
+	}
+
+	go srv.checker.Run(cfg.HealthCheckInterval, stop)
+	healthServer := startHTTPServer(loggr, "health", cfg.HealthAddr, srv.checker.Handler(), defaultWriteTimeout)
+
+	configWatcher := newConfigWatcher(loggr, cfg, func(next *Config) {
+		level, _ := logging.ParseLevel(next.LogLevel)
+		loggr.SetLevel(level)
+		srv.metricsCache.SetTTL(next.MetricsCacheTTL)
+		srv.limiter.SetLimits(
+			ratelimit.Limit{Rate: next.RateLimitClientRPS, Burst: next.RateLimitClientBurst},
+			ratelimit.Limit{Rate: next.RateLimitSourceRPS, Burst: next.RateLimitSourceBurst},
+		)
+	})
+	go configWatcher.Run(stop)
+	metricServer := setupAndStartMetricServer(loggr, registry, cfg.MetricsPort)
+	httpServers := map[string]*http.Server{
+		"health":  healthServer,
+		"metrics": metricServer,
+		"api":     startHTTPServer(loggr, "api", cfg.HTTPAddr, srv.api, defaultWriteTimeout),
+	}
+	if cfg.AppMetricsAddr != "" {
+		httpServers["app-metrics"] = startHTTPServer(loggr, "app-metrics", cfg.AppMetricsAddr, srv.appMetrics, cfg.AppMetricsTimeout+defaultWriteTimeout)
+	}
 
 	lis, err := net.Listen("tcp", cfg.Addr)
 	if err != nil {
-		loggr.Fatalf("failed to listen: %v", err)
+		loggr.Fatal("failed to listen", "addr", cfg.Addr, "error", err)
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.grpc.Serve(lis)
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	select {
+	case sig := <-signals:
+		loggr.Info("shutting down", "signal", sig)
+	case err := <-serveErr:
+		loggr.Fatal("grpc server stopped", "error", err)
+	}
+
+	srv.checker.Shutdown()
+	drainGRPCServer(loggr, srv.grpc, cfg.DrainTimeout)
+	close(stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DrainTimeout)
+	defer cancel()
+	for name, server := range httpServers {
+		if err := server.Shutdown(ctx); err != nil {
+			loggr.Error("failed to shut down server", "server", name, "error", err)
+		}
+	}
+	if tracerProvider != nil {
+		if err := tracerProvider.Shutdown(ctx); err != nil {
+			loggr.Error("failed to flush traces", "error", err)
+		}
+	}
+}
+
+// runCheckConfig loads and validates the configuration, printing it to
+// stdout or the problems to stderr, and returns the exit code.
+func runCheckConfig(stdout, stderr io.Writer) int {
+	cfg, err := LoadConfig()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+
+	if err := WriteConfig(stdout, cfg); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
 	}
-	panic(s.Serve(lis))
+	return 0
 }
 
-func setupAndStartMetricServer(loggr *log.Logger) {
-	m := metricRegistry.NewRegistry(
-		loggr,
-		metricRegistry.WithPublicServer(
-			9090,
-		),
-	)
+// drainGRPCServer stops accepting connections and waits for in-flight
+// requests to finish, forcing them closed after the drain timeout.
+func drainGRPCServer(loggr *logging.Logger, s *grpc.Server, drainTimeout time.Duration) {
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
 
-	requestDurations = m.NewHistogram(
-		"request_duration_seconds",
-		"gPRC request duration distribution",
-		[]float64{0.005, 2, 12},
-	)
+	select {
+	case <-stopped:
+	case <-time.After(drainTimeout):
+		loggr.Warn("requests still in flight, closing connections", "drain_timeout", drainTimeout)
+		s.Stop()
+	}
 }
 
-func requestTimer(ctx context.Context,
-	req interface{},
-	info *grpc.UnaryServerInfo,
-	handler grpc.UnaryHandler) (interface{}, error) {
-	start := time.Now()
+// defaultWriteTimeout bounds HTTP responses. The app-metrics server adds it
+// to APP_METRICS_TIMEOUT, so a slow scrape fails with an error rather than a
+// truncated response.
+const defaultWriteTimeout = 5 * time.Second
 
-	h, err := handler(ctx, req)
+// kubernetesTimeout bounds every Kubernetes API request except the pod
+// informer's watches, so that a hung apiserver fails requests and health
+// checks instead of blocking them.
+const kubernetesTimeout = 30 * time.Second
 
-	d := time.Since(start)
+func startHTTPServer(loggr *logging.Logger, name, addr string, handler http.Handler, writeTimeout time.Duration) *http.Server {
+	server := &http.Server{
+		Addr:         addr,
+		Handler:      handler,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: writeTimeout,
+	}
 
-	requestDurations.Observe(d.Seconds())
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			loggr.Fatal("server stopped", "server", name, "error", err)
+		}
+	}()
 
-	return h, err
+	return server
 }
 
-func createMetricsFetcher(cfg *Config) (metrics.MetricsFetcherFn, error) {
-	restConfig, err := rest.InClusterConfig()
+func setupAndStartMetricServer(loggr *logging.Logger, registry *prometheus.Registry, port int) *http.Server {
+	registry.MustRegister(prometheus.NewGoCollector())
+	registry.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{
+		Registry: registry,
+	}))
+
+	return startHTTPServer(loggr, "metrics", fmt.Sprintf(":%d", port), mux, defaultWriteTimeout)
+}
+
+// chainUnaryInterceptors runs interceptors in order, the first being the
+// outermost.
+func chainUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chained
+			chained = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return chained(ctx, req)
+	}
+}
+
+// chainHTTPMiddleware runs middleware in order, the first being the
+// outermost, like chainUnaryInterceptors.
+func chainHTTPMiddleware(middleware ...func(http.Handler) http.Handler) func(http.Handler) http.Handler {
+	return func(handler http.Handler) http.Handler {
+		for i := len(middleware) - 1; i >= 0; i-- {
+			handler = middleware[i](handler)
+		}
+		return handler
+	}
+}
+
+// createEmitDestinations connects to the loggregator agent and firehose
+// syslog drains in cfg, and finds apps' own drains with podLister when
+// SyslogDrainAnnotation is set. The returned func closes the agent
+// connection.
+func createEmitDestinations(cfg *Config, loggr *logging.Logger, podLister metrics.PodListerFn) (map[string]emitter.Destination, emitter.AppDrainsFn, func(), error) {
+	destinations := map[string]emitter.Destination{}
+	closeAll := func() {}
+
+	var syslogTLSConfig *tls.Config
+	if cfg.SyslogCAPath != "" {
+		pool, err := loadCertPool(cfg.SyslogCAPath)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		syslogTLSConfig = &tls.Config{RootCAs: pool}
+	}
+	for _, drain := range cfg.FirehoseSyslogDrains {
+		d, err := emitter.NewSyslogDestination(drain, syslogTLSConfig)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		destinations[drain] = d
+	}
+
+	var appDrains emitter.AppDrainsFn
+	if cfg.SyslogDrainAnnotation != "" {
+		appDrains = emitter.AnnotatedDrains(loggr, podLister, cfg.SyslogDrainAnnotation, syslogTLSConfig)
+	}
+
+	if cfg.LoggregatorIngressAddr != "" {
+		pool, err := loadCertPool(cfg.LoggregatorCAPath)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.LoggregatorCertPath, cfg.LoggregatorKeyPath)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("cannot load loggregator client certificate: %w", err)
+		}
+
+		// Loggregator agents are issued certificates for "metron".
+		conn, err := grpc.Dial(cfg.LoggregatorIngressAddr, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{
+			ServerName:   "metron",
+			Certificates: []tls.Certificate{cert},
+			RootCAs:      pool,
+		})))
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		destinations["loggregator"] = emitter.NewIngressDestination(conn)
+		closeAll = func() { conn.Close() }
+	}
+
+	return destinations, appDrains, closeAll, nil
+}
+
+func loadCertPool(caPath string) (*x509.CertPool, error) {
+	ca, err := ioutil.ReadFile(caPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates found in CA file %s", caPath)
+	}
+	return pool, nil
+}
+
+func createMetricsFetcher(cfg *Config, restConfig *rest.Config, resolver *discovery.Resolver) (metrics.MetricsFetcherFn, error) {
+	c, err := versioned.NewForConfig(restConfig)
 	if err != nil {
 		return nil, err
 	}
 
+	return func(_ context.Context, guid string) (*v1beta1.PodMetricsList, error) {
+		byNamespace, err := resolver.Namespaces(guid)
+		if err != nil {
+			return nil, err
+		}
+
+		list := &v1beta1.PodMetricsList{}
+		for namespace := range byNamespace {
+			podMetrics, err := c.MetricsV1beta1().PodMetricses(namespace).List(v1.ListOptions{
+				LabelSelector:  fmt.Sprintf("%s=%s", cfg.AppSelector, guid),
+				TimeoutSeconds: &cfg.QueryTimeout,
+			})
+			if err != nil {
+				return nil, err
+			}
+			list.Items = append(list.Items, podMetrics.Items...)
+		}
+		return list, nil
+	}, nil
+}
+
+func createBatchMetricsFetcher(cfg *Config, restConfig *rest.Config, resolver *discovery.Resolver) (metrics.BatchMetricsFetcherFn, error) {
 	c, err := versioned.NewForConfig(restConfig)
 	if err != nil {
 		return nil, err
 	}
 
-	return func(guid string) (*v1beta1.PodMetricsList, error) {
-		return c.MetricsV1beta1().PodMetricses(cfg.Namespace).List(v1.ListOptions{
-			LabelSelector:  fmt.Sprintf("%s=%s", cfg.AppSelector, guid),
-			TimeoutSeconds: &cfg.QueryTimeout,
-		})
+	return func(_ context.Context, guids []string) (map[string]*v1beta1.PodMetricsList, error) {
+		byNamespace, err := resolver.Namespaces(guids...)
+		if err != nil {
+			return nil, err
+		}
+
+		byGUID := make(map[string]*v1beta1.PodMetricsList, len(guids))
+		for namespace, namespaceGUIDs := range byNamespace {
+			requirement, err := labels.NewRequirement(cfg.AppSelector, selection.In, namespaceGUIDs)
+			if err != nil {
+				return nil, err
+			}
+
+			podMetrics, err := c.MetricsV1beta1().PodMetricses(namespace).List(v1.ListOptions{
+				LabelSelector:  requirement.String(),
+				TimeoutSeconds: &cfg.QueryTimeout,
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			for _, item := range podMetrics.Items {
+				guid := item.Labels[cfg.AppSelector]
+				if byGUID[guid] == nil {
+					byGUID[guid] = &v1beta1.PodMetricsList{}
+				}
+				byGUID[guid].Items = append(byGUID[guid].Items, item)
+			}
+		}
+		return byGUID, nil
 	}, nil
 }
 
-func createDiskUsageFetcher(cfg *Config) (metrics.DiskUsageFetcher, error) {
-	restConfig, err := rest.InClusterConfig()
+// servedNamespaces returns NAMESPACES, falling back to NAMESPACE. None means
+// every namespace.
+func servedNamespaces(cfg *Config) []string {
+	if len(cfg.Namespaces) > 0 {
+		return cfg.Namespaces
+	}
+	if cfg.Namespace != "" {
+		return []string{cfg.Namespace}
+	}
+	return nil
+}
+
+func createResolver(cfg *Config, restConfig *rest.Config, metricsCache *metrics.MetricsCache, stop <-chan struct{}) (*discovery.Resolver, toolscache.InformerSynced, error) {
+	// Watches stay open for minutes, so the request timeout would cut them.
+	informerConfig := rest.CopyConfig(restConfig)
+	informerConfig.Timeout = 0
+
+	clientSet, err := kubernetes.NewForConfig(informerConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	namespaces := servedNamespaces(cfg)
+	filter := discovery.StaticNamespaces(namespaces)
+
+	// Only a single static namespace lets the informer skip the rest of the
+	// cluster.
+	informerNamespace := v1.NamespaceAll
+	if len(namespaces) == 1 && cfg.NamespaceSelector == "" {
+		informerNamespace = namespaces[0]
+	}
+
+	// The pod informer caches trimmed pods to keep the proxy's memory flat
+	// as the number of app instances grows.
+	factory := informers.NewSharedInformerFactory(clientSet, 0)
+	podInformer := factory.InformerFor(&corev1.Pod{}, func(client kubernetes.Interface, resync time.Duration) toolscache.SharedIndexInformer {
+		return toolscache.NewSharedIndexInformer(
+			discovery.PodListWatch(client.CoreV1(), informerNamespace, cfg.AppSelector),
+			&corev1.Pod{},
+			resync,
+			toolscache.Indexers{toolscache.NamespaceIndex: toolscache.MetaNamespaceIndexFunc},
+		)
+	})
+	if err := podInformer.AddIndexers(discovery.Indexers(cfg.AppSelector)); err != nil {
+		return nil, nil, err
+	}
+	synced := podInformer.HasSynced
+
+	// Cached metrics don't know about new or deleted instances.
+	invalidate := func(obj interface{}) {
+		if tombstone, ok := obj.(toolscache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		if pod, ok := obj.(*corev1.Pod); ok {
+			metricsCache.Invalidate(pod.Labels[cfg.AppSelector])
+		}
+	}
+	podInformer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    invalidate,
+		DeleteFunc: invalidate,
+	})
+
+	if cfg.NamespaceSelector != "" {
+		namespaceFactory := informers.NewSharedInformerFactoryWithOptions(
+			clientSet,
+			0,
+			informers.WithTweakListOptions(func(opts *v1.ListOptions) {
+				opts.LabelSelector = cfg.NamespaceSelector
+			}),
+		)
+		namespaceInformer := namespaceFactory.Core().V1().Namespaces()
+		filter = discovery.Both(filter, discovery.ListedNamespaces(namespaceInformer.Lister()))
+
+		podsSynced, namespacesSynced := synced, namespaceInformer.Informer().HasSynced
+		synced = func() bool {
+			return podsSynced() && namespacesSynced()
+		}
+		namespaceFactory.Start(stop)
+	}
+
+	factory.Start(stop)
+
+	return discovery.NewResolver(podInformer.GetIndexer(), cfg.AppSelector, filter), synced, nil
+}
+
+func createHealthChecks(restConfig *rest.Config, podsSynced toolscache.InformerSynced) (map[string]health.Check, error) {
+	clientSet, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
 		return nil, err
 	}
 
+	return map[string]health.Check{
+		"metrics-api": func(ctx context.Context) error {
+			return clientSet.Discovery().RESTClient().Get().
+				AbsPath("/apis", v1beta1.SchemeGroupVersion.String()).
+				Context(ctx).
+				Do().
+				Error()
+		},
+		"pod-informer": func(context.Context) error {
+			if !podsSynced() {
+				return errors.New("pod informer has not synced")
+			}
+			return nil
+		},
+	}, nil
+}
+
+func createPodGetter(restConfig *rest.Config, resolver *discovery.Resolver) (diskusage.PodGetter, error) {
 	clientSet, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
 		return nil, err
 	}
 
+	return diskusage.NewPodGetter(clientSet.CoreV1(), resolver.PodNamespace), nil
+}
+
+func createNodeStatter(restConfig *rest.Config) (diskusage.NodeStatter, error) {
+	clientSet, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return diskusage.NewNodeStatter(clientSet.CoreV1().RESTClient()), nil
+}
+
+func createDiskUsageFetcher(cfg *Config, loggr *logging.Logger, selfMetrics *selfmetrics.Metrics, podGetter diskusage.PodGetter, nodeStatter diskusage.NodeStatter) (*diskusage.Fetcher, error) {
 	nodeCacheTTL, err := time.ParseDuration(cfg.NodeCacheTTL)
 	if err != nil {
 		return nil, err
 	}
 
 	return diskusage.NewFetcher(
+		loggr,
+		selfMetrics,
 		cache.NewExpiring(),
 		nodeCacheTTL,
-		diskusage.NewPodGetter(clientSet.CoreV1().Pods(cfg.Namespace)),
-		diskusage.NewNodeStatter(clientSet.CoreV1().RESTClient()),
+		podGetter,
+		nodeStatter,
 	), nil
 }
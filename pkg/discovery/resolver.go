@@ -0,0 +1,186 @@
+// Package discovery finds app pods, and the namespaces they live in, from a
+// pod informer that may span several namespaces.
+package discovery
+
+import (
+	"fmt"
+	"sort"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	guidIndex = "guid"
+	nameIndex = "name"
+)
+
+// NamespaceFilter reports whether app pods in a namespace are served.
+type NamespaceFilter func(namespace string) bool
+
+// AllNamespaces serves every namespace the pod informer sees.
+func AllNamespaces(string) bool {
+	return true
+}
+
+// StaticNamespaces serves only the named namespaces, or every namespace when
+// none are named.
+func StaticNamespaces(namespaces []string) NamespaceFilter {
+	if len(namespaces) == 0 {
+		return AllNamespaces
+	}
+
+	allowed := make(map[string]bool, len(namespaces))
+	for _, ns := range namespaces {
+		allowed[ns] = true
+	}
+	return func(namespace string) bool {
+		return allowed[namespace]
+	}
+}
+
+// NamespaceGetter is satisfied by a namespace lister.
+type NamespaceGetter interface {
+	Get(name string) (*v1.Namespace, error)
+}
+
+// ListedNamespaces serves the namespaces known to getter, typically a lister
+// for a namespace informer filtered by label selector.
+func ListedNamespaces(getter NamespaceGetter) NamespaceFilter {
+	return func(namespace string) bool {
+		_, err := getter.Get(namespace)
+		return err == nil
+	}
+}
+
+// Both serves namespaces that pass both filters.
+func Both(a, b NamespaceFilter) NamespaceFilter {
+	return func(namespace string) bool {
+		return a(namespace) && b(namespace)
+	}
+}
+
+// Indexers returns the pod informer indexers a Resolver needs. They must be
+// added before the informer starts.
+func Indexers(appSelector string) cache.Indexers {
+	return cache.Indexers{
+		guidIndex: func(obj interface{}) ([]string, error) {
+			pod, ok := obj.(*v1.Pod)
+			if !ok || pod.Labels[appSelector] == "" {
+				return nil, nil
+			}
+			return []string{pod.Labels[appSelector]}, nil
+		},
+		nameIndex: func(obj interface{}) ([]string, error) {
+			pod, ok := obj.(*v1.Pod)
+			if !ok {
+				return nil, nil
+			}
+			return []string{pod.Name}, nil
+		},
+	}
+}
+
+// Resolver looks app pods up by guid or name in a pod informer's indexer,
+// ignoring namespaces that aren't served.
+type Resolver struct {
+	indexer     cache.Indexer
+	appSelector string
+	namespaces  NamespaceFilter
+}
+
+func NewResolver(indexer cache.Indexer, appSelector string, namespaces NamespaceFilter) *Resolver {
+	return &Resolver{
+		indexer:     indexer,
+		appSelector: appSelector,
+		namespaces:  namespaces,
+	}
+}
+
+// Pods returns the pods of the app guid in every served namespace.
+func (r *Resolver) Pods(guid string) ([]*v1.Pod, error) {
+	objs, err := r.indexer.ByIndex(guidIndex, guid)
+	if err != nil {
+		return nil, err
+	}
+
+	var pods []*v1.Pod
+	for _, obj := range objs {
+		pod := obj.(*v1.Pod)
+		if r.namespaces(pod.Namespace) {
+			pods = append(pods, pod)
+		}
+	}
+	return pods, nil
+}
+
+// Namespaces groups guids by the namespaces their pods live in. Guids
+// without pods are left out.
+func (r *Resolver) Namespaces(guids ...string) (map[string][]string, error) {
+	byNamespace := map[string][]string{}
+	for _, guid := range guids {
+		pods, err := r.Pods(guid)
+		if err != nil {
+			return nil, err
+		}
+
+		seen := map[string]bool{}
+		for _, pod := range pods {
+			if seen[pod.Namespace] {
+				continue
+			}
+			seen[pod.Namespace] = true
+			byNamespace[pod.Namespace] = append(byNamespace[pod.Namespace], guid)
+		}
+	}
+	return byNamespace, nil
+}
+
+// Sources returns the app guids of served pods carrying all of the given
+// labels.
+func (r *Resolver) Sources(set map[string]string) ([]string, error) {
+	seen := map[string]bool{}
+	var guids []string
+	err := cache.ListAll(r.indexer, labels.SelectorFromSet(set), func(obj interface{}) {
+		pod := obj.(*v1.Pod)
+		guid := pod.Labels[r.appSelector]
+		if guid == "" || seen[guid] || !r.namespaces(pod.Namespace) {
+			return
+		}
+		seen[guid] = true
+		guids = append(guids, guid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(guids)
+	return guids, nil
+}
+
+// PodNamespace returns the served namespace holding the named pod.
+func (r *Resolver) PodNamespace(podName string) (string, error) {
+	objs, err := r.indexer.ByIndex(nameIndex, podName)
+	if err != nil {
+		return "", err
+	}
+
+	var namespaces []string
+	for _, obj := range objs {
+		pod := obj.(*v1.Pod)
+		if r.namespaces(pod.Namespace) {
+			namespaces = append(namespaces, pod.Namespace)
+		}
+	}
+
+	switch len(namespaces) {
+	case 0:
+		return "", fmt.Errorf("pod %q not found in any served namespace", podName)
+	case 1:
+		return namespaces[0], nil
+	default:
+		sort.Strings(namespaces)
+		return "", fmt.Errorf("pod %q exists in several namespaces: %v", podName, namespaces)
+	}
+}
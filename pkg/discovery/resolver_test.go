@@ -0,0 +1,150 @@
+package discovery_test
+
+import (
+	"errors"
+	"testing"
+
+	"code.cloudfoundry.org/metric-proxy/pkg/discovery"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestResolver(t *testing.T) {
+	newResolver := func(g *GomegaWithT, filter discovery.NamespaceFilter) *discovery.Resolver {
+		indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, discovery.Indexers("guid"))
+		for _, pod := range []*v1.Pod{
+			newPod("cf-workloads", "app-a-0", map[string]string{"guid": "app-a", "space": "space-1"}),
+			newPod("cf-workloads", "app-a-1", map[string]string{"guid": "app-a", "space": "space-1"}),
+			newPod("iso-seg", "app-a-2", map[string]string{"guid": "app-a", "space": "space-1"}),
+			newPod("iso-seg", "app-b-0", map[string]string{"guid": "app-b", "space": "space-1"}),
+			newPod("other", "app-c-0", map[string]string{"guid": "app-c", "space": "space-2"}),
+			newPod("other", "app-b-0", map[string]string{"guid": "app-b-copy"}),
+		} {
+			g.Expect(indexer.Add(pod)).To(Succeed())
+		}
+		return discovery.NewResolver(indexer, "guid", filter)
+	}
+
+	t.Run("it finds an app's pods across namespaces", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		r := newResolver(g, discovery.AllNamespaces)
+
+		pods, err := r.Pods("app-a")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(podNames(pods)).To(ConsistOf("app-a-0", "app-a-1", "app-a-2"))
+
+		pods, err = r.Pods("unknown")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(pods).To(BeEmpty())
+	})
+
+	t.Run("it ignores namespaces that aren't served", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		r := newResolver(g, discovery.StaticNamespaces([]string{"cf-workloads"}))
+
+		pods, err := r.Pods("app-a")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(podNames(pods)).To(ConsistOf("app-a-0", "app-a-1"))
+	})
+
+	t.Run("it groups guids by namespace", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		r := newResolver(g, discovery.AllNamespaces)
+
+		byNamespace, err := r.Namespaces("app-a", "app-b", "unknown")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(byNamespace).To(Equal(map[string][]string{
+			"cf-workloads": {"app-a"},
+			"iso-seg":      {"app-a", "app-b"},
+		}))
+	})
+
+	t.Run("it lists the guids of pods matching labels", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		r := newResolver(g, discovery.StaticNamespaces([]string{"cf-workloads", "iso-seg"}))
+
+		guids, err := r.Sources(map[string]string{"space": "space-1"})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(guids).To(Equal([]string{"app-a", "app-b"}))
+	})
+
+	t.Run("it finds the namespace of a pod by name", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		r := newResolver(g, discovery.StaticNamespaces([]string{"cf-workloads", "iso-seg"}))
+
+		namespace, err := r.PodNamespace("app-a-2")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(namespace).To(Equal("iso-seg"))
+
+		namespace, err = r.PodNamespace("app-b-0")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(namespace).To(Equal("iso-seg"))
+
+		_, err = r.PodNamespace("app-c-0")
+		g.Expect(err).To(MatchError(`pod "app-c-0" not found in any served namespace`))
+	})
+
+	t.Run("it refuses to guess between pods with the same name", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		r := newResolver(g, discovery.AllNamespaces)
+
+		_, err := r.PodNamespace("app-b-0")
+		g.Expect(err).To(MatchError(`pod "app-b-0" exists in several namespaces: [iso-seg other]`))
+	})
+}
+
+func TestNamespaceFilters(t *testing.T) {
+	t.Run("no static namespaces serves all of them", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		g.Expect(discovery.StaticNamespaces(nil)("anything")).To(BeTrue())
+	})
+
+	t.Run("listed namespaces are those the getter knows", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		filter := discovery.Both(
+			discovery.StaticNamespaces([]string{"cf-workloads", "iso-seg"}),
+			discovery.ListedNamespaces(namespaceGetter{"iso-seg": true, "other": true}),
+		)
+
+		g.Expect(filter("iso-seg")).To(BeTrue())
+		g.Expect(filter("cf-workloads")).To(BeFalse())
+		g.Expect(filter("other")).To(BeFalse())
+	})
+}
+
+type namespaceGetter map[string]bool
+
+func (n namespaceGetter) Get(name string) (*v1.Namespace, error) {
+	if !n[name] {
+		return nil, errors.New("not found")
+	}
+	return &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}, nil
+}
+
+func newPod(namespace, name string, labels map[string]string) *v1.Pod {
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			Labels:    labels,
+		},
+	}
+}
+
+func podNames(pods []*v1.Pod) []string {
+	var names []string
+	for _, pod := range pods {
+		names = append(names, pod.Name)
+	}
+	return names
+}
@@ -0,0 +1,102 @@
+package discovery
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// PodListWatch lists and watches pods like the default pod informer, but
+// trims every pod with TrimPod before the informer caches it. Every app pod
+// the proxy serves lives in that cache, so the fields it never reads
+// (managed fields, env, volumes, images, ...) would otherwise make up most
+// of its memory.
+func PodListWatch(client corev1client.PodsGetter, namespace, labelSelector string) *cache.ListWatch {
+	return &cache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			opts.LabelSelector = labelSelector
+			list, err := client.Pods(namespace).List(opts)
+			if err != nil {
+				return nil, err
+			}
+			for i := range list.Items {
+				list.Items[i] = *TrimPod(&list.Items[i])
+			}
+			return list, nil
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			opts.LabelSelector = labelSelector
+			w, err := client.Pods(namespace).Watch(opts)
+			if err != nil {
+				return nil, err
+			}
+			return watch.Filter(w, func(event watch.Event) (watch.Event, bool) {
+				if pod, ok := event.Object.(*v1.Pod); ok {
+					event.Object = TrimPod(pod)
+				}
+				return event, true
+			}), nil
+		},
+	}
+}
+
+// TrimPod returns a copy of pod holding only the fields the proxy reads.
+// The resource version is kept so the informer can resume its watch.
+func TrimPod(pod *v1.Pod) *v1.Pod {
+	var annotations map[string]string
+	for key, value := range pod.Annotations {
+		if key == lastAppliedAnnotation {
+			continue
+		}
+		if annotations == nil {
+			annotations = make(map[string]string, len(pod.Annotations))
+		}
+		annotations[key] = value
+	}
+
+	var containers []v1.Container
+	for _, container := range pod.Spec.Containers {
+		containers = append(containers, v1.Container{
+			Name:      container.Name,
+			Resources: v1.ResourceRequirements{Limits: container.Resources.Limits},
+		})
+	}
+
+	var statuses []v1.ContainerStatus
+	for _, status := range pod.Status.ContainerStatuses {
+		statuses = append(statuses, v1.ContainerStatus{
+			Name:         status.Name,
+			State:        status.State,
+			Ready:        status.Ready,
+			RestartCount: status.RestartCount,
+		})
+	}
+
+	return &v1.Pod{
+		TypeMeta: pod.TypeMeta,
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              pod.Name,
+			Namespace:         pod.Namespace,
+			UID:               pod.UID,
+			ResourceVersion:   pod.ResourceVersion,
+			DeletionTimestamp: pod.DeletionTimestamp,
+			Labels:            pod.Labels,
+			Annotations:       annotations,
+		},
+		Spec: v1.PodSpec{
+			NodeName:   pod.Spec.NodeName,
+			Containers: containers,
+		},
+		Status: v1.PodStatus{
+			Phase:             pod.Status.Phase,
+			PodIP:             pod.Status.PodIP,
+			StartTime:         pod.Status.StartTime,
+			ContainerStatuses: statuses,
+		},
+	}
+}
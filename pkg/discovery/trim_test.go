@@ -0,0 +1,90 @@
+package discovery_test
+
+import (
+	"testing"
+
+	"code.cloudfoundry.org/metric-proxy/pkg/discovery"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestTrimPod(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	startedAt := metav1.Now()
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "app-a-0",
+			Namespace:       "cf-workloads",
+			UID:             "uid-1",
+			ResourceVersion: "42",
+			Labels:          map[string]string{"guid": "app-a"},
+			Annotations: map[string]string{
+				"app_name": "app-a",
+				"kubectl.kubernetes.io/last-applied-configuration": "{}",
+			},
+			ManagedFields:   []metav1.ManagedFieldsEntry{{Manager: "kubectl"}},
+			OwnerReferences: []metav1.OwnerReference{{Name: "app-a"}},
+		},
+		Spec: v1.PodSpec{
+			NodeName: "node-1",
+			Containers: []v1.Container{{
+				Name:  "opi",
+				Image: "app-a:latest",
+				Env:   []v1.EnvVar{{Name: "VCAP_SERVICES", Value: "{}"}},
+				Resources: v1.ResourceRequirements{
+					Limits:   v1.ResourceList{v1.ResourceMemory: resource.MustParse("1Gi")},
+					Requests: v1.ResourceList{v1.ResourceMemory: resource.MustParse("512Mi")},
+				},
+			}},
+			Volumes: []v1.Volume{{Name: "certs"}},
+		},
+		Status: v1.PodStatus{
+			Phase:      v1.PodRunning,
+			PodIP:      "10.0.0.1",
+			StartTime:  &startedAt,
+			Conditions: []v1.PodCondition{{Type: v1.PodReady}},
+			ContainerStatuses: []v1.ContainerStatus{{
+				Name:         "opi",
+				Image:        "app-a:latest",
+				ContainerID:  "containerd://1",
+				Ready:        true,
+				RestartCount: 2,
+				State:        v1.ContainerState{Running: &v1.ContainerStateRunning{StartedAt: startedAt}},
+			}},
+		},
+	}
+
+	g.Expect(discovery.TrimPod(pod)).To(Equal(&v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "app-a-0",
+			Namespace:       "cf-workloads",
+			UID:             "uid-1",
+			ResourceVersion: "42",
+			Labels:          map[string]string{"guid": "app-a"},
+			Annotations:     map[string]string{"app_name": "app-a"},
+		},
+		Spec: v1.PodSpec{
+			NodeName: "node-1",
+			Containers: []v1.Container{{
+				Name: "opi",
+				Resources: v1.ResourceRequirements{
+					Limits: v1.ResourceList{v1.ResourceMemory: resource.MustParse("1Gi")},
+				},
+			}},
+		},
+		Status: v1.PodStatus{
+			Phase:     v1.PodRunning,
+			PodIP:     "10.0.0.1",
+			StartTime: &startedAt,
+			ContainerStatuses: []v1.ContainerStatus{{
+				Name:         "opi",
+				Ready:        true,
+				RestartCount: 2,
+				State:        v1.ContainerState{Running: &v1.ContainerStateRunning{StartedAt: startedAt}},
+			}},
+		},
+	}))
+}
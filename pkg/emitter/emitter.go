@@ -0,0 +1,196 @@
+// Package emitter pushes app envelopes to loggregator agents and syslog
+// drains at a fixed interval, for consumers that can't poll Read. Operator
+// destinations receive every app's envelopes; an app's own drains receive
+// only that app's.
+package emitter
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/metric-proxy/pkg/logging"
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics"
+	"code.cloudfoundry.org/metric-proxy/pkg/rpc/metricproxy_v1"
+	"github.com/golang/protobuf/proto"
+)
+
+// Destination receives the envelopes of up to metrics.MaxBatchSourceIDs
+// apps at a time.
+type Destination interface {
+	Send(ctx context.Context, envelopes []*loggregator_v2.Envelope) error
+}
+
+// AppDrainsFn returns an app's own destinations, keyed by name.
+type AppDrainsFn func(sourceID string) (map[string]Destination, error)
+
+// Emitter reads apps through BatchProxy, so the envelopes match what Read
+// returns, and sends them to every destination and to each app's own
+// drains. appDrainsFn may be nil.
+//
+// Read reports counters by their total only. The Emitter sets each
+// counter's delta to the increase since the total it last sent, so deltas
+// don't depend on who else reads the app.
+type Emitter struct {
+	logger         *logging.Logger
+	batchProxy     *metrics.BatchProxy
+	sourceListerFn metrics.SourceListerFn
+	destinations   map[string]Destination
+	appDrainsFn    AppDrainsFn
+
+	mu         sync.Mutex
+	lastTotals map[string]uint64
+}
+
+func New(
+	logger *logging.Logger,
+	batchProxy *metrics.BatchProxy,
+	sourceListerFn metrics.SourceListerFn,
+	destinations map[string]Destination,
+	appDrainsFn AppDrainsFn,
+) *Emitter {
+	return &Emitter{
+		logger:         logger,
+		batchProxy:     batchProxy,
+		sourceListerFn: sourceListerFn,
+		destinations:   destinations,
+		appDrainsFn:    appDrainsFn,
+		lastTotals:     map[string]uint64{},
+	}
+}
+
+// Run emits every interval until stop is closed. Each round must finish
+// within the interval.
+func (e *Emitter) Run(interval time.Duration, stop <-chan struct{}) {
+	metrics.RunRounds(interval, stop, func(ctx context.Context) {
+		if err := e.Emit(ctx); err != nil {
+			e.logger.Error("failed to emit app metrics", "error", err)
+		}
+	})
+}
+
+// Emit reads every app once and sends its envelopes to every destination
+// and to the app's own drains. A failing destination is logged and doesn't
+// keep the others from receiving envelopes; only failing to read apps is
+// returned.
+func (e *Emitter) Emit(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	sourceIDs, err := e.sourceListerFn(map[string]string{})
+	if err != nil {
+		return err
+	}
+
+	// totals replaces lastTotals after a full round, so counters that are
+	// gone aren't kept forever.
+	totals := map[string]uint64{}
+
+	sent := 0
+	failed := map[string]bool{}
+	err = metrics.ForEachBatch(sourceIDs, func(sourceIDs []string) error {
+		batch, err := e.batchProxy.BatchRead(ctx, &metricproxy_v1.BatchReadRequest{
+			SourceIds: sourceIDs,
+		})
+		if err != nil {
+			return err
+		}
+
+		var envelopes []*loggregator_v2.Envelope
+		for sourceID, sourceEnvelopes := range batch.GetEnvelopes() {
+			withDeltas := e.setDeltas(sourceEnvelopes.GetBatch(), totals)
+			envelopes = append(envelopes, withDeltas...)
+			e.sendToAppDrains(ctx, sourceID, withDeltas)
+		}
+		if len(envelopes) == 0 {
+			return nil
+		}
+
+		for name, destination := range e.destinations {
+			if failed[name] {
+				continue
+			}
+			if err := destination.Send(ctx, envelopes); err != nil {
+				e.logger.Error("failed to send envelopes", "destination", name, "error", err)
+				failed[name] = true
+			}
+		}
+		sent += len(envelopes)
+		return nil
+	})
+	if err != nil {
+		for key, total := range totals {
+			e.lastTotals[key] = total
+		}
+		return err
+	}
+
+	e.lastTotals = totals
+	e.logger.Debug("app metrics emitted", "sources", len(sourceIDs), "envelopes", sent)
+	return nil
+}
+
+func (e *Emitter) sendToAppDrains(ctx context.Context, sourceID string, envelopes []*loggregator_v2.Envelope) {
+	if e.appDrainsFn == nil || len(envelopes) == 0 {
+		return
+	}
+
+	drains, err := e.appDrainsFn(sourceID)
+	if err != nil {
+		e.logger.Error("failed to find app drains", "source_id", sourceID, "error", err)
+		return
+	}
+	for name, drain := range drains {
+		if err := drain.Send(ctx, envelopes); err != nil {
+			e.logger.Error("failed to send envelopes", "source_id", sourceID, "destination", name, "error", err)
+		}
+	}
+}
+
+// setDeltas returns envelopes with each counter's delta set to the increase
+// since the total last sent, recording the totals sent now in totals. A
+// counter sent for the first time has no increase, and one whose total went
+// down, such as a scraped counter whose app restarted, increased by its
+// whole total. Counters are copied, as envelopes may be shared with Read.
+func (e *Emitter) setDeltas(envelopes []*loggregator_v2.Envelope, totals map[string]uint64) []*loggregator_v2.Envelope {
+	withDeltas := make([]*loggregator_v2.Envelope, len(envelopes))
+	for i, envelope := range envelopes {
+		withDeltas[i] = envelope
+		counter := envelope.GetCounter()
+		if counter == nil {
+			continue
+		}
+
+		key := counterKey(envelope)
+		total := counter.GetTotal()
+		totals[key] = total
+
+		var delta uint64
+		if last, ok := e.lastTotals[key]; ok {
+			delta = total - last
+			if total < last {
+				delta = total
+			}
+		}
+
+		envelope = proto.Clone(envelope).(*loggregator_v2.Envelope)
+		envelope.GetCounter().Delta = delta
+		withDeltas[i] = envelope
+	}
+	return withDeltas
+}
+
+// counterKey identifies a counter series by its source, instance, name and
+// tags.
+func counterKey(envelope *loggregator_v2.Envelope) string {
+	tags := make([]string, 0, len(envelope.GetTags()))
+	for name, value := range envelope.GetTags() {
+		tags = append(tags, name+"="+value)
+	}
+	sort.Strings(tags)
+
+	return fmt.Sprintf("%s/%s/%s/%q", envelope.GetSourceId(), envelope.GetInstanceId(), envelope.GetCounter().GetName(), tags)
+}
@@ -0,0 +1,330 @@
+package emitter_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/metric-proxy/pkg/emitter"
+	"code.cloudfoundry.org/metric-proxy/pkg/logging"
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics"
+	"code.cloudfoundry.org/metric-proxy/pkg/rpc/metricproxy_v1"
+	"code.cloudfoundry.org/metric-proxy/pkg/selfmetrics"
+	"code.cloudfoundry.org/metric-proxy/pkg/synthetic"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+func TestEmitter(t *testing.T) {
+	generator := synthetic.NewGenerator(synthetic.Config{
+		Apps:       3,
+		Instances:  2,
+		Seed:       1,
+		AppLabel:   "cloudfoundry.org/app_guid",
+		SpaceLabel: "cloudfoundry.org/space_guid",
+		OrgLabel:   "cloudfoundry.org/org_guid",
+	}, clock.NewFakeClock(time.Now()))
+
+	t.Run("it sends every instance's envelopes to a loggregator agent", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		ingress := &fakeIngressServer{}
+		conn, stop := startIngressServer(g, ingress)
+		defer stop()
+
+		e := emitter.New(quietLogger(), newBatchProxy(generator), generator.Sources, map[string]emitter.Destination{
+			"loggregator": emitter.NewIngressDestination(conn),
+		}, nil)
+		g.Expect(e.Emit(context.Background())).To(Succeed())
+
+		gauges := gaugesByInstance(ingress.envelopes())
+		g.Expect(gauges).To(HaveLen(6))
+		for _, guid := range generator.GUIDs() {
+			for _, instanceID := range []string{"0", "1"} {
+				g.Expect(gauges[guid+"/"+instanceID]).To(ContainElements("cpu", "memory", "disk", "state"))
+			}
+		}
+	})
+
+	t.Run("it writes gauges to a syslog drain as structured data", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		g.Expect(err).ToNot(HaveOccurred())
+		defer lis.Close()
+		received := make(chan []string, 1)
+		go func() { received <- acceptSyslog(lis) }()
+
+		drain, err := emitter.NewSyslogDestination("syslog://"+lis.Addr().String(), nil)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		e := emitter.New(quietLogger(), newBatchProxy(generator), generator.Sources, map[string]emitter.Destination{
+			"drain": drain,
+		}, nil)
+		g.Expect(e.Emit(context.Background())).To(Succeed())
+
+		var messages []string
+		g.Eventually(received).Should(Receive(&messages))
+		guid := generator.GUIDs()[0]
+		g.Expect(messages).To(ContainElement(And(
+			HavePrefix("<14>1 "),
+			ContainSubstring(" "+guid+" 0 - "),
+			ContainSubstring(`[gauge@47450 name="memory_quota" value="`),
+		)))
+		g.Expect(messages).To(ContainElement(ContainSubstring(`name="cpu"`)))
+	})
+
+	t.Run("an app's own drain receives only that app's envelopes", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		g.Expect(err).ToNot(HaveOccurred())
+		defer lis.Close()
+		received := make(chan []string, 1)
+		go func() { received <- acceptSyslog(lis) }()
+
+		guid := generator.GUIDs()[0]
+		annotatedPods := func(sourceID string) ([]*v1.Pod, error) {
+			pods, err := generator.Pods(sourceID)
+			if err != nil || sourceID != guid {
+				return pods, err
+			}
+			var annotated []*v1.Pod
+			for _, pod := range pods {
+				pod = pod.DeepCopy()
+				pod.Annotations = map[string]string{"metric-proxy.cloudfoundry.org/syslog-drains": "syslog://" + lis.Addr().String() + ", not-a-drain"}
+				annotated = append(annotated, pod)
+			}
+			return annotated, nil
+		}
+
+		e := emitter.New(quietLogger(), newBatchProxy(generator), generator.Sources, nil,
+			emitter.AnnotatedDrains(quietLogger(), annotatedPods, "metric-proxy.cloudfoundry.org/syslog-drains", nil))
+		g.Expect(e.Emit(context.Background())).To(Succeed())
+
+		var messages []string
+		g.Eventually(received).Should(Receive(&messages))
+		g.Expect(messages).ToNot(BeEmpty())
+		for _, message := range messages {
+			g.Expect(message).To(ContainSubstring(" " + guid + " "))
+		}
+	})
+
+	t.Run("a failing destination doesn't keep the others from receiving envelopes", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		ingress := &fakeIngressServer{}
+		conn, stop := startIngressServer(g, ingress)
+		defer stop()
+
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		g.Expect(err).ToNot(HaveOccurred())
+		unreachable, err := emitter.NewSyslogDestination("syslog://"+lis.Addr().String(), nil)
+		g.Expect(err).ToNot(HaveOccurred())
+		lis.Close()
+
+		e := emitter.New(quietLogger(), newBatchProxy(generator), generator.Sources, map[string]emitter.Destination{
+			"loggregator": emitter.NewIngressDestination(conn),
+			"drain":       unreachable,
+		}, nil)
+		g.Expect(e.Emit(context.Background())).To(Succeed())
+		g.Expect(gaugesByInstance(ingress.envelopes())).To(HaveLen(6))
+	})
+
+	t.Run("it sets counter deltas against the totals it sent last", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		fakeClock := clock.NewFakeClock(time.Now())
+		generator := synthetic.NewGenerator(synthetic.Config{
+			Apps:      1,
+			Instances: 1,
+			Seed:      1,
+			AppLabel:  "cloudfoundry.org/app_guid",
+		}, fakeClock)
+		batchProxy := newBatchProxy(generator)
+
+		ingress := &fakeIngressServer{}
+		conn, stop := startIngressServer(g, ingress)
+		defer stop()
+		e := emitter.New(quietLogger(), batchProxy, generator.Sources, map[string]emitter.Destination{
+			"loggregator": emitter.NewIngressDestination(conn),
+		}, nil)
+
+		g.Expect(e.Emit(context.Background())).To(Succeed())
+		fakeClock.Step(time.Minute)
+		// Another client reading in between doesn't take the increase.
+		_, err := batchProxy.BatchRead(context.Background(), &metricproxy_v1.BatchReadRequest{SourceIds: generator.GUIDs()})
+		g.Expect(err).ToNot(HaveOccurred())
+		fakeClock.Step(time.Minute)
+		g.Expect(e.Emit(context.Background())).To(Succeed())
+
+		counters := cpuTimeCounters(ingress.envelopes())
+		g.Expect(counters).To(HaveLen(2))
+		g.Expect(counters[0].GetDelta()).To(BeZero())
+		g.Expect(counters[1].GetTotal()).To(BeNumerically(">", counters[0].GetTotal()))
+		g.Expect(counters[1].GetDelta()).To(Equal(counters[1].GetTotal() - counters[0].GetTotal()))
+	})
+
+	t.Run("it fails when apps can't be listed", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		failingLister := func(map[string]string) ([]string, error) {
+			return nil, errors.New("informer not synced")
+		}
+		e := emitter.New(quietLogger(), newBatchProxy(generator), failingLister, nil, nil)
+		g.Expect(e.Emit(context.Background())).To(MatchError("informer not synced"))
+	})
+}
+
+func TestNewSyslogDestination(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	for _, drainURL := range []string{"syslog://drain:514", "syslog-tls://drain:6514"} {
+		_, err := emitter.NewSyslogDestination(drainURL, nil)
+		g.Expect(err).ToNot(HaveOccurred())
+	}
+
+	_, err := emitter.NewSyslogDestination("https://drain:443", nil)
+	g.Expect(err).To(MatchError(ContainSubstring("must use syslog or syslog-tls")))
+	_, err = emitter.NewSyslogDestination("syslog://drain", nil)
+	g.Expect(err).To(MatchError(ContainSubstring("has no port")))
+}
+
+func newBatchProxy(generator *synthetic.Generator) *metrics.BatchProxy {
+	selfMetrics := selfmetrics.New(prometheus.NewRegistry(), []float64{1})
+	proxy := metrics.NewProxy(
+		quietLogger(),
+		selfMetrics,
+		metrics.NewMetricsCache(selfMetrics, cache.NewExpiring(), 0),
+		generator.Metrics,
+		generator.Pods,
+		generator,
+		generator,
+		metrics.ProxyOptions{CPUTimeFetcher: generator, DiskIOFetcher: generator},
+	)
+	return metrics.NewBatchProxy(proxy, generator.BatchMetrics)
+}
+
+func cpuTimeCounters(envelopes []*loggregator_v2.Envelope) []*loggregator_v2.Counter {
+	var counters []*loggregator_v2.Counter
+	for _, envelope := range envelopes {
+		if counter := envelope.GetCounter(); counter.GetName() == metrics.CPUTimeCounter {
+			counters = append(counters, counter)
+		}
+	}
+	return counters
+}
+
+// gaugesByInstance returns the gauge names received for each
+// source_id/instance_id.
+func gaugesByInstance(envelopes []*loggregator_v2.Envelope) map[string][]string {
+	gauges := map[string][]string{}
+	for _, envelope := range envelopes {
+		key := envelope.GetSourceId() + "/" + envelope.GetInstanceId()
+		for name := range envelope.GetGauge().GetMetrics() {
+			gauges[key] = append(gauges[key], name)
+		}
+	}
+	return gauges
+}
+
+type fakeIngressServer struct {
+	mu      sync.Mutex
+	batches []*loggregator_v2.EnvelopeBatch
+}
+
+func (s *fakeIngressServer) BatchSender(stream loggregator_v2.Ingress_BatchSenderServer) error {
+	for {
+		batch, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&loggregator_v2.BatchSenderResponse{})
+		}
+		if err != nil {
+			return err
+		}
+
+		s.mu.Lock()
+		s.batches = append(s.batches, batch)
+		s.mu.Unlock()
+	}
+}
+
+func (s *fakeIngressServer) Sender(loggregator_v2.Ingress_SenderServer) error {
+	return errors.New("not implemented")
+}
+
+func (s *fakeIngressServer) Send(context.Context, *loggregator_v2.EnvelopeBatch) (*loggregator_v2.SendResponse, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s *fakeIngressServer) envelopes() []*loggregator_v2.Envelope {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var envelopes []*loggregator_v2.Envelope
+	for _, batch := range s.batches {
+		envelopes = append(envelopes, batch.GetBatch()...)
+	}
+	return envelopes
+}
+
+func startIngressServer(g *GomegaWithT, ingress *fakeIngressServer) (*grpc.ClientConn, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	g.Expect(err).ToNot(HaveOccurred())
+
+	s := grpc.NewServer()
+	loggregator_v2.RegisterIngressServer(s, ingress)
+	go s.Serve(lis)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	g.Expect(err).ToNot(HaveOccurred())
+
+	return conn, func() {
+		conn.Close()
+		s.Stop()
+	}
+}
+
+// acceptSyslog reads the octet-counted messages of one connection.
+func acceptSyslog(lis net.Listener) []string {
+	conn, err := lis.Accept()
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+
+	var messages []string
+	r := bufio.NewReader(conn)
+	for {
+		length, err := r.ReadString(' ')
+		if err != nil {
+			return messages
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+		if err != nil {
+			return messages
+		}
+		message := make([]byte, n)
+		if _, err := io.ReadFull(r, message); err != nil {
+			return messages
+		}
+		messages = append(messages, string(message))
+	}
+}
+
+func quietLogger() *logging.Logger {
+	return logging.New(ioutil.Discard, logging.Error)
+}
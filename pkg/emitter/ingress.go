@@ -0,0 +1,35 @@
+package emitter
+
+import (
+	"context"
+	"io"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"google.golang.org/grpc"
+)
+
+// IngressDestination sends envelopes to a loggregator agent's v2 Ingress
+// API, one BatchSender stream per Send.
+type IngressDestination struct {
+	client loggregator_v2.IngressClient
+}
+
+func NewIngressDestination(conn *grpc.ClientConn) *IngressDestination {
+	return &IngressDestination{client: loggregator_v2.NewIngressClient(conn)}
+}
+
+func (d *IngressDestination) Send(ctx context.Context, envelopes []*loggregator_v2.Envelope) error {
+	sender, err := d.client.BatchSender(ctx)
+	if err != nil {
+		return err
+	}
+
+	// io.EOF means the stream failed; CloseAndRecv returns why.
+	err = sender.Send(&loggregator_v2.EnvelopeBatch{Batch: envelopes})
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	_, err = sender.CloseAndRecv()
+	return err
+}
@@ -0,0 +1,122 @@
+package emitter
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/metric-proxy/pkg/logging"
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics"
+)
+
+// SyslogDestination writes envelopes as RFC 5424 messages to a syslog drain
+// over TCP, framed by octet counting (RFC 6587) the way loggregator writes
+// syslog drains. Gauges become one message per metric, with the value in
+// gauge@47450 structured data.
+//
+// Each Send opens a new connection, so a drain that restarts loses at most
+// one round.
+type SyslogDestination struct {
+	addr      string
+	tlsConfig *tls.Config
+	dialer    net.Dialer
+}
+
+// NewSyslogDestination parses a syslog://host:port or
+// syslog-tls://host:port drain URL. tlsConfig may be nil to verify
+// syslog-tls drains against the system roots.
+func NewSyslogDestination(drainURL string, tlsConfig *tls.Config) (*SyslogDestination, error) {
+	u, err := url.Parse(drainURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Port() == "" {
+		return nil, fmt.Errorf("syslog drain %q has no port", drainURL)
+	}
+
+	d := &SyslogDestination{addr: u.Host}
+	switch u.Scheme {
+	case "syslog":
+	case "syslog-tls":
+		d.tlsConfig = &tls.Config{}
+		if tlsConfig != nil {
+			d.tlsConfig = tlsConfig.Clone()
+		}
+		if d.tlsConfig.ServerName == "" {
+			d.tlsConfig.ServerName = u.Hostname()
+		}
+	default:
+		return nil, fmt.Errorf("syslog drain %q must use syslog or syslog-tls", drainURL)
+	}
+	return d, nil
+}
+
+func (d *SyslogDestination) Send(ctx context.Context, envelopes []*loggregator_v2.Envelope) error {
+	conn, err := d.dialer.DialContext(ctx, "tcp", d.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return err
+		}
+	}
+	if d.tlsConfig != nil {
+		tlsConn := tls.Client(conn, d.tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			return err
+		}
+		conn = tlsConn
+	}
+
+	w := bufio.NewWriter(conn)
+	for _, envelope := range envelopes {
+		messages, err := envelope.Syslog()
+		if err != nil {
+			return err
+		}
+		for _, message := range messages {
+			if _, err := fmt.Fprintf(w, "%d %s", len(message), message); err != nil {
+				return err
+			}
+		}
+	}
+	return w.Flush()
+}
+
+// AnnotatedDrains finds an app's own drains in the given annotation of its
+// pods, as comma-separated syslog drain URLs. Invalid URLs are logged and
+// skipped. tlsConfig is used like in NewSyslogDestination.
+func AnnotatedDrains(logger *logging.Logger, podListerFn metrics.PodListerFn, annotation string, tlsConfig *tls.Config) AppDrainsFn {
+	return func(sourceID string) (map[string]Destination, error) {
+		pods, err := podListerFn(sourceID)
+		if err != nil {
+			return nil, err
+		}
+
+		drains := map[string]Destination{}
+		for _, pod := range pods {
+			for _, drainURL := range strings.Split(pod.Annotations[annotation], ",") {
+				drainURL = strings.TrimSpace(drainURL)
+				if drainURL == "" || drains[drainURL] != nil {
+					continue
+				}
+
+				d, err := NewSyslogDestination(drainURL, tlsConfig)
+				if err != nil {
+					logger.Warn("ignoring invalid app drain", "source_id", sourceID, "pod", pod.Name, "error", err)
+					continue
+				}
+				drains[drainURL] = d
+			}
+		}
+		return drains, nil
+	}
+}
@@ -0,0 +1,446 @@
+// Package fakecluster serves the parts of the Kubernetes API metric-proxy
+// uses from an in-process HTTP server, for tests that exercise the real
+// client-go clients without a cluster.
+package fakecluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics/diskusage"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/rest"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+var (
+	podType       = metav1.TypeMeta{Kind: "Pod", APIVersion: "v1"}
+	namespaceType = metav1.TypeMeta{Kind: "Namespace", APIVersion: "v1"}
+	metricsType   = metav1.TypeMeta{Kind: "PodMetrics", APIVersion: v1beta1.SchemeGroupVersion.String()}
+)
+
+// Cluster is a fake Kubernetes API serving:
+//
+//   - pods and namespaces, with list, watch, get and label selectors
+//   - metrics.k8s.io/v1beta1 PodMetrics, with list and label selectors
+//   - nodes/<name>/proxy/stats/summary
+//   - nodes/<name>/proxy/metrics/cadvisor, with filesystem IO counters only
+//
+// Everything else is 404.
+type Cluster struct {
+	server *httptest.Server
+	closed chan struct{}
+
+	mu              sync.Mutex
+	resourceVersion int
+	pods            map[string]*corev1.Pod
+	namespaces      map[string]*corev1.Namespace
+	podMetrics      map[string]*v1beta1.PodMetrics
+	summaries       map[string]diskusage.NodeDiskUsage
+	ioStats         map[string]diskusage.NodeIOStats
+	events          []event
+	changed         chan struct{}
+	requests        []string
+}
+
+type event struct {
+	resourceVersion int
+	kind            string
+	Type            string      `json:"type"`
+	Object          interface{} `json:"object"`
+}
+
+// New starts a Cluster. Close it when done.
+func New() *Cluster {
+	c := &Cluster{
+		closed:     make(chan struct{}),
+		pods:       map[string]*corev1.Pod{},
+		namespaces: map[string]*corev1.Namespace{},
+		podMetrics: map[string]*v1beta1.PodMetrics{},
+		summaries:  map[string]diskusage.NodeDiskUsage{},
+		ioStats:    map[string]diskusage.NodeIOStats{},
+		changed:    make(chan struct{}),
+	}
+	c.server = httptest.NewServer(http.HandlerFunc(c.serveHTTP))
+	return c
+}
+
+// Close ends open watches and stops the server.
+func (c *Cluster) Close() {
+	close(c.closed)
+	c.server.Close()
+}
+
+// RESTConfig returns a client config for the cluster.
+func (c *Cluster) RESTConfig() *rest.Config {
+	return &rest.Config{Host: c.server.URL}
+}
+
+// Requests returns the method and request URI of every request served so
+// far, e.g. "GET /api/v1/nodes/node-1/proxy/stats/summary".
+func (c *Cluster) Requests() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string(nil), c.requests...)
+}
+
+// AddNamespace creates or updates a namespace.
+func (c *Cluster) AddNamespace(name string, nsLabels map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ns := &corev1.Namespace{
+		TypeMeta:   namespaceType,
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: nsLabels},
+	}
+	_, exists := c.namespaces[name]
+	c.namespaces[name] = ns
+	c.record("namespaces", exists, ns, &ns.ObjectMeta)
+}
+
+// AddPod creates or updates a pod.
+func (c *Cluster) AddPod(pod *corev1.Pod) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pod = pod.DeepCopy()
+	pod.TypeMeta = podType
+	_, exists := c.pods[key(pod.ObjectMeta)]
+	c.pods[key(pod.ObjectMeta)] = pod
+	c.record("pods", exists, pod, &pod.ObjectMeta)
+}
+
+// DeletePod deletes a pod, if it exists.
+func (c *Cluster) DeletePod(namespace, name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pod, ok := c.pods[namespace+"/"+name]
+	if !ok {
+		return
+	}
+	delete(c.pods, namespace+"/"+name)
+
+	c.resourceVersion++
+	c.events = append(c.events, event{
+		resourceVersion: c.resourceVersion,
+		kind:            "pods",
+		Type:            "DELETED",
+		Object:          pod,
+	})
+	c.notify()
+}
+
+// SetPodMetrics creates or replaces the metrics-server view of a pod.
+func (c *Cluster) SetPodMetrics(m *v1beta1.PodMetrics) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m = m.DeepCopy()
+	m.TypeMeta = metricsType
+	c.podMetrics[key(m.ObjectMeta)] = m
+}
+
+// SetNodeSummary sets the kubelet stats summary served for a node.
+func (c *Cluster) SetNodeSummary(nodeName string, summary diskusage.NodeDiskUsage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.summaries[nodeName] = summary
+}
+
+// SetNodeIOStats sets the container filesystem IO served as a node's
+// cAdvisor metrics.
+func (c *Cluster) SetNodeIOStats(nodeName string, stats diskusage.NodeIOStats) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ioStats[nodeName] = stats
+}
+
+// record stamps a resource version on a stored object and queues its watch
+// event. Callers hold c.mu.
+func (c *Cluster) record(kind string, exists bool, obj interface{}, meta *metav1.ObjectMeta) {
+	c.resourceVersion++
+	meta.ResourceVersion = strconv.Itoa(c.resourceVersion)
+
+	eventType := "ADDED"
+	if exists {
+		eventType = "MODIFIED"
+	}
+	c.events = append(c.events, event{
+		resourceVersion: c.resourceVersion,
+		kind:            kind,
+		Type:            eventType,
+		Object:          obj,
+	})
+	c.notify()
+}
+
+// notify wakes open watches. Callers hold c.mu.
+func (c *Cluster) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *Cluster) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	c.requests = append(c.requests, r.Method+" "+r.URL.RequestURI())
+	c.mu.Unlock()
+
+	if r.Method != http.MethodGet {
+		writeStatus(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method+" is not supported")
+		return
+	}
+
+	selector, err := labels.Parse(r.URL.Query().Get("labelSelector"))
+	if err != nil {
+		writeStatus(w, http.StatusBadRequest, "BadRequest", err.Error())
+		return
+	}
+	watching := r.URL.Query().Get("watch") == "true"
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case match(path, "api", "v1", "pods"):
+		c.servePods(w, r, "", selector, watching)
+	case match(path, "api", "v1", "namespaces", "*", "pods"):
+		c.servePods(w, r, path[3], selector, watching)
+	case match(path, "api", "v1", "namespaces", "*", "pods", "*"):
+		c.serveGetPod(w, path[3], path[5])
+	case match(path, "api", "v1", "namespaces"):
+		c.serveNamespaces(w, r, selector, watching)
+	case match(path, "api", "v1", "nodes", "*", "proxy", "stats", "summary"):
+		c.serveSummary(w, path[3])
+	case match(path, "api", "v1", "nodes", "*", "proxy", "metrics", "cadvisor"):
+		c.serveCadvisor(w, path[3])
+	case match(path, "apis", "metrics.k8s.io", "v1beta1"):
+		writeJSON(w, http.StatusOK, metav1.APIResourceList{
+			TypeMeta:     metav1.TypeMeta{Kind: "APIResourceList", APIVersion: "v1"},
+			GroupVersion: v1beta1.SchemeGroupVersion.String(),
+			APIResources: []metav1.APIResource{
+				{Name: "pods", Namespaced: true, Kind: "PodMetrics", Verbs: []string{"get", "list"}},
+			},
+		})
+	case match(path, "apis", "metrics.k8s.io", "v1beta1", "pods"):
+		c.servePodMetrics(w, "", selector)
+	case match(path, "apis", "metrics.k8s.io", "v1beta1", "namespaces", "*", "pods"):
+		c.servePodMetrics(w, path[4], selector)
+	default:
+		writeStatus(w, http.StatusNotFound, "NotFound", "the server could not find the requested resource")
+	}
+}
+
+func (c *Cluster) servePods(w http.ResponseWriter, r *http.Request, namespace string, selector labels.Selector, watching bool) {
+	matches := func(obj interface{}) bool {
+		pod := obj.(*corev1.Pod)
+		return (namespace == "" || pod.Namespace == namespace) && selector.Matches(labels.Set(pod.Labels))
+	}
+	if watching {
+		c.watch(w, r, "pods", matches)
+		return
+	}
+
+	c.mu.Lock()
+	list := corev1.PodList{
+		TypeMeta: metav1.TypeMeta{Kind: "PodList", APIVersion: "v1"},
+		ListMeta: metav1.ListMeta{ResourceVersion: strconv.Itoa(c.resourceVersion)},
+	}
+	for _, pod := range c.pods {
+		if matches(pod) {
+			list.Items = append(list.Items, *pod)
+		}
+	}
+	c.mu.Unlock()
+
+	sort.Slice(list.Items, func(i, j int) bool {
+		return key(list.Items[i].ObjectMeta) < key(list.Items[j].ObjectMeta)
+	})
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (c *Cluster) serveGetPod(w http.ResponseWriter, namespace, name string) {
+	c.mu.Lock()
+	pod, ok := c.pods[namespace+"/"+name]
+	c.mu.Unlock()
+
+	if !ok {
+		writeStatus(w, http.StatusNotFound, "NotFound", fmt.Sprintf("pods %q not found", name))
+		return
+	}
+	writeJSON(w, http.StatusOK, pod)
+}
+
+func (c *Cluster) serveNamespaces(w http.ResponseWriter, r *http.Request, selector labels.Selector, watching bool) {
+	matches := func(obj interface{}) bool {
+		return selector.Matches(labels.Set(obj.(*corev1.Namespace).Labels))
+	}
+	if watching {
+		c.watch(w, r, "namespaces", matches)
+		return
+	}
+
+	c.mu.Lock()
+	list := corev1.NamespaceList{
+		TypeMeta: metav1.TypeMeta{Kind: "NamespaceList", APIVersion: "v1"},
+		ListMeta: metav1.ListMeta{ResourceVersion: strconv.Itoa(c.resourceVersion)},
+	}
+	for _, ns := range c.namespaces {
+		if matches(ns) {
+			list.Items = append(list.Items, *ns)
+		}
+	}
+	c.mu.Unlock()
+
+	sort.Slice(list.Items, func(i, j int) bool {
+		return key(list.Items[i].ObjectMeta) < key(list.Items[j].ObjectMeta)
+	})
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (c *Cluster) servePodMetrics(w http.ResponseWriter, namespace string, selector labels.Selector) {
+	c.mu.Lock()
+	list := v1beta1.PodMetricsList{
+		TypeMeta: metav1.TypeMeta{Kind: "PodMetricsList", APIVersion: v1beta1.SchemeGroupVersion.String()},
+	}
+	for _, m := range c.podMetrics {
+		if (namespace == "" || m.Namespace == namespace) && selector.Matches(labels.Set(m.Labels)) {
+			list.Items = append(list.Items, *m)
+		}
+	}
+	c.mu.Unlock()
+
+	sort.Slice(list.Items, func(i, j int) bool {
+		return key(list.Items[i].ObjectMeta) < key(list.Items[j].ObjectMeta)
+	})
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (c *Cluster) serveSummary(w http.ResponseWriter, nodeName string) {
+	c.mu.Lock()
+	summary, ok := c.summaries[nodeName]
+	c.mu.Unlock()
+
+	if !ok {
+		writeStatus(w, http.StatusNotFound, "NotFound", fmt.Sprintf("nodes %q not found", nodeName))
+		return
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
+
+// serveCadvisor writes a node's IO stats in the Prometheus text format,
+// one series per container on a single device.
+func (c *Cluster) serveCadvisor(w http.ResponseWriter, nodeName string) {
+	c.mu.Lock()
+	stats, ok := c.ioStats[nodeName]
+	c.mu.Unlock()
+
+	if !ok {
+		writeStatus(w, http.StatusNotFound, "NotFound", fmt.Sprintf("nodes %q not found", nodeName))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, metric := range []struct {
+		name  string
+		value func(diskusage.ContainerIOStats) uint64
+	}{
+		{"container_fs_reads_bytes_total", func(c diskusage.ContainerIOStats) uint64 { return c.ReadBytes }},
+		{"container_fs_writes_bytes_total", func(c diskusage.ContainerIOStats) uint64 { return c.WriteBytes }},
+	} {
+		fmt.Fprintf(w, "# TYPE %s counter\n", metric.name)
+		for _, pod := range stats.Pods {
+			for _, container := range pod.Containers {
+				fmt.Fprintf(w, "%s{container=%q,device=\"/dev/sda\",namespace=%q,pod=%q} %d\n",
+					metric.name, container.Name, pod.PodRef.Namespace, pod.PodRef.Name, metric.value(container))
+			}
+		}
+	}
+}
+
+// watch streams events for kind after the requested resource version
+// until the client goes away or the cluster is closed.
+func (c *Cluster) watch(w http.ResponseWriter, r *http.Request, kind string, matches func(interface{}) bool) {
+	since, _ := strconv.Atoi(r.URL.Query().Get("resourceVersion"))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+
+	for {
+		c.mu.Lock()
+		var pending []event
+		for _, e := range c.events {
+			if e.resourceVersion > since && e.kind == kind && matches(e.Object) {
+				pending = append(pending, e)
+			}
+		}
+		if len(c.events) > 0 {
+			since = c.events[len(c.events)-1].resourceVersion
+		}
+		changed := c.changed
+		c.mu.Unlock()
+
+		for _, e := range pending {
+			if err := encoder.Encode(e); err != nil {
+				return
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+func match(path []string, pattern ...string) bool {
+	if len(path) != len(pattern) {
+		return false
+	}
+	for i := range pattern {
+		if pattern[i] != "*" && pattern[i] != path[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func key(meta metav1.ObjectMeta) string {
+	return meta.Namespace + "/" + meta.Name
+}
+
+func writeStatus(w http.ResponseWriter, code int, reason metav1.StatusReason, message string) {
+	writeJSON(w, code, metav1.Status{
+		TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+		Status:   metav1.StatusFailure,
+		Message:  message,
+		Reason:   reason,
+		Code:     int32(code),
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
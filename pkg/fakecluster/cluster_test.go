@@ -0,0 +1,160 @@
+package fakecluster_test
+
+import (
+	"context"
+	"testing"
+
+	"code.cloudfoundry.org/metric-proxy/pkg/fakecluster"
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics/diskusage"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+	"k8s.io/metrics/pkg/client/clientset/versioned"
+)
+
+func TestCluster(t *testing.T) {
+	newPod := func(namespace, name, guid string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			Labels:    map[string]string{"guid": guid},
+		}}
+	}
+
+	t.Run("it lists and gets pods", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		cluster := fakecluster.New()
+		defer cluster.Close()
+		cluster.AddPod(newPod("cf-workloads", "app-a-0", "app-a"))
+		cluster.AddPod(newPod("cf-workloads", "app-b-0", "app-b"))
+		cluster.AddPod(newPod("iso-seg", "app-a-1", "app-a"))
+
+		clientSet, err := kubernetes.NewForConfig(cluster.RESTConfig())
+		g.Expect(err).ToNot(HaveOccurred())
+
+		pods, err := clientSet.CoreV1().Pods("").List(metav1.ListOptions{LabelSelector: "guid=app-a"})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(pods.Items).To(HaveLen(2))
+		g.Expect(pods.Items[0].Name).To(Equal("app-a-0"))
+		g.Expect(pods.Items[1].Name).To(Equal("app-a-1"))
+
+		pod, err := clientSet.CoreV1().Pods("iso-seg").Get("app-a-1", metav1.GetOptions{})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(pod.Labels).To(HaveKeyWithValue("guid", "app-a"))
+
+		_, err = clientSet.CoreV1().Pods("iso-seg").Get("app-b-0", metav1.GetOptions{})
+		g.Expect(errors.IsNotFound(err)).To(BeTrue())
+	})
+
+	t.Run("informers see pods added and deleted after they start", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		cluster := fakecluster.New()
+		defer cluster.Close()
+		cluster.AddPod(newPod("cf-workloads", "app-a-0", "app-a"))
+
+		clientSet, err := kubernetes.NewForConfig(cluster.RESTConfig())
+		g.Expect(err).ToNot(HaveOccurred())
+		stop := make(chan struct{})
+		defer close(stop)
+		factory := informers.NewSharedInformerFactory(clientSet, 0)
+		lister := factory.Core().V1().Pods().Lister()
+		factory.Start(stop)
+		factory.WaitForCacheSync(stop)
+
+		podCount := func() int {
+			pods, _ := lister.List(labels.Everything())
+			return len(pods)
+		}
+		g.Expect(podCount()).To(Equal(1))
+
+		cluster.AddPod(newPod("cf-workloads", "app-a-1", "app-a"))
+		g.Eventually(podCount).Should(Equal(2))
+
+		cluster.DeletePod("cf-workloads", "app-a-0")
+		g.Eventually(podCount).Should(Equal(1))
+	})
+
+	t.Run("it serves pod metrics by namespace and label", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		cluster := fakecluster.New()
+		defer cluster.Close()
+		for _, pod := range []*corev1.Pod{
+			newPod("cf-workloads", "app-a-0", "app-a"),
+			newPod("cf-workloads", "app-b-0", "app-b"),
+			newPod("iso-seg", "app-a-1", "app-a"),
+		} {
+			cluster.SetPodMetrics(&v1beta1.PodMetrics{ObjectMeta: pod.ObjectMeta})
+		}
+
+		c, err := versioned.NewForConfig(cluster.RESTConfig())
+		g.Expect(err).ToNot(HaveOccurred())
+
+		podMetrics, err := c.MetricsV1beta1().PodMetricses("cf-workloads").List(metav1.ListOptions{LabelSelector: "guid=app-a"})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(podMetrics.Items).To(HaveLen(1))
+		g.Expect(podMetrics.Items[0].Name).To(Equal("app-a-0"))
+
+		g.Expect(cluster.Requests()).To(ContainElement(
+			"GET /apis/metrics.k8s.io/v1beta1/namespaces/cf-workloads/pods?labelSelector=guid%3Dapp-a",
+		))
+	})
+
+	t.Run("it serves node stats summaries", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		cluster := fakecluster.New()
+		defer cluster.Close()
+		cluster.SetNodeSummary("node-1", diskusage.NodeDiskUsage{Pods: []diskusage.PodDiskUsage{{
+			PodRef: diskusage.PodRef{Name: "app-a-0", Namespace: "cf-workloads"},
+			Containers: []diskusage.ContainerDiskUsage{{
+				Name:   "app",
+				RootFS: diskusage.DiskUsage{UsedBytes: 100},
+				Logs:   diskusage.DiskUsage{UsedBytes: 20},
+			}},
+		}}})
+
+		clientSet, err := kubernetes.NewForConfig(cluster.RESTConfig())
+		g.Expect(err).ToNot(HaveOccurred())
+		statter := diskusage.NewNodeStatter(clientSet.CoreV1().RESTClient())
+
+		summary, err := statter.Summary(context.Background(), "node-1")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(summary.Pods).To(HaveLen(1))
+		g.Expect(summary.Pods[0].Containers[0].RootFS.UsedBytes).To(BeEquivalentTo(100))
+		g.Expect(cluster.Requests()).To(ContainElement("GET /api/v1/nodes/node-1/proxy/stats/summary"))
+
+		_, err = statter.Summary(context.Background(), "node-2")
+		g.Expect(errors.IsNotFound(err)).To(BeTrue())
+	})
+	t.Run("it serves node cadvisor filesystem io", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		cluster := fakecluster.New()
+		defer cluster.Close()
+		cluster.SetNodeIOStats("node-1", diskusage.NodeIOStats{Pods: []diskusage.PodIOStats{{
+			PodRef:     diskusage.PodRef{Name: "app-a-0", Namespace: "cf-workloads"},
+			Containers: []diskusage.ContainerIOStats{{Name: "app", ReadBytes: 4096, WriteBytes: 512}},
+		}}})
+
+		clientSet, err := kubernetes.NewForConfig(cluster.RESTConfig())
+		g.Expect(err).ToNot(HaveOccurred())
+		statter := diskusage.NewNodeStatter(clientSet.CoreV1().RESTClient())
+
+		stats, err := statter.IOStats(context.Background(), "node-1", "app-a-0")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(stats.Pods).To(HaveLen(1))
+		g.Expect(stats.Pods[0].Containers).To(Equal([]diskusage.ContainerIOStats{{Name: "app", ReadBytes: 4096, WriteBytes: 512}}))
+		g.Expect(cluster.Requests()).To(ContainElement("GET /api/v1/nodes/node-1/proxy/metrics/cadvisor"))
+
+		_, err = statter.IOStats(context.Background(), "node-2", "app-a-0")
+		g.Expect(errors.IsNotFound(err)).To(BeTrue())
+	})
+}
@@ -0,0 +1,227 @@
+// Package health reports whether metric-proxy can reach its upstream
+// Kubernetes APIs, over gRPC health checking and HTTP probes.
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/metric-proxy/pkg/logging"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+// Check returns an error when a dependency is unreachable or not ready. It
+// should give up once ctx is done.
+type Check func(ctx context.Context) error
+
+// Checker runs named checks concurrently and reports NOT_SERVING once any
+// check has gone without succeeding for longer than the unreachable timeout.
+// Checks that have never succeeded are not ready, and a check that outlives
+// the check timeout fails.
+type Checker struct {
+	logger             *logging.Logger
+	clock              clock.PassiveClock
+	checkTimeout       time.Duration
+	unreachableTimeout time.Duration
+	checks             map[string]Check
+	services           []string
+	healthServer       *health.Server
+
+	mu           sync.Mutex
+	running      map[string]bool
+	lastSuccess  map[string]time.Time
+	lastErr      map[string]error
+	shuttingDown bool
+}
+
+func NewChecker(logger *logging.Logger, clock clock.PassiveClock, checkTimeout, unreachableTimeout time.Duration, checks map[string]Check, services ...string) *Checker {
+	c := &Checker{
+		logger:             logger,
+		clock:              clock,
+		checkTimeout:       checkTimeout,
+		unreachableTimeout: unreachableTimeout,
+		checks:             checks,
+		services:           append([]string{""}, services...),
+		healthServer:       health.NewServer(),
+		running:            map[string]bool{},
+		lastSuccess:        map[string]time.Time{},
+		lastErr:            map[string]error{},
+	}
+	c.setServingStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+
+	return c
+}
+
+// HealthServer is the grpc.health.v1.Health implementation kept in sync with
+// the checks.
+func (c *Checker) HealthServer() *health.Server {
+	return c.healthServer
+}
+
+// Run checks every interval until stop is closed.
+func (c *Checker) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		c.Check()
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Check runs every check once, waiting at most the check timeout, and
+// updates the serving status.
+func (c *Checker) Check() {
+	var wg sync.WaitGroup
+	for name, check := range c.checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			c.record(name, c.run(name, check))
+		}(name, check)
+	}
+	wg.Wait()
+
+	c.mu.Lock()
+	shuttingDown := c.shuttingDown
+	c.mu.Unlock()
+	if shuttingDown {
+		return
+	}
+
+	if c.Ready() {
+		c.setServingStatus(healthpb.HealthCheckResponse_SERVING)
+		return
+	}
+	c.setServingStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+}
+
+// run runs check until it returns or the check timeout passes. A check that
+// ignores its context keeps running in the background, and fails every round
+// until it returns rather than being started again.
+func (c *Checker) run(name string, check Check) error {
+	c.mu.Lock()
+	if c.running[name] {
+		c.mu.Unlock()
+		return fmt.Errorf("still running after %s", c.checkTimeout)
+	}
+	c.running[name] = true
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.checkTimeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		err := check(ctx)
+
+		c.mu.Lock()
+		delete(c.running, name)
+		c.mu.Unlock()
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out after %s", c.checkTimeout)
+	}
+}
+
+func (c *Checker) record(name string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err != nil {
+		if c.lastErr[name] == nil {
+			c.logger.Warn("health check failing", "check", name, "error", err)
+		}
+		c.lastErr[name] = err
+		return
+	}
+	if c.lastErr[name] != nil {
+		c.logger.Info("health check recovered", "check", name)
+	}
+	c.lastErr[name] = nil
+	c.lastSuccess[name] = c.clock.Now()
+}
+
+// Shutdown reports NOT_SERVING from now on so that load balancers stop
+// routing new requests while in-flight ones drain.
+func (c *Checker) Shutdown() {
+	c.mu.Lock()
+	c.shuttingDown = true
+	c.mu.Unlock()
+
+	c.healthServer.Shutdown()
+}
+
+// Ready reports whether every check has succeeded within the unreachable
+// timeout.
+func (c *Checker) Ready() bool {
+	return len(c.failing()) == 0
+}
+
+func (c *Checker) failing() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var failing []string
+	if c.shuttingDown {
+		failing = append(failing, "shutting down")
+	}
+	for name := range c.checks {
+		lastSuccess, ok := c.lastSuccess[name]
+		if ok && c.clock.Since(lastSuccess) <= c.unreachableTimeout {
+			continue
+		}
+
+		reason := "not checked yet"
+		if err := c.lastErr[name]; err != nil {
+			reason = err.Error()
+		}
+		failing = append(failing, fmt.Sprintf("%s: %s", name, reason))
+	}
+	sort.Strings(failing)
+
+	return failing
+}
+
+func (c *Checker) setServingStatus(status healthpb.HealthCheckResponse_ServingStatus) {
+	for _, service := range c.services {
+		c.healthServer.SetServingStatus(service, status)
+	}
+}
+
+// Handler serves /healthz, which succeeds while the process is up, and
+// /readyz, which succeeds while the checks are passing.
+func (c *Checker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		failing := c.failing()
+		if len(failing) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, strings.Join(failing, "\n"))
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+
+	return mux
+}
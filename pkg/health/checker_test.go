@@ -0,0 +1,176 @@
+package health_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/metric-proxy/pkg/health"
+	"code.cloudfoundry.org/metric-proxy/pkg/logging"
+	. "github.com/onsi/gomega"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+func TestChecker(t *testing.T) {
+	var (
+		g         *GomegaWithT
+		fakeClock *clock.FakeClock
+		apiErr    error
+		synced    bool
+		checker   *health.Checker
+	)
+
+	setUp := func(t *testing.T) {
+		g = NewGomegaWithT(t)
+
+		apiErr = nil
+		synced = true
+		fakeClock = clock.NewFakeClock(time.Now())
+		checker = health.NewChecker(
+			logging.New(os.Stderr, logging.Debug),
+			fakeClock,
+			time.Second,
+			time.Minute,
+			map[string]health.Check{
+				"metrics-api": func(context.Context) error { return apiErr },
+				"pod-informer": func(context.Context) error {
+					if !synced {
+						return errors.New("not synced")
+					}
+					return nil
+				},
+			},
+			"logcache.v1.Egress",
+		)
+	}
+
+	servingStatus := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := checker.HealthServer().Check(context.Background(), &healthpb.HealthCheckRequest{
+			Service: service,
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+		return resp.Status
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		checker.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	t.Run("it is not serving before the first check", func(t *testing.T) {
+		setUp(t)
+
+		g.Expect(checker.Ready()).To(BeFalse())
+		g.Expect(servingStatus("")).To(Equal(healthpb.HealthCheckResponse_NOT_SERVING))
+		g.Expect(get("/readyz").Code).To(Equal(http.StatusServiceUnavailable))
+		g.Expect(get("/healthz").Code).To(Equal(http.StatusOK))
+	})
+
+	t.Run("it is serving when all checks pass", func(t *testing.T) {
+		setUp(t)
+
+		checker.Check()
+
+		g.Expect(checker.Ready()).To(BeTrue())
+		g.Expect(servingStatus("")).To(Equal(healthpb.HealthCheckResponse_SERVING))
+		g.Expect(servingStatus("logcache.v1.Egress")).To(Equal(healthpb.HealthCheckResponse_SERVING))
+		g.Expect(get("/readyz").Code).To(Equal(http.StatusOK))
+	})
+
+	t.Run("it is not ready until the pod informer has synced", func(t *testing.T) {
+		setUp(t)
+		synced = false
+
+		checker.Check()
+
+		g.Expect(checker.Ready()).To(BeFalse())
+		rec := get("/readyz")
+		g.Expect(rec.Code).To(Equal(http.StatusServiceUnavailable))
+		g.Expect(rec.Body.String()).To(ContainSubstring("pod-informer: not synced"))
+	})
+
+	t.Run("it keeps serving while the apiserver is briefly unreachable", func(t *testing.T) {
+		setUp(t)
+		checker.Check()
+
+		apiErr = errors.New("connection refused")
+		fakeClock.Step(30 * time.Second)
+		checker.Check()
+
+		g.Expect(checker.Ready()).To(BeTrue())
+		g.Expect(servingStatus("")).To(Equal(healthpb.HealthCheckResponse_SERVING))
+	})
+
+	t.Run("it degrades to not serving when the apiserver stays unreachable", func(t *testing.T) {
+		setUp(t)
+		checker.Check()
+
+		apiErr = errors.New("connection refused")
+		fakeClock.Step(2 * time.Minute)
+		checker.Check()
+
+		g.Expect(checker.Ready()).To(BeFalse())
+		g.Expect(servingStatus("")).To(Equal(healthpb.HealthCheckResponse_NOT_SERVING))
+		g.Expect(get("/readyz").Body.String()).To(ContainSubstring("metrics-api: connection refused"))
+
+		apiErr = nil
+		checker.Check()
+
+		g.Expect(servingStatus("")).To(Equal(healthpb.HealthCheckResponse_SERVING))
+	})
+
+	t.Run("it stops serving once shut down", func(t *testing.T) {
+		setUp(t)
+		checker.Check()
+
+		checker.Shutdown()
+		checker.Check()
+
+		g.Expect(checker.Ready()).To(BeFalse())
+		g.Expect(servingStatus("")).To(Equal(healthpb.HealthCheckResponse_NOT_SERVING))
+		g.Expect(get("/readyz").Body.String()).To(ContainSubstring("shutting down"))
+		g.Expect(get("/healthz").Code).To(Equal(http.StatusOK))
+	})
+	t.Run("it fails checks that outlive the check timeout", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		hung := make(chan struct{})
+		defer close(hung)
+		checker := health.NewChecker(
+			logging.New(os.Stderr, logging.Debug),
+			clock.NewFakeClock(time.Now()),
+			10*time.Millisecond,
+			time.Minute,
+			map[string]health.Check{
+				"metrics-api": func(ctx context.Context) error {
+					<-ctx.Done()
+					return ctx.Err()
+				},
+				"pod-informer": func(context.Context) error {
+					<-hung
+					return nil
+				},
+			},
+		)
+
+		done := make(chan struct{})
+		go func() {
+			checker.Check()
+			checker.Check()
+			close(done)
+		}()
+		g.Eventually(done).Should(BeClosed())
+
+		rec := httptest.NewRecorder()
+		checker.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		g.Expect(rec.Code).To(Equal(http.StatusServiceUnavailable))
+		g.Expect(rec.Body.String()).To(ContainSubstring("metrics-api: timed out after 10ms"))
+		g.Expect(rec.Body.String()).To(ContainSubstring("pod-informer: still running after 10ms"))
+	})
+}
@@ -0,0 +1,78 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// RequestIDMetadataKey lets clients supply their own request ID.
+const RequestIDMetadataKey = "x-request-id"
+
+// UnaryServerInterceptor attaches a logger carrying a request ID and the gRPC
+// method to the context of every call.
+func UnaryServerInterceptor(l *Logger) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		logger := l.With(
+			"request_id", requestID(ctx),
+			"method", info.FullMethod,
+		)
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			logger = logger.With("trace_id", sc.TraceID().String())
+		}
+
+		return handler(NewContext(ctx, logger), req)
+	}
+}
+
+// HTTPMiddleware attaches a logger carrying a request ID and the path to the
+// context of every HTTP request. Clients can supply the request ID in the
+// X-Request-Id header.
+func HTTPMiddleware(l *Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(RequestIDMetadataKey)
+			if id == "" {
+				id = newRequestID()
+			}
+
+			logger := l.With(
+				"request_id", id,
+				"path", r.URL.Path,
+			)
+			if sc := trace.SpanContextFromContext(r.Context()); sc.IsValid() {
+				logger = logger.With("trace_id", sc.TraceID().String())
+			}
+
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), logger)))
+		})
+	}
+}
+
+func requestID(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(RequestIDMetadataKey); len(ids) > 0 && ids[0] != "" {
+			return ids[0]
+		}
+	}
+
+	return newRequestID()
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
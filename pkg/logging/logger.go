@@ -0,0 +1,177 @@
+// Package logging writes leveled, structured JSON log lines.
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Level int
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+func (l Level) String() string {
+	switch l {
+	case Debug:
+		return "debug"
+	case Info:
+		return "info"
+	case Warn:
+		return "warn"
+	default:
+		return "error"
+	}
+}
+
+// ParseLevel parses a level name such as "info", case-insensitively.
+func ParseLevel(s string) (Level, error) {
+	for _, l := range []Level{Debug, Info, Warn, Error} {
+		if strings.EqualFold(s, l.String()) {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// Logger writes one JSON object per line. Loggers derived through With share
+// the underlying writer and level.
+type Logger struct {
+	out    *syncWriter
+	level  *int32
+	fields map[string]interface{}
+	now    func() time.Time
+}
+
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func New(w io.Writer, level Level) *Logger {
+	l := int32(level)
+	return &Logger{
+		out:    &syncWriter{w: w},
+		level:  &l,
+		fields: map[string]interface{}{},
+		now:    time.Now,
+	}
+}
+
+// With returns a logger that adds the given key/value pairs to every line.
+func (l *Logger) With(keysAndValues ...interface{}) *Logger {
+	fields := make(map[string]interface{}, len(l.fields)+len(keysAndValues)/2)
+	for k, v := range l.fields {
+		fields[k] = v
+	}
+	addFields(fields, keysAndValues)
+
+	return &Logger{
+		out:    l.out,
+		level:  l.level,
+		fields: fields,
+		now:    l.now,
+	}
+}
+
+// SetLevel changes the level of this logger and every logger sharing its
+// writer.
+func (l *Logger) SetLevel(level Level) {
+	atomic.StoreInt32(l.level, int32(level))
+}
+
+func (l *Logger) Debug(msg string, keysAndValues ...interface{}) {
+	l.log(Debug, msg, keysAndValues)
+}
+
+func (l *Logger) Info(msg string, keysAndValues ...interface{}) {
+	l.log(Info, msg, keysAndValues)
+}
+
+func (l *Logger) Warn(msg string, keysAndValues ...interface{}) {
+	l.log(Warn, msg, keysAndValues)
+}
+
+func (l *Logger) Error(msg string, keysAndValues ...interface{}) {
+	l.log(Error, msg, keysAndValues)
+}
+
+// Fatal logs at the error level and exits the process.
+func (l *Logger) Fatal(msg string, keysAndValues ...interface{}) {
+	l.log(Error, msg, keysAndValues)
+	os.Exit(1)
+}
+
+func (l *Logger) log(level Level, msg string, keysAndValues []interface{}) {
+	if level < Level(atomic.LoadInt32(l.level)) {
+		return
+	}
+
+	line := make(map[string]interface{}, len(l.fields)+len(keysAndValues)/2+3)
+	for k, v := range l.fields {
+		line[k] = v
+	}
+	addFields(line, keysAndValues)
+	line["timestamp"] = l.now().UTC().Format(time.RFC3339Nano)
+	line["level"] = level.String()
+	line["message"] = msg
+
+	b, err := json.Marshal(line)
+	if err != nil {
+		b, _ = json.Marshal(map[string]interface{}{
+			"timestamp": line["timestamp"],
+			"level":     Error.String(),
+			"message":   fmt.Sprintf("failed to marshal log line %q: %v", msg, err),
+		})
+	}
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	_, _ = l.out.w.Write(append(b, '\n'))
+}
+
+func addFields(fields map[string]interface{}, keysAndValues []interface{}) {
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key := fmt.Sprint(keysAndValues[i])
+		if i+1 == len(keysAndValues) {
+			fields[key] = nil
+			break
+		}
+
+		switch v := keysAndValues[i+1].(type) {
+		case error:
+			fields[key] = v.Error()
+		case fmt.Stringer:
+			fields[key] = v.String()
+		default:
+			fields[key] = v
+		}
+	}
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the given logger.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger carried by ctx, or fallback if there is
+// none.
+func FromContext(ctx context.Context, fallback *Logger) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+	return fallback
+}
@@ -0,0 +1,110 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"code.cloudfoundry.org/metric-proxy/pkg/logging"
+	. "github.com/onsi/gomega"
+)
+
+func TestLogger(t *testing.T) {
+	lines := func(g *GomegaWithT, buf *bytes.Buffer) []map[string]interface{} {
+		var result []map[string]interface{}
+		for _, l := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			if l == "" {
+				continue
+			}
+			var line map[string]interface{}
+			g.Expect(json.Unmarshal([]byte(l), &line)).To(Succeed())
+			result = append(result, line)
+		}
+		return result
+	}
+
+	t.Run("it writes JSON lines with fields", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		var buf bytes.Buffer
+
+		logger := logging.New(&buf, logging.Info).With("request_id", "abc")
+		logger.Info("hello", "count", 3, "error", errors.New("boom"))
+
+		result := lines(g, &buf)
+		g.Expect(result).To(HaveLen(1))
+		g.Expect(result[0]).To(HaveKeyWithValue("level", "info"))
+		g.Expect(result[0]).To(HaveKeyWithValue("message", "hello"))
+		g.Expect(result[0]).To(HaveKeyWithValue("request_id", "abc"))
+		g.Expect(result[0]).To(HaveKeyWithValue("count", BeNumerically("==", 3)))
+		g.Expect(result[0]).To(HaveKeyWithValue("error", "boom"))
+		g.Expect(result[0]).To(HaveKey("timestamp"))
+	})
+
+	t.Run("it drops lines below the configured level", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		var buf bytes.Buffer
+
+		logger := logging.New(&buf, logging.Warn)
+		logger.Debug("debug")
+		logger.Info("info")
+		logger.Warn("warn")
+		logger.Error("error")
+
+		result := lines(g, &buf)
+		g.Expect(result).To(HaveLen(2))
+		g.Expect(result[0]).To(HaveKeyWithValue("level", "warn"))
+		g.Expect(result[1]).To(HaveKeyWithValue("level", "error"))
+	})
+
+	t.Run("SetLevel applies to derived loggers", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		var buf bytes.Buffer
+
+		parent := logging.New(&buf, logging.Info)
+		child := parent.With("source_id", "child")
+		child.Debug("dropped")
+
+		parent.SetLevel(logging.Debug)
+		child.Debug("kept")
+
+		result := lines(g, &buf)
+		g.Expect(result).To(HaveLen(1))
+		g.Expect(result[0]).To(HaveKeyWithValue("message", "kept"))
+	})
+
+	t.Run("With does not modify the parent logger", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+		var buf bytes.Buffer
+
+		parent := logging.New(&buf, logging.Info)
+		parent.With("source_id", "child")
+		parent.Info("parent")
+
+		g.Expect(lines(g, &buf)[0]).ToNot(HaveKey("source_id"))
+	})
+
+	t.Run("it parses levels", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		level, err := logging.ParseLevel("DEBUG")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(level).To(Equal(logging.Debug))
+
+		_, err = logging.ParseLevel("verbose")
+		g.Expect(err).To(MatchError(`unknown log level "verbose"`))
+	})
+
+	t.Run("it carries a logger in a context", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		fallback := logging.New(&bytes.Buffer{}, logging.Info)
+		logger := logging.New(&bytes.Buffer{}, logging.Info)
+
+		g.Expect(logging.FromContext(context.Background(), fallback)).To(BeIdenticalTo(fallback))
+		ctx := logging.NewContext(context.Background(), logger)
+		g.Expect(logging.FromContext(ctx, fallback)).To(BeIdenticalTo(logger))
+	})
+}
@@ -0,0 +1,194 @@
+package metrics
+
+import (
+	"net/http"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/metric-proxy/pkg/logging"
+	"code.cloudfoundry.org/metric-proxy/pkg/rpc/metricproxy_v1"
+	"github.com/golang/protobuf/jsonpb"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SourceListerFn returns the app guids of the pods carrying all of the given
+// labels.
+type SourceListerFn func(labels map[string]string) ([]string, error)
+
+// AggregatorLabels are the pod labels identifying an app's space and org.
+type AggregatorLabels struct {
+	Space string
+	Org   string
+}
+
+// Aggregator serves the metricproxy.v1.Aggregator API. It reads every app in
+// a space or org through BatchProxy and sums the resulting envelopes.
+type Aggregator struct {
+	logger         *logging.Logger
+	batchProxy     *BatchProxy
+	sourceListerFn SourceListerFn
+	labels         AggregatorLabels
+}
+
+func NewAggregator(logger *logging.Logger, batchProxy *BatchProxy, sourceListerFn SourceListerFn, labels AggregatorLabels) *Aggregator {
+	return &Aggregator{
+		logger:         logger,
+		batchProxy:     batchProxy,
+		sourceListerFn: sourceListerFn,
+		labels:         labels,
+	}
+}
+
+func (a *Aggregator) AggregateRead(ctx context.Context, req *metricproxy_v1.AggregateReadRequest) (*metricproxy_v1.AggregateReadResponse, error) {
+	selector, err := a.selector(req)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := logging.FromContext(ctx, a.logger).With("selector", selector)
+	ctx = logging.NewContext(ctx, logger)
+
+	sourceIDs, err := a.sourceListerFn(selector)
+	if err != nil {
+		logger.Error("failed to list sources", "error", err)
+		return nil, err
+	}
+
+	resp := &metricproxy_v1.AggregateReadResponse{
+		Total:   &metricproxy_v1.Usage{},
+		Sources: make(map[string]*metricproxy_v1.Usage, len(sourceIDs)),
+	}
+
+	err = ForEachBatch(sourceIDs, func(sourceIDs []string) error {
+		batch, err := a.batchProxy.BatchRead(ctx, &metricproxy_v1.BatchReadRequest{
+			SourceIds: sourceIDs,
+		})
+		if err != nil {
+			return err
+		}
+
+		for sourceID, envelopes := range batch.GetEnvelopes() {
+			usage := sumEnvelopes(envelopes.GetBatch())
+			resp.Sources[sourceID] = usage
+			addUsage(resp.Total, usage)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Debug("aggregate read complete", "sources", len(sourceIDs))
+
+	return resp, nil
+}
+
+// SourceIDs returns the source IDs an AggregateReadRequest reads, so that
+// rate limiting can take a source token for each of them. Other requests,
+// and requests AggregateRead rejects, have none.
+func (a *Aggregator) SourceIDs(req interface{}) []string {
+	r, ok := req.(*metricproxy_v1.AggregateReadRequest)
+	if !ok {
+		return nil
+	}
+
+	selector, err := a.selector(r)
+	if err != nil {
+		return nil
+	}
+	sourceIDs, err := a.sourceListerFn(selector)
+	if err != nil {
+		return nil
+	}
+	return sourceIDs
+}
+
+// HTTPSourceIDs is SourceIDs for requests to Handler.
+func (a *Aggregator) HTTPSourceIDs(r *http.Request) []string {
+	return a.SourceIDs(httpAggregateReadRequest(r))
+}
+
+func (a *Aggregator) selector(req *metricproxy_v1.AggregateReadRequest) (map[string]string, error) {
+	switch {
+	case req.GetSpaceGuid() != "" && req.GetOrgGuid() != "":
+		return nil, status.Error(codes.InvalidArgument, "only one of space_guid and org_guid may be set")
+	case req.GetSpaceGuid() != "":
+		return map[string]string{a.labels.Space: req.GetSpaceGuid()}, nil
+	case req.GetOrgGuid() != "":
+		return map[string]string{a.labels.Org: req.GetOrgGuid()}, nil
+	default:
+		return nil, status.Error(codes.InvalidArgument, "one of space_guid or org_guid is required")
+	}
+}
+
+// Handler serves AggregateRead over HTTP at /api/v1/aggregate, taking a
+// space_guid or org_guid query parameter.
+func (a *Aggregator) Handler() http.Handler {
+	marshaler := &jsonpb.Marshaler{OrigName: true, EmitDefaults: true}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/aggregate", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		resp, err := a.AggregateRead(r.Context(), httpAggregateReadRequest(r))
+		if err != nil {
+			http.Error(w, status.Convert(err).Message(), httpStatus(status.Code(err)))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := marshaler.Marshal(w, resp); err != nil {
+			logging.FromContext(r.Context(), a.logger).Error("failed to write aggregate response", "error", err)
+		}
+	})
+
+	return mux
+}
+
+func httpAggregateReadRequest(r *http.Request) *metricproxy_v1.AggregateReadRequest {
+	return &metricproxy_v1.AggregateReadRequest{
+		SpaceGuid: r.URL.Query().Get("space_guid"),
+		OrgGuid:   r.URL.Query().Get("org_guid"),
+	}
+}
+
+func sumEnvelopes(envelopes []*loggregator_v2.Envelope) *metricproxy_v1.Usage {
+	usage := &metricproxy_v1.Usage{}
+	for _, e := range envelopes {
+		for name, gauge := range e.GetGauge().GetMetrics() {
+			switch name {
+			case "cpu":
+				usage.CpuPercentage += gauge.GetValue()
+			case "memory":
+				usage.MemoryBytes += uint64(gauge.GetValue())
+			case "disk":
+				usage.DiskBytes += uint64(gauge.GetValue())
+			case "memory_quota":
+				usage.MemoryQuotaBytes += uint64(gauge.GetValue())
+			case "disk_quota":
+				usage.DiskQuotaBytes += uint64(gauge.GetValue())
+			case "state":
+				usage.Instances++
+				if InstanceState(gauge.GetValue()) == InstanceStateRunning {
+					usage.RunningInstances++
+				}
+			}
+		}
+	}
+
+	return usage
+}
+
+func addUsage(total, usage *metricproxy_v1.Usage) {
+	total.CpuPercentage += usage.GetCpuPercentage()
+	total.MemoryBytes += usage.GetMemoryBytes()
+	total.DiskBytes += usage.GetDiskBytes()
+	total.MemoryQuotaBytes += usage.GetMemoryQuotaBytes()
+	total.DiskQuotaBytes += usage.GetDiskQuotaBytes()
+	total.Instances += usage.GetInstances()
+	total.RunningInstances += usage.GetRunningInstances()
+}
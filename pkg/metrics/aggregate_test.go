@@ -0,0 +1,157 @@
+package metrics_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"code.cloudfoundry.org/metric-proxy/pkg/logging"
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics"
+	"code.cloudfoundry.org/metric-proxy/pkg/rpc/metricproxy_v1"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAggregatorAggregateRead(t *testing.T) {
+	labels := metrics.AggregatorLabels{Space: "space-label", Org: "org-label"}
+
+	t.Run("it sums usage across every app in a space", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		f := newFakeBatchMetricsFetcher(map[string][]string{
+			"app-a": {"app-a-0", "app-a-1"},
+			"app-b": {"app-b-0"},
+		})
+		sourceLister := newFakeSourceLister("app-a", "app-b", "app-c")
+		a := newAggregator(f, sourceLister.List, labels)
+
+		resp, err := a.AggregateRead(context.Background(), &metricproxy_v1.AggregateReadRequest{
+			SpaceGuid: "some-space",
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+
+		g.Expect(sourceLister.selectors).To(Equal([]map[string]string{{"space-label": "some-space"}}))
+		g.Expect(f.calls).To(HaveLen(1))
+
+		g.Expect(resp.GetTotal().GetCpuPercentage()).To(BeNumerically("~", 126))
+		g.Expect(resp.GetTotal().GetMemoryBytes()).To(Equal(uint64(3072)))
+		g.Expect(resp.GetTotal().GetInstances()).To(Equal(uint32(3)))
+		g.Expect(resp.GetTotal().GetRunningInstances()).To(Equal(uint32(3)))
+
+		g.Expect(resp.GetSources()).To(HaveLen(3))
+		g.Expect(resp.GetSources()["app-a"].GetInstances()).To(Equal(uint32(2)))
+		g.Expect(resp.GetSources()["app-b"].GetMemoryBytes()).To(Equal(uint64(1024)))
+		g.Expect(resp.GetSources()["app-c"].GetInstances()).To(BeZero())
+	})
+
+	t.Run("it selects apps by org", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		sourceLister := newFakeSourceLister()
+		a := newAggregator(newFakeBatchMetricsFetcher(nil), sourceLister.List, labels)
+
+		resp, err := a.AggregateRead(context.Background(), &metricproxy_v1.AggregateReadRequest{
+			OrgGuid: "some-org",
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(sourceLister.selectors).To(Equal([]map[string]string{{"org-label": "some-org"}}))
+		g.Expect(resp.GetTotal().GetInstances()).To(BeZero())
+	})
+
+	t.Run("it reads large spaces in several batches", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		var guids []string
+		for i := 0; i < metrics.MaxBatchSourceIDs+1; i++ {
+			guids = append(guids, fmt.Sprintf("app-%03d", i))
+		}
+		f := newFakeBatchMetricsFetcher(nil)
+		a := newAggregator(f, newFakeSourceLister(guids...).List, labels)
+
+		resp, err := a.AggregateRead(context.Background(), &metricproxy_v1.AggregateReadRequest{
+			SpaceGuid: "some-space",
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(f.calls).To(HaveLen(2))
+		g.Expect(resp.GetSources()).To(HaveLen(metrics.MaxBatchSourceIDs + 1))
+	})
+
+	t.Run("it requires exactly one of space and org", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		a := newAggregator(newFakeBatchMetricsFetcher(nil), newFakeSourceLister().List, labels)
+
+		_, err := a.AggregateRead(context.Background(), &metricproxy_v1.AggregateReadRequest{})
+		g.Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+
+		_, err = a.AggregateRead(context.Background(), &metricproxy_v1.AggregateReadRequest{
+			SpaceGuid: "some-space",
+			OrgGuid:   "some-org",
+		})
+		g.Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+	})
+}
+
+func TestAggregatorHandler(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	f := newFakeBatchMetricsFetcher(map[string][]string{"app-a": {"app-a-0"}})
+	a := newAggregator(f, newFakeSourceLister("app-a").List, metrics.AggregatorLabels{Space: "space-label"})
+	server := httptest.NewServer(a.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/api/v1/aggregate?space_guid=some-space")
+	g.Expect(err).ToNot(HaveOccurred())
+	defer resp.Body.Close()
+	g.Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+	var body struct {
+		Total struct {
+			MemoryBytes string `json:"memory_bytes"`
+			Instances   int    `json:"instances"`
+		} `json:"total"`
+	}
+	g.Expect(json.NewDecoder(resp.Body).Decode(&body)).To(Succeed())
+	g.Expect(body.Total.MemoryBytes).To(Equal("1024"))
+	g.Expect(body.Total.Instances).To(Equal(1))
+}
+
+func TestAggregatorSourceIDs(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	l := newFakeSourceLister("app-a", "app-b")
+	a := newAggregator(newFakeBatchMetricsFetcher(nil), l.List, metrics.AggregatorLabels{Space: "space-label", Org: "org-label"})
+
+	g.Expect(a.SourceIDs(&metricproxy_v1.AggregateReadRequest{OrgGuid: "some-org"})).To(Equal([]string{"app-a", "app-b"}))
+	g.Expect(l.selectors).To(Equal([]map[string]string{{"org-label": "some-org"}}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/aggregate?space_guid=some-space", nil)
+	g.Expect(a.HTTPSourceIDs(req)).To(Equal([]string{"app-a", "app-b"}))
+
+	g.Expect(a.SourceIDs(&metricproxy_v1.AggregateReadRequest{})).To(BeEmpty())
+	g.Expect(a.SourceIDs(&metricproxy_v1.BatchReadRequest{SourceIds: []string{"app-c"}})).To(BeEmpty())
+}
+
+func newAggregator(f *fakeBatchMetricsFetcher, l metrics.SourceListerFn, labels metrics.AggregatorLabels) *metrics.Aggregator {
+	logger := logging.New(os.Stderr, logging.Debug)
+	return metrics.NewAggregator(logger, newBatchProxy(f.GetMetrics, newPodLister()), l, labels)
+}
+
+type fakeSourceLister struct {
+	guids     []string
+	selectors []map[string]string
+}
+
+func newFakeSourceLister(guids ...string) *fakeSourceLister {
+	return &fakeSourceLister{guids: guids}
+}
+
+func (f *fakeSourceLister) List(selector map[string]string) ([]string, error) {
+	f.selectors = append(f.selectors, selector)
+	return f.guids, nil
+}
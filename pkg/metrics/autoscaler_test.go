@@ -0,0 +1,133 @@
+package metrics_test
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/log-cache/pkg/rpc/logcache_v1"
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics/metricsfakes"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// autoscalerQueries are the Reads app-autoscaler's log-cache metric fetcher
+// makes for each container metric type it scales on. throughput and
+// responsetime come from gorouter http timers, which metric-proxy doesn't
+// have.
+var autoscalerQueries = map[string]struct {
+	envelopeType logcache_v1.EnvelopeType
+	nameFilter   string
+}{
+	"memoryused": {logcache_v1.EnvelopeType_GAUGE, "memory"},
+	"memoryutil": {logcache_v1.EnvelopeType_GAUGE, "memory|memory_quota"},
+	"cpu":        {logcache_v1.EnvelopeType_GAUGE, "cpu"},
+}
+
+func TestAutoscalerCompatibility(t *testing.T) {
+	pod := newRunningPod()
+	pod.Spec.Containers = []corev1.Container{{
+		Name: "test-app",
+		Resources: corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+		},
+	}}
+	f := newFakeMetricsFetcher(corev1.ResourceList{
+		"cpu":    *resource.NewScaledQuantity(420000000, resource.Nano),
+		"memory": *resource.NewQuantity(256<<20, resource.BinarySI),
+	})
+	// GetMetrics blocks once processGUID is full.
+	f.processGUID = make(chan string, 16)
+	stop, err := startGRPCServerWithPods(f.GetMetrics, newPodLister(pod), new(metricsfakes.FakeDiskUsageFetcher), newFakePodGetter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	conn, err := grpc.Dial(":8080", grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := logcache_v1.NewEgressClient(conn)
+
+	read := func(g *GomegaWithT, metricType string) []*loggregator_v2.Envelope {
+		query := autoscalerQueries[metricType]
+		now := time.Now()
+		resp, err := client.Read(context.Background(), &logcache_v1.ReadRequest{
+			SourceId:      "app-guid",
+			StartTime:     now.Add(-30 * time.Second).UnixNano(),
+			EndTime:       now.UnixNano(),
+			EnvelopeTypes: []logcache_v1.EnvelopeType{query.envelopeType},
+			NameFilter:    query.nameFilter,
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+		return resp.GetEnvelopes().GetBatch()
+	}
+
+	for metricType, expected := range map[string]float64{
+		"memoryused": 256,
+		"memoryutil": 25,
+		"cpu":        42,
+	} {
+		metricType, expected := metricType, expected
+		t.Run("it serves "+metricType, func(t *testing.T) {
+			g := NewGomegaWithT(t)
+
+			instanceMetrics := autoscalerGaugeMetrics(read(g, metricType))
+			g.Expect(instanceMetrics).To(HaveKeyWithValue(metricType, map[string]float64{"0": expected}))
+		})
+	}
+
+	t.Run("it returns only the envelopes matching the name filter", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		envelopes := read(g, "cpu")
+		g.Expect(envelopes).To(HaveLen(1))
+		g.Expect(envelopes[0].GetGauge().GetMetrics()).To(HaveKey("cpu"))
+	})
+
+	t.Run("it rejects an invalid name filter", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		_, err := client.Read(context.Background(), &logcache_v1.ReadRequest{
+			SourceId:   "app-guid",
+			NameFilter: "memory(",
+		})
+		g.Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+	})
+}
+
+// autoscalerGaugeMetrics converts gauge envelopes the way app-autoscaler's
+// envelope processor does, returning each metric type's value by instance
+// ID: memory in whole MB, and memory utilization and CPU in whole percent.
+// memoryutil requires memory and memory_quota in the same envelope.
+func autoscalerGaugeMetrics(envelopes []*loggregator_v2.Envelope) map[string]map[string]float64 {
+	byType := map[string]map[string]float64{}
+	add := func(metricType, instanceID string, value float64) {
+		if byType[metricType] == nil {
+			byType[metricType] = map[string]float64{}
+		}
+		byType[metricType][instanceID] = math.Ceil(value)
+	}
+
+	for _, e := range envelopes {
+		gauges := e.GetGauge().GetMetrics()
+		if memory, ok := gauges["memory"]; ok {
+			add("memoryused", e.GetInstanceId(), memory.GetValue()/(1024*1024))
+			if quota, ok := gauges["memory_quota"]; ok && quota.GetValue() != 0 {
+				add("memoryutil", e.GetInstanceId(), memory.GetValue()/quota.GetValue()*100)
+			}
+		}
+		if cpu, ok := gauges["cpu"]; ok {
+			add("cpu", e.GetInstanceId(), cpu.GetValue())
+		}
+	}
+	return byType
+}
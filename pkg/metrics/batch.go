@@ -0,0 +1,170 @@
+package metrics
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/log-cache/pkg/rpc/logcache_v1"
+	"code.cloudfoundry.org/metric-proxy/pkg/logging"
+	"code.cloudfoundry.org/metric-proxy/pkg/rpc/metricproxy_v1"
+	"code.cloudfoundry.org/metric-proxy/pkg/selfmetrics"
+	"code.cloudfoundry.org/metric-proxy/pkg/tracing"
+	"github.com/golang/protobuf/jsonpb"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+// MaxBatchSourceIDs bounds the size of the label selector sent to the
+// metrics API.
+const MaxBatchSourceIDs = 100
+
+// ForEachBatch calls fn with consecutive batches of at most
+// MaxBatchSourceIDs source IDs, stopping at the first error.
+func ForEachBatch(sourceIDs []string, fn func(batch []string) error) error {
+	for start := 0; start < len(sourceIDs); start += MaxBatchSourceIDs {
+		end := start + MaxBatchSourceIDs
+		if end > len(sourceIDs) {
+			end = len(sourceIDs)
+		}
+		if err := fn(sourceIDs[start:end]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BatchMetricsFetcherFn returns the pod metrics of several app guids, keyed
+// by guid, from a single metrics API request.
+type BatchMetricsFetcherFn func(ctx context.Context, guids []string) (map[string]*v1beta1.PodMetricsList, error)
+
+// BatchProxy serves the metricproxy.v1.Egress API, building each source's
+// envelopes the same way as Proxy.Read.
+type BatchProxy struct {
+	proxy                 *Proxy
+	batchMetricsFetcherFn BatchMetricsFetcherFn
+}
+
+func NewBatchProxy(proxy *Proxy, batchMetricsFetcherFn BatchMetricsFetcherFn) *BatchProxy {
+	return &BatchProxy{
+		proxy:                 proxy,
+		batchMetricsFetcherFn: batchMetricsFetcherFn,
+	}
+}
+
+func (b *BatchProxy) BatchRead(ctx context.Context, req *metricproxy_v1.BatchReadRequest) (*metricproxy_v1.BatchReadResponse, error) {
+	sourceIDs := uniqueSourceIDs(req.GetSourceIds())
+	if len(sourceIDs) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "at least one source ID is required")
+	}
+	if len(sourceIDs) > MaxBatchSourceIDs {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d source IDs may be read at once, got %d", MaxBatchSourceIDs, len(sourceIDs))
+	}
+
+	logger := logging.FromContext(ctx, b.proxy.logger).With("source_ids", len(sourceIDs))
+
+	metricsByID, err := b.fetchMetrics(ctx, sourceIDs)
+	if err != nil {
+		logger.Error("failed to get metrics", "error", err)
+		return nil, err
+	}
+
+	resp := &metricproxy_v1.BatchReadResponse{
+		Envelopes: make(map[string]*loggregator_v2.EnvelopeBatch, len(sourceIDs)),
+	}
+	for _, sourceID := range sourceIDs {
+		podMetrics, ok := metricsByID[sourceID]
+		if !ok {
+			podMetrics = &v1beta1.PodMetricsList{}
+		}
+
+		sourceCtx := logging.NewContext(ctx, logger.With("source_id", sourceID))
+		envelopes, err := b.proxy.createEnvelopes(sourceCtx, &logcache_v1.ReadRequest{SourceId: sourceID}, podMetrics)
+		if err != nil {
+			return nil, err
+		}
+		resp.Envelopes[sourceID] = &loggregator_v2.EnvelopeBatch{Batch: envelopes}
+	}
+
+	return resp, nil
+}
+
+func (b *BatchProxy) fetchMetrics(ctx context.Context, guids []string) (map[string]*v1beta1.PodMetricsList, error) {
+	start := time.Now()
+	ctx, span := tracing.StartSpan(ctx, "metrics-api.list", attribute.Int("source_ids", len(guids)))
+	metricsByID, err := b.batchMetricsFetcherFn(ctx, guids)
+	tracing.End(span, err)
+	b.proxy.selfMetrics.ObserveUpstream(selfmetrics.DependencyMetricsAPI, start, err)
+
+	return metricsByID, err
+}
+
+// Handler serves BatchRead over HTTP at /api/v1/batch-read. Source IDs are
+// given as repeated or comma-separated source_id query parameters, and the
+// response is the BatchReadResponse as JSON.
+func (b *BatchProxy) Handler() http.Handler {
+	marshaler := &jsonpb.Marshaler{OrigName: true}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/batch-read", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		resp, err := b.BatchRead(r.Context(), &metricproxy_v1.BatchReadRequest{SourceIds: HTTPSourceIDs(r)})
+		if err != nil {
+			http.Error(w, status.Convert(err).Message(), httpStatus(status.Code(err)))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if err := marshaler.Marshal(w, resp); err != nil {
+			logging.FromContext(r.Context(), b.proxy.logger).Error("failed to write batch read response", "error", err)
+		}
+	})
+
+	return mux
+}
+
+// HTTPSourceIDs returns the source IDs of a batch read request, given as
+// repeated or comma-separated source_id query parameters.
+func HTTPSourceIDs(r *http.Request) []string {
+	var sourceIDs []string
+	for _, v := range r.URL.Query()["source_id"] {
+		sourceIDs = append(sourceIDs, strings.Split(v, ",")...)
+	}
+	return sourceIDs
+}
+
+func httpStatus(code codes.Code) int {
+	switch code {
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func uniqueSourceIDs(sourceIDs []string) []string {
+	seen := make(map[string]bool, len(sourceIDs))
+	var unique []string
+	for _, id := range sourceIDs {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		unique = append(unique, id)
+	}
+
+	sort.Strings(unique)
+	return unique
+}
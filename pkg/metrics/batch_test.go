@@ -0,0 +1,241 @@
+package metrics_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"code.cloudfoundry.org/metric-proxy/pkg/logging"
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics"
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics/metricsfakes"
+	"code.cloudfoundry.org/metric-proxy/pkg/rpc/metricproxy_v1"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+func TestBatchProxyBatchRead(t *testing.T) {
+	t.Run("it returns envelopes grouped by source ID from one metrics request", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		f := newFakeBatchMetricsFetcher(map[string][]string{
+			"app-a": {"app-a-0", "app-a-1"},
+			"app-b": {"app-b-0"},
+		})
+		stop, err := startBatchGRPCServer(newBatchProxy(f.GetMetrics, newPodLister()))
+		g.Expect(err).ToNot(HaveOccurred())
+		defer stop()
+
+		conn, err := grpc.Dial(":8080", grpc.WithInsecure())
+		g.Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		client := metricproxy_v1.NewEgressClient(conn)
+		resp, err := client.BatchRead(context.Background(), &metricproxy_v1.BatchReadRequest{
+			SourceIds: []string{"app-b", "app-a", "app-c", "app-a"},
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+
+		g.Expect(f.calls).To(Equal([][]string{{"app-a", "app-b", "app-c"}}))
+		g.Expect(resp.GetEnvelopes()).To(HaveLen(3))
+
+		// cpu, memory, disk and instance envelopes per pod
+		g.Expect(resp.GetEnvelopes()["app-a"].GetBatch()).To(HaveLen(8))
+		g.Expect(resp.GetEnvelopes()["app-b"].GetBatch()).To(HaveLen(4))
+		g.Expect(resp.GetEnvelopes()["app-c"].GetBatch()).To(BeEmpty())
+
+		for sourceID, batch := range resp.GetEnvelopes() {
+			for _, e := range batch.GetBatch() {
+				g.Expect(e.GetSourceId()).To(Equal(sourceID))
+			}
+		}
+	})
+
+	t.Run("it includes placeholders for a source's pods without metrics", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		f := newFakeBatchMetricsFetcher(nil)
+		b := newBatchProxy(f.GetMetrics, newPodLister(newRunningPodNamed("app-a-0")))
+
+		resp, err := b.BatchRead(context.Background(), &metricproxy_v1.BatchReadRequest{
+			SourceIds: []string{"app-a"},
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(resp.GetEnvelopes()["app-a"].GetBatch()).To(HaveLen(4))
+	})
+
+	t.Run("it rejects empty and oversized batches", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		b := newBatchProxy(newFakeBatchMetricsFetcher(nil).GetMetrics, newPodLister())
+
+		_, err := b.BatchRead(context.Background(), &metricproxy_v1.BatchReadRequest{SourceIds: []string{"", " "}})
+		g.Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+
+		ids := make([]string, metrics.MaxBatchSourceIDs+1)
+		for i := range ids {
+			ids[i] = string(rune('a'+i%26)) + string(rune('a'+i/26))
+		}
+		_, err = b.BatchRead(context.Background(), &metricproxy_v1.BatchReadRequest{SourceIds: ids})
+		g.Expect(status.Code(err)).To(Equal(codes.InvalidArgument))
+	})
+
+	t.Run("it returns metrics API errors", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		b := newBatchProxy(func(context.Context, []string) (map[string]*v1beta1.PodMetricsList, error) {
+			return nil, errors.New("metrics-server unavailable")
+		}, newPodLister())
+
+		_, err := b.BatchRead(context.Background(), &metricproxy_v1.BatchReadRequest{SourceIds: []string{"app-a"}})
+		g.Expect(err).To(MatchError("metrics-server unavailable"))
+	})
+}
+
+func TestForEachBatch(t *testing.T) {
+	var sourceIDs []string
+	for i := 0; i < 2*metrics.MaxBatchSourceIDs+1; i++ {
+		sourceIDs = append(sourceIDs, fmt.Sprintf("app-%d", i))
+	}
+
+	t.Run("it splits source IDs into batches of at most MaxBatchSourceIDs", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		var batches [][]string
+		g.Expect(metrics.ForEachBatch(sourceIDs, func(batch []string) error {
+			batches = append(batches, batch)
+			return nil
+		})).To(Succeed())
+
+		g.Expect(batches).To(HaveLen(3))
+		g.Expect(batches[0]).To(Equal(sourceIDs[:metrics.MaxBatchSourceIDs]))
+		g.Expect(batches[2]).To(Equal(sourceIDs[2*metrics.MaxBatchSourceIDs:]))
+	})
+
+	t.Run("it stops at the first error", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		calls := 0
+		err := metrics.ForEachBatch(sourceIDs, func([]string) error {
+			calls++
+			return errors.New("expected")
+		})
+
+		g.Expect(err).To(MatchError("expected"))
+		g.Expect(calls).To(Equal(1))
+	})
+}
+
+func TestBatchProxyHandler(t *testing.T) {
+	t.Run("it serves batch reads as JSON", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		f := newFakeBatchMetricsFetcher(map[string][]string{
+			"app-a": {"app-a-0"},
+			"app-b": {"app-b-0"},
+		})
+		server := httptest.NewServer(newBatchProxy(f.GetMetrics, newPodLister()).Handler())
+		defer server.Close()
+
+		resp, err := http.Get(server.URL + "/api/v1/batch-read?source_id=app-a,app-b&source_id=app-c")
+		g.Expect(err).ToNot(HaveOccurred())
+		defer resp.Body.Close()
+		g.Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		g.Expect(resp.Header.Get("Content-Type")).To(Equal("application/json"))
+
+		var body struct {
+			Envelopes map[string]struct {
+				Batch []json.RawMessage `json:"batch"`
+			} `json:"envelopes"`
+		}
+		g.Expect(json.NewDecoder(resp.Body).Decode(&body)).To(Succeed())
+		g.Expect(body.Envelopes).To(HaveKey("app-c"))
+		g.Expect(body.Envelopes["app-a"].Batch).To(HaveLen(4))
+		g.Expect(body.Envelopes["app-b"].Batch).To(HaveLen(4))
+	})
+
+	t.Run("it returns 400 without source IDs", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		server := httptest.NewServer(newBatchProxy(newFakeBatchMetricsFetcher(nil).GetMetrics, newPodLister()).Handler())
+		defer server.Close()
+
+		resp, err := http.Get(server.URL + "/api/v1/batch-read")
+		g.Expect(err).ToNot(HaveOccurred())
+		resp.Body.Close()
+		g.Expect(resp.StatusCode).To(Equal(http.StatusBadRequest))
+	})
+}
+
+func newBatchProxy(f metrics.BatchMetricsFetcherFn, l metrics.PodListerFn) *metrics.BatchProxy {
+	logger := logging.New(os.Stderr, logging.Debug)
+	unusedFetcher := func(context.Context, string) (*v1beta1.PodMetricsList, error) {
+		return nil, errors.New("BatchRead must not use the single source fetcher")
+	}
+	p := metrics.NewProxy(logger, nil, nil, unusedFetcher, l, new(metricsfakes.FakeDiskUsageFetcher), newFakePodGetter(), metrics.ProxyOptions{})
+
+	return metrics.NewBatchProxy(p, f)
+}
+
+func startBatchGRPCServer(b *metrics.BatchProxy) (stop func(), err error) {
+	s := grpc.NewServer()
+	metricproxy_v1.RegisterEgressServer(s, b)
+
+	lis, err := net.Listen("tcp", ":8080")
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		err := s.Serve(lis)
+		if err != nil {
+			panic(err)
+		}
+	}()
+
+	return s.GracefulStop, nil
+}
+
+type fakeBatchMetricsFetcher struct {
+	podsByGUID map[string][]string
+	calls      [][]string
+}
+
+func newFakeBatchMetricsFetcher(podsByGUID map[string][]string) *fakeBatchMetricsFetcher {
+	return &fakeBatchMetricsFetcher{podsByGUID: podsByGUID}
+}
+
+func (f *fakeBatchMetricsFetcher) GetMetrics(_ context.Context, guids []string) (map[string]*v1beta1.PodMetricsList, error) {
+	f.calls = append(f.calls, guids)
+
+	byGUID := map[string]*v1beta1.PodMetricsList{}
+	for _, guid := range guids {
+		for _, podName := range f.podsByGUID[guid] {
+			if byGUID[guid] == nil {
+				byGUID[guid] = &v1beta1.PodMetricsList{}
+			}
+			byGUID[guid].Items = append(byGUID[guid].Items, v1beta1.PodMetrics{
+				ObjectMeta: v1.ObjectMeta{Name: podName},
+				Containers: []v1beta1.ContainerMetrics{{
+					Name: "app",
+					Usage: corev1.ResourceList{
+						"cpu":    *resource.NewScaledQuantity(420000000, resource.Nano),
+						"memory": *resource.NewQuantity(1024, resource.BinarySI),
+					},
+				}},
+			})
+		}
+	}
+	return byGUID, nil
+}
@@ -0,0 +1,115 @@
+package metrics_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/log-cache/pkg/rpc/logcache_v1"
+	"code.cloudfoundry.org/metric-proxy/pkg/logging"
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics"
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics/metricsfakes"
+	"code.cloudfoundry.org/metric-proxy/pkg/selfmetrics"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+func BenchmarkProxyRead(b *testing.B) {
+	for _, instances := range []int{1, 10, 100} {
+		b.Run(fmt.Sprintf("%d instances", instances), func(b *testing.B) {
+			list, pods := benchmarkApp(instances)
+			diskUsageFetcher := new(metricsfakes.FakeDiskUsageFetcher)
+			diskUsageFetcher.DiskUsageReturns(150<<20, nil)
+
+			selfMetrics := selfmetrics.New(prometheus.NewRegistry(), []float64{1})
+			proxy := metrics.NewProxy(
+				logging.New(ioutil.Discard, logging.Error),
+				selfMetrics,
+				// Without a cache every Read converts the whole list.
+				metrics.NewMetricsCache(selfMetrics, cache.NewExpiring(), 0),
+				func(context.Context, string) (*v1beta1.PodMetricsList, error) { return list, nil },
+				func(string) ([]*corev1.Pod, error) { return pods, nil },
+				diskUsageFetcher,
+				new(metricsfakes.FakePodGetter),
+				metrics.ProxyOptions{},
+			)
+			req := &logcache_v1.ReadRequest{SourceId: "app-guid"}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := proxy.Read(context.Background(), req); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkAggregateContainerMetrics(b *testing.B) {
+	containers := []v1beta1.ContainerMetrics{
+		{Name: "opi", Usage: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("2340912n"),
+			corev1.ResourceMemory: resource.MustParse("27868Ki"),
+		}},
+		{Name: "istio-proxy", Usage: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("5125733n"),
+			corev1.ResourceMemory: resource.MustParse("40312Ki"),
+		}},
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		metrics.AggregateContainerMetrics(containers)
+	}
+}
+
+// benchmarkApp returns the metrics and pods of an app with the given number
+// of running instances, shaped like metrics-server and Eirini output.
+func benchmarkApp(instances int) (*v1beta1.PodMetricsList, []*corev1.Pod) {
+	started := v1.NewTime(time.Now().Add(-time.Hour))
+	list := &v1beta1.PodMetricsList{}
+	var pods []*corev1.Pod
+	for i := 0; i < instances; i++ {
+		meta := v1.ObjectMeta{Name: fmt.Sprintf("app-name-%d", i), Namespace: "cf-workloads"}
+		list.Items = append(list.Items, v1beta1.PodMetrics{
+			ObjectMeta: meta,
+			Containers: []v1beta1.ContainerMetrics{
+				{Name: "opi", Usage: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("2340912n"),
+					corev1.ResourceMemory: resource.MustParse("27868Ki"),
+				}},
+				{Name: "istio-proxy", Usage: corev1.ResourceList{
+					corev1.ResourceCPU:    resource.MustParse("5125733n"),
+					corev1.ResourceMemory: resource.MustParse("40312Ki"),
+				}},
+			},
+		})
+		pods = append(pods, &corev1.Pod{
+			ObjectMeta: meta,
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name: "opi",
+				Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{
+					corev1.ResourceMemory:           resource.MustParse("1Gi"),
+					corev1.ResourceEphemeralStorage: resource.MustParse("1Gi"),
+				}},
+			}}},
+			Status: corev1.PodStatus{
+				Phase:     corev1.PodRunning,
+				StartTime: &started,
+				ContainerStatuses: []corev1.ContainerStatus{{
+					Name:  "opi",
+					Ready: true,
+					State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{StartedAt: started}},
+				}},
+			},
+		})
+	}
+	return list, pods
+}
@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/metric-proxy/pkg/selfmetrics"
+	"golang.org/x/net/context"
+	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+// MetricsCache keeps metrics-server responses per source ID for a short TTL
+// and coalesces concurrent fetches for the same source ID into one call. A
+// nil *MetricsCache, or one with a zero TTL, always fetches.
+type MetricsCache struct {
+	selfMetrics *selfmetrics.Metrics
+	cache       *cache.Expiring
+
+	mu       sync.Mutex
+	ttl      time.Duration
+	inFlight map[string]*metricsCall
+}
+
+type metricsCall struct {
+	done       chan struct{}
+	podMetrics *v1beta1.PodMetricsList
+	err        error
+
+	// invalidated is set when the source's pods change mid-fetch, so the
+	// result may already be out of date.
+	invalidated bool
+}
+
+func NewMetricsCache(selfMetrics *selfmetrics.Metrics, cache *cache.Expiring, ttl time.Duration) *MetricsCache {
+	return &MetricsCache{
+		selfMetrics: selfMetrics,
+		cache:       cache,
+		ttl:         ttl,
+		inFlight:    map[string]*metricsCall{},
+	}
+}
+
+// Fetch returns the cached metrics for guid, joins a fetch already in flight
+// for it, or calls fetch. Joined callers share the first caller's context, so
+// they fail with it if it is cancelled. Errors are never cached.
+func (c *MetricsCache) Fetch(ctx context.Context, guid string, fetch MetricsFetcherFn) (*v1beta1.PodMetricsList, error) {
+	if c == nil {
+		return fetch(ctx, guid)
+	}
+
+	c.mu.Lock()
+	if c.ttl <= 0 {
+		c.mu.Unlock()
+		return fetch(ctx, guid)
+	}
+
+	if cached, ok := c.cache.Get(guid); ok {
+		c.mu.Unlock()
+		c.selfMetrics.MetricsCacheHit()
+		return cached.(*v1beta1.PodMetricsList), nil
+	}
+
+	if call, ok := c.inFlight[guid]; ok {
+		c.mu.Unlock()
+		c.selfMetrics.MetricsCacheHit()
+		<-call.done
+		return call.podMetrics, call.err
+	}
+
+	call := &metricsCall{done: make(chan struct{})}
+	c.inFlight[guid] = call
+	c.mu.Unlock()
+
+	c.selfMetrics.MetricsCacheMiss()
+	call.podMetrics, call.err = fetch(ctx, guid)
+
+	c.mu.Lock()
+	if c.inFlight[guid] == call {
+		delete(c.inFlight, guid)
+	}
+	if call.err == nil && !call.invalidated && c.ttl > 0 {
+		c.cache.Set(guid, call.podMetrics, c.ttl)
+	}
+	c.mu.Unlock()
+	close(call.done)
+
+	return call.podMetrics, call.err
+}
+
+// Invalidate drops the cached metrics for guid, e.g. because one of its pods
+// was added or deleted. A fetch already in flight for guid is not cached and
+// later callers don't join it.
+func (c *MetricsCache) Invalidate(guid string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.cache.Delete(guid)
+	if call, ok := c.inFlight[guid]; ok {
+		call.invalidated = true
+		delete(c.inFlight, guid)
+	}
+}
+
+// SetTTL changes how long later fetches are cached. Entries already cached
+// keep their expiry.
+func (c *MetricsCache) SetTTL(ttl time.Duration) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ttl = ttl
+}
@@ -0,0 +1,186 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics"
+	"code.cloudfoundry.org/metric-proxy/pkg/selfmetrics"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+func TestMetricsCache(t *testing.T) {
+	type countingFetcher struct {
+		sync.Mutex
+		calls int
+	}
+	newFetcher := func(release <-chan struct{}) (*countingFetcher, metrics.MetricsFetcherFn) {
+		c := &countingFetcher{}
+		return c, func(_ context.Context, guid string) (*v1beta1.PodMetricsList, error) {
+			c.Lock()
+			c.calls++
+			c.Unlock()
+			if release != nil {
+				<-release
+			}
+			return &v1beta1.PodMetricsList{Items: []v1beta1.PodMetrics{{}}}, nil
+		}
+	}
+	callCount := func(c *countingFetcher) func() int {
+		return func() int {
+			c.Lock()
+			defer c.Unlock()
+			return c.calls
+		}
+	}
+
+	t.Run("it serves repeated lookups from the cache until the TTL passes", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		registry := prometheus.NewRegistry()
+		c := metrics.NewMetricsCache(selfmetrics.New(registry, prometheus.DefBuckets), cache.NewExpiring(), 50*time.Millisecond)
+		counter, fetch := newFetcher(nil)
+
+		first, err := c.Fetch(context.Background(), "some-guid", fetch)
+		g.Expect(err).ToNot(HaveOccurred())
+		second, err := c.Fetch(context.Background(), "some-guid", fetch)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(second).To(BeIdenticalTo(first))
+		g.Expect(counter.calls).To(Equal(1))
+
+		_, err = c.Fetch(context.Background(), "other-guid", fetch)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(counter.calls).To(Equal(2))
+
+		time.Sleep(100 * time.Millisecond)
+		_, err = c.Fetch(context.Background(), "some-guid", fetch)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(counter.calls).To(Equal(3))
+
+		g.Expect(testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP metrics_cache_hits_total Pod metrics lookups served from the response cache or a coalesced in-flight request
+# TYPE metrics_cache_hits_total counter
+metrics_cache_hits_total 1
+# HELP metrics_cache_misses_total Pod metrics lookups sent to metrics-server
+# TYPE metrics_cache_misses_total counter
+metrics_cache_misses_total 3
+`), "metrics_cache_hits_total", "metrics_cache_misses_total")).To(Succeed())
+	})
+
+	t.Run("it coalesces concurrent lookups for the same source", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		c := metrics.NewMetricsCache(nil, cache.NewExpiring(), time.Minute)
+		release := make(chan struct{})
+		counter, fetch := newFetcher(release)
+
+		var wg sync.WaitGroup
+		results := make([]*v1beta1.PodMetricsList, 5)
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i], _ = c.Fetch(context.Background(), "some-guid", fetch)
+			}(i)
+		}
+
+		g.Eventually(callCount(counter)).Should(Equal(1))
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		g.Expect(counter.calls).To(Equal(1))
+		for _, r := range results {
+			g.Expect(r).To(BeIdenticalTo(results[0]))
+		}
+	})
+
+	t.Run("it does not cache errors", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		c := metrics.NewMetricsCache(nil, cache.NewExpiring(), time.Minute)
+		calls := 0
+		fetch := func(context.Context, string) (*v1beta1.PodMetricsList, error) {
+			calls++
+			return nil, errors.New("metrics-server unavailable")
+		}
+
+		_, err := c.Fetch(context.Background(), "some-guid", fetch)
+		g.Expect(err).To(MatchError("metrics-server unavailable"))
+		_, err = c.Fetch(context.Background(), "some-guid", fetch)
+		g.Expect(err).To(HaveOccurred())
+		g.Expect(calls).To(Equal(2))
+	})
+
+	t.Run("invalidating a source forces the next lookup to fetch", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		c := metrics.NewMetricsCache(nil, cache.NewExpiring(), time.Minute)
+		counter, fetch := newFetcher(nil)
+
+		_, _ = c.Fetch(context.Background(), "some-guid", fetch)
+		c.Invalidate("some-guid")
+		_, _ = c.Fetch(context.Background(), "some-guid", fetch)
+
+		g.Expect(counter.calls).To(Equal(2))
+	})
+
+	t.Run("a fetch in flight during invalidation is not cached", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		c := metrics.NewMetricsCache(nil, cache.NewExpiring(), time.Minute)
+		release := make(chan struct{})
+		counter, fetch := newFetcher(release)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, _ = c.Fetch(context.Background(), "some-guid", fetch)
+		}()
+		g.Eventually(callCount(counter)).Should(Equal(1))
+
+		c.Invalidate("some-guid")
+		close(release)
+		<-done
+
+		_, _ = c.Fetch(context.Background(), "some-guid", fetch)
+		g.Expect(counter.calls).To(Equal(2))
+	})
+
+	t.Run("a zero TTL disables caching until it is raised", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		c := metrics.NewMetricsCache(nil, cache.NewExpiring(), 0)
+		counter, fetch := newFetcher(nil)
+
+		_, _ = c.Fetch(context.Background(), "some-guid", fetch)
+		_, _ = c.Fetch(context.Background(), "some-guid", fetch)
+		g.Expect(counter.calls).To(Equal(2))
+
+		c.SetTTL(time.Minute)
+		_, _ = c.Fetch(context.Background(), "some-guid", fetch)
+		_, _ = c.Fetch(context.Background(), "some-guid", fetch)
+		g.Expect(counter.calls).To(Equal(3))
+	})
+
+	t.Run("a nil cache always fetches", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		var c *metrics.MetricsCache
+		counter, fetch := newFetcher(nil)
+
+		_, _ = c.Fetch(context.Background(), "some-guid", fetch)
+		_, _ = c.Fetch(context.Background(), "some-guid", fetch)
+		c.Invalidate("some-guid")
+
+		g.Expect(counter.calls).To(Equal(2))
+	})
+}
@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/cache"
+)
+
+// counterTTL is how long a counter is remembered after its instance was
+// last read. An instance read again after that starts a new counter.
+const counterTTL = 10 * time.Minute
+
+// counterTracker turns values that restart from zero with their container,
+// such as the CPU time the kubelet reports, into counters that only go up.
+type counterTracker struct {
+	mu       sync.Mutex
+	counters *cache.Expiring
+}
+
+type counterState struct {
+	// last is the latest value observed, and offset the total before the
+	// container last restarted.
+	last, offset uint64
+	// generation identifies the containers last was observed for.
+	generation string
+	// restartCounted is set when a value went down before generation
+	// changed, so the coming generation change doesn't count the restart
+	// again.
+	restartCounted bool
+}
+
+func newCounterTracker(counters *cache.Expiring) *counterTracker {
+	return &counterTracker{counters: counters}
+}
+
+// observe records the latest value of the counter named key, for the
+// containers identified by generation, and returns its total. When the
+// containers restarted, the total carries on from the previous total.
+// Increases are left to each consumer, which knows the total it saw last.
+//
+// A restart is recognized by a new generation, even when the new container
+// already reports more than the old one did. The value may come from a
+// cache that predates the restart, so a new generation with an unchanged
+// value is only accepted once the value changes. The value going down also
+// means a restart, for when the value is newer than the generation.
+func (t *counterTracker) observe(key, generation string, value uint64) uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state := counterState{last: value, generation: generation}
+	if cached, ok := t.counters.Get(key); ok {
+		previous := cached.(counterState)
+		state.offset = previous.offset
+		switch {
+		case generation != previous.generation && value == previous.last:
+			state = previous
+		case generation != previous.generation:
+			if !previous.restartCounted {
+				state.offset += previous.last
+			}
+		case value < previous.last:
+			state.offset += previous.last
+			state.restartCounted = true
+		default:
+			state.restartCounted = previous.restartCounted
+		}
+	}
+	t.counters.Set(key, state, counterTTL)
+
+	return state.offset + value
+}
+
+// containerGeneration identifies the lifetime of a pod's app containers. It
+// changes when the pod is replaced or one of its app containers restarts.
+func containerGeneration(pod *v1.Pod) string {
+	generation := string(pod.UID)
+	for _, status := range appContainerStatuses(pod) {
+		generation += fmt.Sprintf("/%s:%d", status.Name, status.RestartCount)
+	}
+	return generation
+}
@@ -0,0 +1,30 @@
+package diskusage_test
+
+import (
+	"fmt"
+	"testing"
+
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics/diskusage"
+)
+
+// BenchmarkCalculatePodDiskUsage looks up the last pod of a node running the
+// kubelet's default maximum of 110 pods.
+func BenchmarkCalculatePodDiskUsage(b *testing.B) {
+	summary := diskusage.NodeDiskUsage{}
+	for i := 0; i < 110; i++ {
+		summary.Pods = append(summary.Pods, diskusage.PodDiskUsage{
+			PodRef: diskusage.PodRef{Name: fmt.Sprintf("app-name-%d", i), Namespace: "cf-workloads"},
+			Containers: []diskusage.ContainerDiskUsage{
+				{Name: "opi", RootFS: diskusage.DiskUsage{UsedBytes: 135168}, Logs: diskusage.DiskUsage{UsedBytes: 24576}},
+				{Name: "istio-proxy", RootFS: diskusage.DiskUsage{UsedBytes: 4096000}},
+			},
+		})
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := diskusage.CalculatePodDiskUsage("app-name-109", summary); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -2,16 +2,33 @@
 package diskusagefakes
 
 import (
+	"context"
 	"sync"
 
 	"code.cloudfoundry.org/metric-proxy/pkg/metrics/diskusage"
 )
 
 type FakeNodeStatter struct {
-	SummaryStub        func(string) (diskusage.NodeDiskUsage, error)
+	IOStatsStub        func(context.Context, string, string) (diskusage.NodeIOStats, error)
+	iOStatsMutex       sync.RWMutex
+	iOStatsArgsForCall []struct {
+		arg1 context.Context
+		arg2 string
+		arg3 string
+	}
+	iOStatsReturns struct {
+		result1 diskusage.NodeIOStats
+		result2 error
+	}
+	iOStatsReturnsOnCall map[int]struct {
+		result1 diskusage.NodeIOStats
+		result2 error
+	}
+	SummaryStub        func(context.Context, string) (diskusage.NodeDiskUsage, error)
 	summaryMutex       sync.RWMutex
 	summaryArgsForCall []struct {
-		arg1 string
+		arg1 context.Context
+		arg2 string
 	}
 	summaryReturns struct {
 		result1 diskusage.NodeDiskUsage
@@ -25,18 +42,85 @@ type FakeNodeStatter struct {
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeNodeStatter) Summary(arg1 string) (diskusage.NodeDiskUsage, error) {
+func (fake *FakeNodeStatter) IOStats(arg1 context.Context, arg2 string, arg3 string) (diskusage.NodeIOStats, error) {
+	fake.iOStatsMutex.Lock()
+	ret, specificReturn := fake.iOStatsReturnsOnCall[len(fake.iOStatsArgsForCall)]
+	fake.iOStatsArgsForCall = append(fake.iOStatsArgsForCall, struct {
+		arg1 context.Context
+		arg2 string
+		arg3 string
+	}{arg1, arg2, arg3})
+	stub := fake.IOStatsStub
+	fakeReturns := fake.iOStatsReturns
+	fake.recordInvocation("IOStats", []interface{}{arg1, arg2, arg3})
+	fake.iOStatsMutex.Unlock()
+	if stub != nil {
+		return stub(arg1, arg2, arg3)
+	}
+	if specificReturn {
+		return ret.result1, ret.result2
+	}
+	return fakeReturns.result1, fakeReturns.result2
+}
+
+func (fake *FakeNodeStatter) IOStatsCallCount() int {
+	fake.iOStatsMutex.RLock()
+	defer fake.iOStatsMutex.RUnlock()
+	return len(fake.iOStatsArgsForCall)
+}
+
+func (fake *FakeNodeStatter) IOStatsCalls(stub func(context.Context, string, string) (diskusage.NodeIOStats, error)) {
+	fake.iOStatsMutex.Lock()
+	defer fake.iOStatsMutex.Unlock()
+	fake.IOStatsStub = stub
+}
+
+func (fake *FakeNodeStatter) IOStatsArgsForCall(i int) (context.Context, string, string) {
+	fake.iOStatsMutex.RLock()
+	defer fake.iOStatsMutex.RUnlock()
+	argsForCall := fake.iOStatsArgsForCall[i]
+	return argsForCall.arg1, argsForCall.arg2, argsForCall.arg3
+}
+
+func (fake *FakeNodeStatter) IOStatsReturns(result1 diskusage.NodeIOStats, result2 error) {
+	fake.iOStatsMutex.Lock()
+	defer fake.iOStatsMutex.Unlock()
+	fake.IOStatsStub = nil
+	fake.iOStatsReturns = struct {
+		result1 diskusage.NodeIOStats
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeNodeStatter) IOStatsReturnsOnCall(i int, result1 diskusage.NodeIOStats, result2 error) {
+	fake.iOStatsMutex.Lock()
+	defer fake.iOStatsMutex.Unlock()
+	fake.IOStatsStub = nil
+	if fake.iOStatsReturnsOnCall == nil {
+		fake.iOStatsReturnsOnCall = make(map[int]struct {
+			result1 diskusage.NodeIOStats
+			result2 error
+		})
+	}
+	fake.iOStatsReturnsOnCall[i] = struct {
+		result1 diskusage.NodeIOStats
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *FakeNodeStatter) Summary(arg1 context.Context, arg2 string) (diskusage.NodeDiskUsage, error) {
 	fake.summaryMutex.Lock()
 	ret, specificReturn := fake.summaryReturnsOnCall[len(fake.summaryArgsForCall)]
 	fake.summaryArgsForCall = append(fake.summaryArgsForCall, struct {
-		arg1 string
-	}{arg1})
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
 	stub := fake.SummaryStub
 	fakeReturns := fake.summaryReturns
-	fake.recordInvocation("Summary", []interface{}{arg1})
+	fake.recordInvocation("Summary", []interface{}{arg1, arg2})
 	fake.summaryMutex.Unlock()
 	if stub != nil {
-		return stub(arg1)
+		return stub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
@@ -50,17 +134,17 @@ func (fake *FakeNodeStatter) SummaryCallCount() int {
 	return len(fake.summaryArgsForCall)
 }
 
-func (fake *FakeNodeStatter) SummaryCalls(stub func(string) (diskusage.NodeDiskUsage, error)) {
+func (fake *FakeNodeStatter) SummaryCalls(stub func(context.Context, string) (diskusage.NodeDiskUsage, error)) {
 	fake.summaryMutex.Lock()
 	defer fake.summaryMutex.Unlock()
 	fake.SummaryStub = stub
 }
 
-func (fake *FakeNodeStatter) SummaryArgsForCall(i int) string {
+func (fake *FakeNodeStatter) SummaryArgsForCall(i int) (context.Context, string) {
 	fake.summaryMutex.RLock()
 	defer fake.summaryMutex.RUnlock()
 	argsForCall := fake.summaryArgsForCall[i]
-	return argsForCall.arg1
+	return argsForCall.arg1, argsForCall.arg2
 }
 
 func (fake *FakeNodeStatter) SummaryReturns(result1 diskusage.NodeDiskUsage, result2 error) {
@@ -92,6 +176,8 @@ func (fake *FakeNodeStatter) SummaryReturnsOnCall(i int, result1 diskusage.NodeD
 func (fake *FakeNodeStatter) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
+	fake.iOStatsMutex.RLock()
+	defer fake.iOStatsMutex.RUnlock()
 	fake.summaryMutex.RLock()
 	defer fake.summaryMutex.RUnlock()
 	copiedInvocations := map[string][][]interface{}{}
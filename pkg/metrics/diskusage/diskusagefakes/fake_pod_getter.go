@@ -2,6 +2,7 @@
 package diskusagefakes
 
 import (
+	"context"
 	"sync"
 
 	"code.cloudfoundry.org/metric-proxy/pkg/metrics/diskusage"
@@ -9,10 +10,11 @@ import (
 )
 
 type FakePodGetter struct {
-	GetStub        func(string) (*v1.Pod, error)
+	GetStub        func(context.Context, string) (*v1.Pod, error)
 	getMutex       sync.RWMutex
 	getArgsForCall []struct {
-		arg1 string
+		arg1 context.Context
+		arg2 string
 	}
 	getReturns struct {
 		result1 *v1.Pod
@@ -26,18 +28,19 @@ type FakePodGetter struct {
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakePodGetter) Get(arg1 string) (*v1.Pod, error) {
+func (fake *FakePodGetter) Get(arg1 context.Context, arg2 string) (*v1.Pod, error) {
 	fake.getMutex.Lock()
 	ret, specificReturn := fake.getReturnsOnCall[len(fake.getArgsForCall)]
 	fake.getArgsForCall = append(fake.getArgsForCall, struct {
-		arg1 string
-	}{arg1})
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
 	stub := fake.GetStub
 	fakeReturns := fake.getReturns
-	fake.recordInvocation("Get", []interface{}{arg1})
+	fake.recordInvocation("Get", []interface{}{arg1, arg2})
 	fake.getMutex.Unlock()
 	if stub != nil {
-		return stub(arg1)
+		return stub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
@@ -51,17 +54,17 @@ func (fake *FakePodGetter) GetCallCount() int {
 	return len(fake.getArgsForCall)
 }
 
-func (fake *FakePodGetter) GetCalls(stub func(string) (*v1.Pod, error)) {
+func (fake *FakePodGetter) GetCalls(stub func(context.Context, string) (*v1.Pod, error)) {
 	fake.getMutex.Lock()
 	defer fake.getMutex.Unlock()
 	fake.GetStub = stub
 }
 
-func (fake *FakePodGetter) GetArgsForCall(i int) string {
+func (fake *FakePodGetter) GetArgsForCall(i int) (context.Context, string) {
 	fake.getMutex.RLock()
 	defer fake.getMutex.RUnlock()
 	argsForCall := fake.getArgsForCall[i]
-	return argsForCall.arg1
+	return argsForCall.arg1, argsForCall.arg2
 }
 
 func (fake *FakePodGetter) GetReturns(result1 *v1.Pod, result2 error) {
@@ -0,0 +1,4 @@
+package diskusage
+
+var CalculatePodDiskUsage = calculatePodDiskUsage
+var ParseCadvisor = parseCadvisor
@@ -2,10 +2,15 @@
 package diskusage
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"time"
 
+	"code.cloudfoundry.org/metric-proxy/pkg/logging"
+	"code.cloudfoundry.org/metric-proxy/pkg/selfmetrics"
+	"code.cloudfoundry.org/metric-proxy/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/cache"
 )
@@ -15,24 +20,32 @@ import (
 //counterfeiter:generate . PodGetter
 
 type PodGetter interface {
-	Get(podName string) (*v1.Pod, error)
+	Get(ctx context.Context, podName string) (*v1.Pod, error)
 }
 
 //counterfeiter:generate . NodeStatter
 
 type NodeStatter interface {
-	Summary(nodeName string) (NodeDiskUsage, error)
+	Summary(ctx context.Context, nodeName string) (NodeDiskUsage, error)
+	IOStats(ctx context.Context, nodeName, podName string) (NodeIOStats, error)
 }
 
+// ioStatsCachePrefix keys a pod's IO stats in the node cache.
+const ioStatsCachePrefix = "io-stats/"
+
 type Fetcher struct {
+	logger       *logging.Logger
+	selfMetrics  *selfmetrics.Metrics
 	nodeCache    *cache.Expiring
 	nodeCacheTTL time.Duration
 	podGetter    PodGetter
 	nodeStatter  NodeStatter
 }
 
-func NewFetcher(nodeCache *cache.Expiring, nodeCacheTTL time.Duration, podGetter PodGetter, nodeStatter NodeStatter) *Fetcher {
+func NewFetcher(logger *logging.Logger, selfMetrics *selfmetrics.Metrics, nodeCache *cache.Expiring, nodeCacheTTL time.Duration, podGetter PodGetter, nodeStatter NodeStatter) *Fetcher {
 	return &Fetcher{
+		logger:       logger,
+		selfMetrics:  selfMetrics,
 		nodeCache:    nodeCache,
 		nodeCacheTTL: nodeCacheTTL,
 		podGetter:    podGetter,
@@ -40,35 +53,113 @@ func NewFetcher(nodeCache *cache.Expiring, nodeCacheTTL time.Duration, podGetter
 	}
 }
 
-func (f *Fetcher) DiskUsage(podName string) (int64, error) {
-	pod, err := f.podGetter.Get(podName)
+func (f *Fetcher) DiskUsage(ctx context.Context, podName string) (int64, error) {
+	pod, err := f.getPod(ctx, podName)
 	if err != nil {
 		return 0, fmt.Errorf("failed to retrieve pod: %w", err)
 	}
 
-	if cached, ok := f.nodeCache.Get(pod.Spec.NodeName); ok {
-		diskUsage, err := calculatePodDiskUsage(podName, cached.(NodeDiskUsage))
-		if err != nil {
-			return f.calculateFreshUsage(pod.Spec.NodeName, podName)
+	summary, err := f.nodeSummary(ctx, pod.Spec.NodeName, podName)
+	if err != nil {
+		return 0, err
+	}
+	return calculatePodDiskUsage(podName, summary)
+}
+
+// CPUTime returns the CPU time, in nanoseconds, the pod's app containers
+// have used since they started. It shares DiskUsage's node summary cache and
+// takes the pod rather than its name, as callers already have it.
+func (f *Fetcher) CPUTime(ctx context.Context, pod *v1.Pod) (uint64, error) {
+	summary, err := f.nodeSummary(ctx, pod.Spec.NodeName, pod.Name)
+	if err != nil {
+		return 0, err
+	}
+	return calculatePodCPUTime(pod.Name, summary)
+}
+
+// DiskIO returns the bytes the pod's app containers have read from and
+// written to their filesystems since they started. It caches the pod's
+// stats for the node cache TTL.
+func (f *Fetcher) DiskIO(ctx context.Context, pod *v1.Pod) (readBytes, writeBytes uint64, err error) {
+	stats, err := f.nodeIOStats(ctx, pod.Spec.NodeName, pod.Name)
+	if err != nil {
+		return 0, 0, err
+	}
+	return calculatePodDiskIO(pod.Name, stats)
+}
+
+// nodeSummary returns the summary of the pod's node, refreshing a cached
+// summary the pod is missing from.
+func (f *Fetcher) nodeSummary(ctx context.Context, nodeName, podName string) (NodeDiskUsage, error) {
+	logger := logging.FromContext(ctx, f.logger).With("pod", podName, "node", nodeName)
+
+	if cached, ok := f.nodeCache.Get(nodeName); ok {
+		summary := cached.(NodeDiskUsage)
+		if _, found := findPod(podName, summary); found {
+			logger.Debug("using cached node summary")
+			f.selfMetrics.NodeCacheHit()
+			return summary, nil
 		}
 
-		return diskUsage, nil
+		logger.Debug("pod missing from cached node summary, refreshing")
+		f.selfMetrics.NodeCacheEviction()
+	} else {
+		logger.Debug("fetching node summary")
+		f.selfMetrics.NodeCacheMiss()
 	}
 
-	return f.calculateFreshUsage(pod.Spec.NodeName, podName)
+	summary, err := f.fetchAndCacheStats(ctx, nodeName)
+	if err != nil {
+		return NodeDiskUsage{}, fmt.Errorf("failed to retrieve node summary: %w", err)
+	}
+	return summary, nil
 }
 
-func (f *Fetcher) calculateFreshUsage(nodeName, podName string) (int64, error) {
-	summary, err := f.fetchAndCacheStats(nodeName)
+// nodeIOStats returns the IO stats of the pod from its node. Each pod's
+// stats are cached on their own, as only the pod's series are kept from the
+// node's metrics. They share the node cache with summaries, under keys no
+// node name can have.
+func (f *Fetcher) nodeIOStats(ctx context.Context, nodeName, podName string) (NodeIOStats, error) {
+	logger := logging.FromContext(ctx, f.logger).With("pod", podName, "node", nodeName)
+	key := ioStatsCachePrefix + nodeName + "/" + podName
+
+	if cached, ok := f.nodeCache.Get(key); ok {
+		logger.Debug("using cached pod io stats")
+		f.selfMetrics.NodeCacheHit()
+		return cached.(NodeIOStats), nil
+	}
+	logger.Debug("fetching pod io stats")
+	f.selfMetrics.NodeCacheMiss()
+
+	start := time.Now()
+	ctx, span := tracing.StartSpan(ctx, "nodes.metrics.cadvisor", attribute.String("node", nodeName))
+	stats, err := f.nodeStatter.IOStats(ctx, nodeName, podName)
+	tracing.End(span, err)
+	f.selfMetrics.ObserveUpstream(selfmetrics.DependencyNodeCadvisor, start, err)
 	if err != nil {
-		return 0, fmt.Errorf("failed to retrieve node summary: %w", err)
+		return NodeIOStats{}, fmt.Errorf("failed to retrieve node io stats: %w", err)
 	}
+	f.nodeCache.Set(key, stats, f.nodeCacheTTL)
 
-	return calculatePodDiskUsage(podName, summary)
+	return stats, nil
 }
 
-func (f *Fetcher) fetchAndCacheStats(nodeName string) (NodeDiskUsage, error) {
-	summary, err := f.nodeStatter.Summary(nodeName)
+func (f *Fetcher) getPod(ctx context.Context, podName string) (*v1.Pod, error) {
+	start := time.Now()
+	ctx, span := tracing.StartSpan(ctx, "pods.get", attribute.String("pod", podName))
+	pod, err := f.podGetter.Get(ctx, podName)
+	tracing.End(span, err)
+	f.selfMetrics.ObserveUpstream(selfmetrics.DependencyPods, start, err)
+
+	return pod, err
+}
+
+func (f *Fetcher) fetchAndCacheStats(ctx context.Context, nodeName string) (NodeDiskUsage, error) {
+	start := time.Now()
+	ctx, span := tracing.StartSpan(ctx, "nodes.stats.summary", attribute.String("node", nodeName))
+	summary, err := f.nodeStatter.Summary(ctx, nodeName)
+	tracing.End(span, err)
+	f.selfMetrics.ObserveUpstream(selfmetrics.DependencyNodeSummary, start, err)
 	if err != nil {
 		return NodeDiskUsage{}, err
 	}
@@ -78,19 +169,69 @@ func (f *Fetcher) fetchAndCacheStats(nodeName string) (NodeDiskUsage, error) {
 }
 
 func calculatePodDiskUsage(podName string, summary NodeDiskUsage) (int64, error) {
+	pod, found := findPod(podName, summary)
+	if !found {
+		return 0, fmt.Errorf("disk usage for pod %q not found", podName)
+	}
+
+	var sum int64 = 0
+	for _, container := range pod.Containers {
+		if isIstio(container.Name) {
+			continue
+		}
+		sum += container.RootFS.UsedBytes + container.Logs.UsedBytes
+	}
+	return sum, nil
+}
+
+func calculatePodCPUTime(podName string, summary NodeDiskUsage) (uint64, error) {
+	pod, found := findPod(podName, summary)
+	if !found {
+		return 0, fmt.Errorf("cpu time for pod %q not found", podName)
+	}
+
+	var sum uint64
+	for _, container := range pod.Containers {
+		if isIstio(container.Name) {
+			continue
+		}
+		sum += container.CPU.UsageCoreNanoSeconds
+	}
+	return sum, nil
+}
+
+func calculatePodDiskIO(podName string, stats NodeIOStats) (readBytes, writeBytes uint64, err error) {
+	pod, found := findPodIOStats(podName, stats)
+	if !found {
+		return 0, 0, fmt.Errorf("disk io for pod %q not found", podName)
+	}
+
+	for _, container := range pod.Containers {
+		if isIstio(container.Name) {
+			continue
+		}
+		readBytes += container.ReadBytes
+		writeBytes += container.WriteBytes
+	}
+	return readBytes, writeBytes, nil
+}
+
+func findPodIOStats(podName string, stats NodeIOStats) (PodIOStats, bool) {
+	for _, pod := range stats.Pods {
+		if pod.PodRef.Name == podName {
+			return pod, true
+		}
+	}
+	return PodIOStats{}, false
+}
+
+func findPod(podName string, summary NodeDiskUsage) (PodDiskUsage, bool) {
 	for _, pod := range summary.Pods {
 		if pod.PodRef.Name == podName {
-			var sum int64 = 0
-			for _, container := range pod.Containers {
-				if isIstio(container.Name) {
-					continue
-				}
-				sum += container.RootFS.UsedBytes + container.Logs.UsedBytes
-			}
-			return sum, nil
+			return pod, true
 		}
 	}
-	return 0, fmt.Errorf("disk usage for pod %q not found", podName)
+	return PodDiskUsage{}, false
 }
 
 func isIstio(containerName string) bool {
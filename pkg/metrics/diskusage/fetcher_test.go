@@ -1,13 +1,24 @@
 package diskusage_test
 
 import (
+	"context"
 	"errors"
+	"os"
+	"strings"
 	"testing"
 	"time"
 
+	"code.cloudfoundry.org/metric-proxy/pkg/logging"
 	"code.cloudfoundry.org/metric-proxy/pkg/metrics/diskusage"
 	"code.cloudfoundry.org/metric-proxy/pkg/metrics/diskusage/diskusagefakes"
+	"code.cloudfoundry.org/metric-proxy/pkg/selfmetrics"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/cache"
@@ -28,6 +39,7 @@ func TestDiskUsageFetcher(t *testing.T) {
 		returnedStatsErr error
 		fetcher          *diskusage.Fetcher
 		clock            *diskusagefakes.FakeClock
+		registry         *prometheus.Registry
 	)
 
 	podResult := &corev1.Pod{
@@ -54,6 +66,9 @@ func TestDiskUsageFetcher(t *testing.T) {
 						Logs: diskusage.DiskUsage{
 							UsedBytes: 9999,
 						},
+						CPU: diskusage.CPUUsage{
+							UsageCoreNanoSeconds: 9999,
+						},
 					},
 					{
 						Name: "opi",
@@ -63,6 +78,9 @@ func TestDiskUsageFetcher(t *testing.T) {
 						Logs: diskusage.DiskUsage{
 							UsedBytes: 200,
 						},
+						CPU: diskusage.CPUUsage{
+							UsageCoreNanoSeconds: 5000000,
+						},
 					},
 					{
 						Name: "opi-2",
@@ -72,6 +90,9 @@ func TestDiskUsageFetcher(t *testing.T) {
 						Logs: diskusage.DiskUsage{
 							UsedBytes: 4,
 						},
+						CPU: diskusage.CPUUsage{
+							UsageCoreNanoSeconds: 600,
+						},
 					},
 				},
 			},
@@ -96,7 +117,9 @@ func TestDiskUsageFetcher(t *testing.T) {
 
 		clock = new(diskusagefakes.FakeClock)
 		nodeCache := cache.NewExpiringWithClock(clock)
-		fetcher = diskusage.NewFetcher(nodeCache, time.Minute, podGetter, nodeStatter)
+		registry = prometheus.NewRegistry()
+		selfMetrics := selfmetrics.New(registry, prometheus.DefBuckets)
+		fetcher = diskusage.NewFetcher(logging.New(os.Stderr, logging.Debug), selfMetrics, nodeCache, time.Minute, podGetter, nodeStatter)
 	}
 
 	t.Run("it calculates pod disk usage", func(t *testing.T) {
@@ -107,19 +130,102 @@ func TestDiskUsageFetcher(t *testing.T) {
 
 		setUp(t)
 
-		usage, err := fetcher.DiskUsage("my-pod")
+		usage, err := fetcher.DiskUsage(context.Background(), "my-pod")
 
 		g.Expect(err).ToNot(HaveOccurred())
 
 		g.Expect(podGetter.GetCallCount()).To(Equal(1))
-		g.Expect(podGetter.GetArgsForCall(0)).To(Equal("my-pod"))
+		_, podName := podGetter.GetArgsForCall(0)
+		g.Expect(podName).To(Equal("my-pod"))
 
 		g.Expect(nodeStatter.SummaryCallCount()).To(Equal(1))
-		g.Expect(nodeStatter.SummaryArgsForCall(0)).To(Equal("my-node"))
+		_, nodeName := nodeStatter.SummaryArgsForCall(0)
+		g.Expect(nodeName).To(Equal("my-node"))
 
 		g.Expect(usage).To(BeNumerically("==", 1234))
 	})
 
+	t.Run("it calculates pod cpu time from the cached node summary", func(t *testing.T) {
+		init()
+
+		returnedPod = podResult
+		returnedStats = nodeResult
+
+		setUp(t)
+
+		_, err := fetcher.DiskUsage(context.Background(), "my-pod")
+		g.Expect(err).ToNot(HaveOccurred())
+
+		cpuTime, err := fetcher.CPUTime(context.Background(), podResult)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(cpuTime).To(BeNumerically("==", 5000600))
+		g.Expect(podGetter.GetCallCount()).To(Equal(1))
+		g.Expect(nodeStatter.SummaryCallCount()).To(Equal(1))
+	})
+
+	t.Run("it calculates pod disk io from the node io stats", func(t *testing.T) {
+		init()
+
+		setUp(t)
+		nodeStatter.IOStatsReturns(diskusage.NodeIOStats{
+			Pods: []diskusage.PodIOStats{{
+				PodRef: diskusage.PodRef{Name: "my-pod"},
+				Containers: []diskusage.ContainerIOStats{
+					{Name: "istio-proxy", ReadBytes: 9999, WriteBytes: 9999},
+					{Name: "opi", ReadBytes: 4096, WriteBytes: 1024},
+					{Name: "opi-2", ReadBytes: 4, WriteBytes: 2},
+				},
+			}},
+		}, nil)
+
+		readBytes, writeBytes, err := fetcher.DiskIO(context.Background(), podResult)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(readBytes).To(BeNumerically("==", 4100))
+		g.Expect(writeBytes).To(BeNumerically("==", 1026))
+
+		_, _, err = fetcher.DiskIO(context.Background(), podResult)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(nodeStatter.IOStatsCallCount()).To(Equal(1))
+		_, nodeName, podName := nodeStatter.IOStatsArgsForCall(0)
+		g.Expect(nodeName).To(Equal("my-node"))
+		g.Expect(podName).To(Equal("my-pod"))
+		g.Expect(nodeStatter.SummaryCallCount()).To(Equal(0))
+	})
+
+	t.Run("returning an error when the node io stats can't be fetched", func(t *testing.T) {
+		init()
+
+		setUp(t)
+		nodeStatter.IOStatsReturns(diskusage.NodeIOStats{}, errors.New("k8s problem"))
+
+		_, _, err := fetcher.DiskIO(context.Background(), podResult)
+		g.Expect(err).To(MatchError("failed to retrieve node io stats: k8s problem"))
+	})
+
+	t.Run("it traces the pod and node summary requests", func(t *testing.T) {
+		init()
+
+		returnedPod = podResult
+		returnedStats = nodeResult
+
+		setUp(t)
+
+		recorder := tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+		defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+		_, err := fetcher.DiskUsage(context.Background(), "my-pod")
+		g.Expect(err).ToNot(HaveOccurred())
+
+		spans := recorder.Ended()
+		g.Expect(spans).To(HaveLen(2))
+		g.Expect(spans[0].Name()).To(Equal("pods.get"))
+		g.Expect(spans[1].Name()).To(Equal("nodes.stats.summary"))
+
+		ctx, _ := nodeStatter.SummaryArgsForCall(0)
+		g.Expect(trace.SpanContextFromContext(ctx)).To(Equal(spans[1].SpanContext()))
+	})
+
 	t.Run("cache is used when recent node summary is available", func(t *testing.T) {
 		now := time.Now()
 		init()
@@ -131,7 +237,7 @@ func TestDiskUsageFetcher(t *testing.T) {
 
 		clock.NowReturns(now)
 
-		usage, err := fetcher.DiskUsage("my-pod")
+		usage, err := fetcher.DiskUsage(context.Background(), "my-pod")
 		g.Expect(err).NotTo(HaveOccurred())
 		g.Expect(podGetter.GetCallCount()).To(Equal(1))
 		g.Expect(nodeStatter.SummaryCallCount()).To(Equal(1))
@@ -139,7 +245,7 @@ func TestDiskUsageFetcher(t *testing.T) {
 
 		clock.NowReturns(now.Add(30 * time.Second))
 
-		usage, err = fetcher.DiskUsage("my-pod")
+		usage, err = fetcher.DiskUsage(context.Background(), "my-pod")
 		g.Expect(err).NotTo(HaveOccurred())
 		g.Expect(podGetter.GetCallCount()).To(Equal(2))
 		g.Expect(nodeStatter.SummaryCallCount()).To(Equal(1))
@@ -147,11 +253,20 @@ func TestDiskUsageFetcher(t *testing.T) {
 
 		clock.NowReturns(time.Now().Add(2 * time.Minute))
 
-		usage, err = fetcher.DiskUsage("my-pod")
+		usage, err = fetcher.DiskUsage(context.Background(), "my-pod")
 		g.Expect(err).NotTo(HaveOccurred())
 		g.Expect(podGetter.GetCallCount()).To(Equal(3))
 		g.Expect(nodeStatter.SummaryCallCount()).To(Equal(2))
 		g.Expect(usage).To(BeNumerically("==", 1234))
+
+		g.Expect(testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP node_cache_hits_total Disk usage lookups served from a cached node summary
+# TYPE node_cache_hits_total counter
+node_cache_hits_total 1
+# HELP node_cache_misses_total Disk usage lookups with no cached node summary
+# TYPE node_cache_misses_total counter
+node_cache_misses_total 2
+`), "node_cache_hits_total", "node_cache_misses_total")).To(Succeed())
 	})
 
 	t.Run("returning error when getting pod fails", func(t *testing.T) {
@@ -160,7 +275,7 @@ func TestDiskUsageFetcher(t *testing.T) {
 
 		setUp(t)
 
-		_, err := fetcher.DiskUsage("my-pod")
+		_, err := fetcher.DiskUsage(context.Background(), "my-pod")
 		g.Expect(err).To(MatchError(SatisfyAll(
 			ContainSubstring("failed to retrieve pod"),
 			ContainSubstring("k8s problem"),
@@ -178,7 +293,7 @@ func TestDiskUsageFetcher(t *testing.T) {
 
 		setUp(t)
 
-		_, err := fetcher.DiskUsage("my-pod")
+		_, err := fetcher.DiskUsage(context.Background(), "my-pod")
 		g.Expect(err).To(MatchError(SatisfyAll(
 			ContainSubstring("failed to retrieve node summary"),
 			ContainSubstring("k8s problem"),
@@ -193,12 +308,18 @@ func TestDiskUsageFetcher(t *testing.T) {
 		nodeStatter.SummaryReturnsOnCall(1, nodeResult, nil)
 
 		// populate the node cache with empty results
-		_, err := fetcher.DiskUsage("my-pod")
+		_, err := fetcher.DiskUsage(context.Background(), "my-pod")
 		g.Expect(err).To(HaveOccurred())
 
-		usage, err := fetcher.DiskUsage("my-pod")
+		usage, err := fetcher.DiskUsage(context.Background(), "my-pod")
 		g.Expect(err).NotTo(HaveOccurred())
 		g.Expect(usage).To(BeNumerically("==", 1234))
+
+		g.Expect(testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP node_cache_evictions_total Cached node summaries discarded because they were missing a pod
+# TYPE node_cache_evictions_total counter
+node_cache_evictions_total 1
+`), "node_cache_evictions_total")).To(Succeed())
 	})
 
 	t.Run("when cache is refreshed for a missing pod, but pod still isn't found, it errors", func(t *testing.T) {
@@ -208,10 +329,10 @@ func TestDiskUsageFetcher(t *testing.T) {
 		setUp(t)
 
 		// populate the node cache with empty results
-		_, err := fetcher.DiskUsage("my-pod")
+		_, err := fetcher.DiskUsage(context.Background(), "my-pod")
 		g.Expect(err).To(HaveOccurred())
 
-		_, err = fetcher.DiskUsage("my-pod")
+		_, err = fetcher.DiskUsage(context.Background(), "my-pod")
 		g.Expect(err).To(MatchError(`disk usage for pod "my-pod" not found`))
 	})
 
@@ -221,7 +342,84 @@ func TestDiskUsageFetcher(t *testing.T) {
 
 		setUp(t)
 
-		_, err := fetcher.DiskUsage("my-pod")
+		_, err := fetcher.DiskUsage(context.Background(), "my-pod")
 		g.Expect(err).To(MatchError(`disk usage for pod "my-pod" not found`))
+
+		_, err = fetcher.CPUTime(context.Background(), podResult)
+		g.Expect(err).To(MatchError(`cpu time for pod "my-pod" not found`))
+	})
+}
+
+func TestParseCadvisor(t *testing.T) {
+	metrics := `# HELP container_cpu_usage_seconds_total Cumulative cpu time consumed in seconds.
+# TYPE container_cpu_usage_seconds_total counter
+container_cpu_usage_seconds_total{container="opi",cpu="total",namespace="cf-workloads",pod="app-name-0"} 12.5
+# TYPE container_fs_reads_bytes_total counter
+container_fs_reads_bytes_total{container="",device="/dev/sda",namespace="cf-workloads",pod="app-name-0"} 99999
+container_fs_reads_bytes_total{container="POD",device="/dev/sda",namespace="cf-workloads",pod="app-name-0"} 99999
+container_fs_reads_bytes_total{container="opi",device="/dev/sda",namespace="cf-workloads",pod="app-name-0"} 4096 1600000000000
+container_fs_reads_bytes_total{container="opi",device="/dev/sdb",namespace="cf-workloads",pod="app-name-0"} 1024
+container_fs_reads_bytes_total{container="opi",device="/dev/sda",namespace="cf-workloads",pod="app-name-00"} 99999
+container_fs_reads_bytes_total{container="kubelet",device="/dev/sda",namespace="",pod=""} 99999
+# TYPE container_fs_writes_bytes_total counter
+container_fs_writes_bytes_total{container="opi",device="/dev/sda",id="/kubepods/\"quoted\"}",namespace="cf-workloads",pod="app-name-0"} 512
+container_fs_writes_bytes_total{container="opi",device="/dev/sda",namespace="cf-workloads",pod="app-name-1"} 256
+`
+
+	t.Run("it sums the pod's containers' filesystem io across devices", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		stats, err := diskusage.ParseCadvisor(strings.NewReader(metrics), "app-name-0")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(stats).To(Equal(diskusage.NodeIOStats{
+			Pods: []diskusage.PodIOStats{{
+				PodRef:     diskusage.PodRef{Name: "app-name-0", Namespace: "cf-workloads"},
+				Containers: []diskusage.ContainerIOStats{{Name: "opi", ReadBytes: 5120, WriteBytes: 512}},
+			}},
+		}))
+	})
+
+	t.Run("it has no stats for pods without series", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		stats, err := diskusage.ParseCadvisor(strings.NewReader(metrics), "app-name-2")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(stats.Pods).To(BeEmpty())
+	})
+
+	t.Run("it rejects malformed samples of the pod", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		_, err := diskusage.ParseCadvisor(strings.NewReader(`container_fs_reads_bytes_total{container="opi",pod="app-name-0" 1`), "app-name-0")
+		g.Expect(err).To(HaveOccurred())
+
+		_, err = diskusage.ParseCadvisor(strings.NewReader(`container_fs_reads_bytes_total{container="opi",pod="app-name-0"} many`), "app-name-0")
+		g.Expect(err).To(HaveOccurred())
 	})
+
+	t.Run("it gives up on metrics larger than the cap", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		line := `container_memory_rss{container="opi",namespace="cf-workloads",pod="app-name-1"} 1` + "\n"
+		endless := &repeatReader{line: line}
+
+		_, err := diskusage.ParseCadvisor(endless, "app-name-0")
+		g.Expect(err).To(MatchError(ContainSubstring("cadvisor metrics exceed")))
+	})
+}
+
+// repeatReader reads line over and over.
+type repeatReader struct {
+	line string
+	off  int
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		copied := copy(p[n:], r.line[r.off:])
+		n += copied
+		r.off = (r.off + copied) % len(r.line)
+	}
+	return n, nil
 }
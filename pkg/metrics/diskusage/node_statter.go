@@ -1,7 +1,13 @@
 package diskusage
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
 
 	"k8s.io/client-go/rest"
 )
@@ -24,12 +30,49 @@ type ContainerDiskUsage struct {
 	Name   string    `json:"name"`
 	RootFS DiskUsage `json:"rootfs"`
 	Logs   DiskUsage `json:"logs"`
+	CPU    CPUUsage  `json:"cpu"`
 }
 
 type DiskUsage struct {
 	UsedBytes int64 `json:"usedBytes"`
 }
 
+// CPUUsage holds the CPU time a container has used since it started, in
+// nanoseconds.
+type CPUUsage struct {
+	UsageCoreNanoSeconds uint64 `json:"usageCoreNanoSeconds"`
+}
+
+// NodeIOStats holds the filesystem IO of a node's containers. The stats
+// summary has none, so it comes from the kubelet's cAdvisor metrics.
+type NodeIOStats struct {
+	Pods []PodIOStats `json:"pods"`
+}
+
+type PodIOStats struct {
+	PodRef     PodRef             `json:"podRef"`
+	Containers []ContainerIOStats `json:"containers"`
+}
+
+// ContainerIOStats holds the bytes a container has read from and written to
+// its filesystems since it started.
+type ContainerIOStats struct {
+	Name       string `json:"name"`
+	ReadBytes  uint64 `json:"readBytes"`
+	WriteBytes uint64 `json:"writeBytes"`
+}
+
+// The cAdvisor counters IOStats reads, labeled with the pod, namespace and
+// container they belong to.
+const (
+	fsReadsMetric  = "container_fs_reads_bytes_total"
+	fsWritesMetric = "container_fs_writes_bytes_total"
+)
+
+// maxCadvisorBytes caps how much of a node's cAdvisor metrics IOStats reads.
+// They cover every container on the node, so they grow with it.
+const maxCadvisorBytes = 64 << 20
+
 type nodeStatter struct {
 	k8sRestClient rest.Interface
 }
@@ -40,7 +83,7 @@ func NewNodeStatter(k8sRestClient rest.Interface) NodeStatter {
 	}
 }
 
-func (s *nodeStatter) Summary(nodeName string) (NodeDiskUsage, error) {
+func (s *nodeStatter) Summary(_ context.Context, nodeName string) (NodeDiskUsage, error) {
 	result := s.k8sRestClient.
 		Get().
 		Resource("nodes").
@@ -60,3 +103,138 @@ func (s *nodeStatter) Summary(nodeName string) (NodeDiskUsage, error) {
 	}
 	return nodeDiskUsage, nil
 }
+
+// IOStats returns the filesystem IO of podName's containers. It streams the
+// node's cAdvisor metrics rather than loading them, as they cover every
+// container on the node.
+func (s *nodeStatter) IOStats(_ context.Context, nodeName, podName string) (NodeIOStats, error) {
+	body, err := s.k8sRestClient.
+		Get().
+		Resource("nodes").
+		Name(nodeName).
+		SubResource("proxy", "metrics", "cadvisor").
+		Stream()
+	if err != nil {
+		return NodeIOStats{}, err
+	}
+	defer body.Close()
+
+	return parseCadvisor(body, podName)
+}
+
+// parseCadvisor reads cAdvisor metrics line by line, keeping only podName's
+// filesystem IO counters and summing each container's across devices.
+// Series without a container, such as the pod's own cgroup and its pause
+// container, are skipped.
+func parseCadvisor(r io.Reader, podName string) (NodeIOStats, error) {
+	limited := &io.LimitedReader{R: r, N: maxCadvisorBytes}
+	scanner := bufio.NewScanner(limited)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+
+	podLabel := `pod="` + podName + `"`
+	pod := PodIOStats{}
+	containers := map[string]*ContainerIOStats{}
+	var order []string
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		var accumulate func(*ContainerIOStats, uint64)
+		switch {
+		case strings.HasPrefix(line, fsReadsMetric+"{"):
+			accumulate = func(c *ContainerIOStats, v uint64) { c.ReadBytes += v }
+		case strings.HasPrefix(line, fsWritesMetric+"{"):
+			accumulate = func(c *ContainerIOStats, v uint64) { c.WriteBytes += v }
+		default:
+			continue
+		}
+		if !strings.Contains(line, podLabel) {
+			continue
+		}
+
+		labels, value, err := parseSample(line[strings.IndexByte(line, '{')+1:])
+		if err != nil {
+			return NodeIOStats{}, fmt.Errorf("invalid cadvisor sample %q: %w", line, err)
+		}
+		if labels["pod"] != podName || labels["container"] == "" || labels["container"] == "POD" {
+			continue
+		}
+
+		pod.PodRef = PodRef{Name: podName, Namespace: labels["namespace"]}
+		container, ok := containers[labels["container"]]
+		if !ok {
+			container = &ContainerIOStats{Name: labels["container"]}
+			containers[container.Name] = container
+			order = append(order, container.Name)
+		}
+		accumulate(container, uint64(value))
+	}
+	if err := scanner.Err(); err != nil {
+		return NodeIOStats{}, err
+	}
+	if limited.N <= 0 {
+		return NodeIOStats{}, fmt.Errorf("cadvisor metrics exceed %d bytes", maxCadvisorBytes)
+	}
+
+	if len(order) == 0 {
+		return NodeIOStats{}, nil
+	}
+	for _, name := range order {
+		pod.Containers = append(pod.Containers, *containers[name])
+	}
+	return NodeIOStats{Pods: []PodIOStats{pod}}, nil
+}
+
+// parseSample parses the labels and value of a text format sample, starting
+// after the opening brace of its labels.
+func parseSample(s string) (map[string]string, float64, error) {
+	labels := map[string]string{}
+	for {
+		s = strings.TrimLeft(s, " ,")
+		if strings.HasPrefix(s, "}") {
+			s = s[1:]
+			break
+		}
+
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 || len(s) < eq+2 || s[eq+1] != '"' {
+			return nil, 0, fmt.Errorf("malformed labels")
+		}
+		name := strings.TrimSpace(s[:eq])
+		s = s[eq+2:]
+
+		var value strings.Builder
+		closed := false
+		for i := 0; i < len(s); i++ {
+			switch c := s[i]; {
+			case c == '\\' && i+1 < len(s):
+				i++
+				if s[i] == 'n' {
+					value.WriteByte('\n')
+				} else {
+					value.WriteByte(s[i])
+				}
+			case c == '"':
+				s, closed = s[i+1:], true
+			default:
+				value.WriteByte(c)
+			}
+			if closed {
+				break
+			}
+		}
+		if !closed {
+			return nil, 0, fmt.Errorf("unterminated label value")
+		}
+		labels[name] = value.String()
+	}
+
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, 0, fmt.Errorf("missing value")
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return nil, 0, err
+	}
+	return labels, value, nil
+}
@@ -1,19 +1,33 @@
 package diskusage
 
 import (
+	"context"
+
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	typesv1 "k8s.io/client-go/kubernetes/typed/core/v1"
 )
 
+// NamespaceFn returns the namespace a pod lives in.
+type NamespaceFn func(podName string) (string, error)
+
 type podGetter struct {
-	podsClient typesv1.PodInterface
+	podsClient  typesv1.PodsGetter
+	namespaceFn NamespaceFn
 }
 
-func NewPodGetter(podsClient typesv1.PodInterface) PodGetter {
-	return &podGetter{podsClient: podsClient}
+func NewPodGetter(podsClient typesv1.PodsGetter, namespaceFn NamespaceFn) PodGetter {
+	return &podGetter{
+		podsClient:  podsClient,
+		namespaceFn: namespaceFn,
+	}
 }
 
-func (p *podGetter) Get(podName string) (*corev1.Pod, error) {
-	return p.podsClient.Get(podName, metav1.GetOptions{})
+func (p *podGetter) Get(_ context.Context, podName string) (*corev1.Pod, error) {
+	namespace, err := p.namespaceFn(podName)
+	if err != nil {
+		return nil, err
+	}
+
+	return p.podsClient.Pods(namespace).Get(podName, metav1.GetOptions{})
 }
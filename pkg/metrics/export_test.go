@@ -0,0 +1,3 @@
+package metrics
+
+var AggregateContainerMetrics = aggregateContainerMetrics
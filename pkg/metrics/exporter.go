@@ -0,0 +1,279 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/metric-proxy/pkg/logging"
+	"code.cloudfoundry.org/metric-proxy/pkg/rpc/metricproxy_v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	v1 "k8s.io/api/core/v1"
+)
+
+// ExporterLabels are the pod labels holding an app's space and org guids and
+// the pod annotations holding the app, space and org names.
+type ExporterLabels struct {
+	Space string
+	Org   string
+
+	AppName   string
+	SpaceName string
+	OrgName   string
+}
+
+// exportedGauge is how an envelope gauge is exposed to Prometheus.
+type exportedGauge struct {
+	name  string
+	help  string
+	scale float64
+}
+
+// exportedGauges maps the gauges Proxy creates to Prometheus metrics.
+// Gauges not listed aren't exported.
+var exportedGauges = map[string]exportedGauge{
+	"cpu":           {"cf_app_cpu_percent", "CPU usage of the instance as a percentage of one core.", 1},
+	"memory":        {"cf_app_memory_bytes", "Memory usage of the instance.", 1},
+	"disk":          {"cf_app_disk_bytes", "Disk usage of the instance.", 1},
+	"memory_quota":  {"cf_app_memory_quota_bytes", "Memory limit of the instance.", 1},
+	"disk_quota":    {"cf_app_disk_quota_bytes", "Disk limit of the instance.", 1},
+	"state":         {"cf_app_instance_state", "State of the instance: 0 unknown, 1 starting, 2 running, 3 crashed.", 1},
+	"restart_count": {"cf_app_instance_restarts", "Number of times the instance's containers restarted.", 1},
+	"container_age": {"cf_app_instance_age_seconds", "Time since the instance's containers started.", 1e-9},
+}
+
+var exportedLabelNames = []string{"app_guid", "app_name", "space_guid", "space_name", "org_guid", "org_name", "instance_id"}
+
+// Exporter renders every app instance's gauges in the Prometheus exposition
+// format. It reads apps through BatchProxy, so the values match what Read
+// returns. A collection is reused by scrapes within cacheTTL of it, and
+// concurrent scrapes share one collection. Each collection is bounded by
+// timeout, or by the scrape timeout Prometheus sends if that is shorter.
+type Exporter struct {
+	logger         *logging.Logger
+	batchProxy     *BatchProxy
+	sourceListerFn SourceListerFn
+	podListerFn    PodListerFn
+	labels         ExporterLabels
+	descs          map[string]*prometheus.Desc
+	timeout        time.Duration
+	cacheTTL       time.Duration
+
+	mu       sync.Mutex
+	last     *exporterCollection
+	inFlight *exporterCollection
+}
+
+// exporterCollection is the metrics of every app, collected once.
+type exporterCollection struct {
+	done        chan struct{}
+	metrics     []prometheus.Metric
+	err         error
+	collectedAt time.Time
+}
+
+func NewExporter(
+	logger *logging.Logger,
+	batchProxy *BatchProxy,
+	sourceListerFn SourceListerFn,
+	podListerFn PodListerFn,
+	labels ExporterLabels,
+	timeout time.Duration,
+	cacheTTL time.Duration,
+) *Exporter {
+	descs := make(map[string]*prometheus.Desc, len(exportedGauges))
+	for gauge, exported := range exportedGauges {
+		descs[gauge] = prometheus.NewDesc(exported.name, exported.help, exportedLabelNames, nil)
+	}
+
+	return &Exporter{
+		logger:         logger,
+		batchProxy:     batchProxy,
+		sourceListerFn: sourceListerFn,
+		podListerFn:    podListerFn,
+		labels:         labels,
+		descs:          descs,
+		timeout:        timeout,
+		cacheTTL:       cacheTTL,
+	}
+}
+
+// Handler serves the metrics at /app-metrics.
+func (e *Exporter) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/app-metrics", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), e.scrapeTimeout(r))
+		defer cancel()
+
+		registry := prometheus.NewRegistry()
+		registry.MustRegister(&exporterCollector{exporter: e, ctx: ctx})
+
+		promhttp.HandlerFor(registry, promhttp.HandlerOpts{
+			ErrorLog:          promhttpLogger{logging.FromContext(r.Context(), e.logger)},
+			ErrorHandling:     promhttp.HTTPErrorOnError,
+			EnableOpenMetrics: true,
+		}).ServeHTTP(w, r)
+	})
+
+	return mux
+}
+
+// scrapeTimeout is the exporter's timeout, or the scrape timeout Prometheus
+// sends in a header if that is shorter.
+func (e *Exporter) scrapeTimeout(r *http.Request) time.Duration {
+	timeout := e.timeout
+	if seconds, err := strconv.ParseFloat(r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"), 64); err == nil && seconds > 0 {
+		if scrapeTimeout := time.Duration(seconds * float64(time.Second)); scrapeTimeout < timeout {
+			timeout = scrapeTimeout
+		}
+	}
+	return timeout
+}
+
+// exporterCollector collects the metrics of one scrape.
+type exporterCollector struct {
+	exporter *Exporter
+	ctx      context.Context
+}
+
+func (c *exporterCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range c.exporter.descs {
+		ch <- desc
+	}
+}
+
+func (c *exporterCollector) Collect(ch chan<- prometheus.Metric) {
+	metrics, err := c.exporter.cachedCollect(c.ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.exporter.descs["state"], err)
+		return
+	}
+	for _, m := range metrics {
+		ch <- m
+	}
+}
+
+// cachedCollect reuses and coalesces collections the way MetricsCache.Fetch
+// does metrics-server responses, for the one collection of every app.
+func (e *Exporter) cachedCollect(ctx context.Context) ([]prometheus.Metric, error) {
+	e.mu.Lock()
+	if e.last != nil && time.Since(e.last.collectedAt) < e.cacheTTL {
+		last := e.last
+		e.mu.Unlock()
+		return last.metrics, nil
+	}
+	if call := e.inFlight; call != nil {
+		e.mu.Unlock()
+		<-call.done
+		return call.metrics, call.err
+	}
+
+	call := &exporterCollection{done: make(chan struct{})}
+	e.inFlight = call
+	e.mu.Unlock()
+
+	call.metrics, call.err = e.collect(ctx)
+	call.collectedAt = time.Now()
+
+	e.mu.Lock()
+	e.inFlight = nil
+	if call.err == nil {
+		e.last = call
+	}
+	e.mu.Unlock()
+	close(call.done)
+
+	return call.metrics, call.err
+}
+
+func (e *Exporter) collect(ctx context.Context) ([]prometheus.Metric, error) {
+	logger := logging.FromContext(ctx, e.logger)
+
+	sourceIDs, err := e.sourceListerFn(map[string]string{})
+	if err != nil {
+		logger.Error("failed to list sources", "error", err)
+		return nil, err
+	}
+
+	var metrics []prometheus.Metric
+
+	err = ForEachBatch(sourceIDs, func(sourceIDs []string) error {
+		batch, err := e.batchProxy.BatchRead(ctx, &metricproxy_v1.BatchReadRequest{
+			SourceIds: sourceIDs,
+		})
+		if err != nil {
+			return err
+		}
+
+		for sourceID, envelopes := range batch.GetEnvelopes() {
+			appLabels, err := e.appLabels(sourceID)
+			if err != nil {
+				logger.Error("failed to list pods", "source_id", sourceID, "error", err)
+				return err
+			}
+			metrics = e.appendMetrics(metrics, appLabels, envelopes.GetBatch())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Debug("app metrics collected", "sources", len(sourceIDs))
+	return metrics, nil
+}
+
+func (e *Exporter) appendMetrics(metrics []prometheus.Metric, appLabels []string, envelopes []*loggregator_v2.Envelope) []prometheus.Metric {
+	for _, envelope := range envelopes {
+		labelValues := append(appLabels[:len(appLabels):len(appLabels)], envelope.GetInstanceId())
+		for name, gauge := range envelope.GetGauge().GetMetrics() {
+			exported, ok := exportedGauges[name]
+			if !ok {
+				continue
+			}
+			metrics = append(metrics, prometheus.MustNewConstMetric(
+				e.descs[name],
+				prometheus.GaugeValue,
+				gauge.GetValue()*exported.scale,
+				labelValues...,
+			))
+		}
+	}
+	return metrics
+}
+
+// appLabels returns the values of every label in exportedLabelNames but
+// instance_id, taken from one of the app's pods.
+func (e *Exporter) appLabels(guid string) ([]string, error) {
+	pods, err := e.podListerFn(guid)
+	if err != nil {
+		return nil, err
+	}
+
+	pod := &v1.Pod{}
+	if len(pods) > 0 {
+		pod = pods[0]
+	}
+	return []string{
+		guid,
+		pod.Annotations[e.labels.AppName],
+		pod.Labels[e.labels.Space],
+		pod.Annotations[e.labels.SpaceName],
+		pod.Labels[e.labels.Org],
+		pod.Annotations[e.labels.OrgName],
+	}, nil
+}
+
+// promhttpLogger logs promhttp's errors, such as failing to read an app.
+type promhttpLogger struct {
+	logger *logging.Logger
+}
+
+func (l promhttpLogger) Println(v ...interface{}) {
+	l.logger.Error("failed to serve app metrics", "error", fmt.Sprint(v...))
+}
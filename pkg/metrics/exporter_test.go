@@ -0,0 +1,148 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/metric-proxy/pkg/logging"
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+func TestExporter(t *testing.T) {
+	labels := metrics.ExporterLabels{
+		Space:     "space-label",
+		Org:       "org-label",
+		AppName:   "app-name-annotation",
+		SpaceName: "space-name-annotation",
+		OrgName:   "org-name-annotation",
+	}
+	appPod := func(name string) *corev1.Pod {
+		pod := newRunningPodNamed(name)
+		pod.Labels = map[string]string{"space-label": "space-guid", "org-label": "org-guid"}
+		pod.Annotations = map[string]string{
+			"app-name-annotation":   "my-app",
+			"space-name-annotation": "my-space",
+			"org-name-annotation":   "my-org",
+		}
+		return pod
+	}
+	podLister := func(guid string) ([]*corev1.Pod, error) {
+		if guid != "app-a" {
+			return nil, nil
+		}
+		return []*corev1.Pod{appPod("app-a-0"), appPod("app-a-1")}, nil
+	}
+	scrape := func(g *GomegaWithT, e *metrics.Exporter, accept string) (*http.Response, string) {
+		server := httptest.NewServer(e.Handler())
+		defer server.Close()
+
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/app-metrics", nil)
+		g.Expect(err).ToNot(HaveOccurred())
+		req.Header.Set("Accept", accept)
+		resp, err := http.DefaultClient.Do(req)
+		g.Expect(err).ToNot(HaveOccurred())
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		g.Expect(err).ToNot(HaveOccurred())
+		return resp, string(body)
+	}
+
+	t.Run("it exposes every instance's gauges labeled with its app, space and org", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		f := newFakeBatchMetricsFetcher(map[string][]string{"app-a": {"app-a-0", "app-a-1"}})
+		e := newExporter(newBatchProxy(f.GetMetrics, podLister), newFakeSourceLister("app-a", "app-b").List, podLister, labels)
+
+		resp, body := scrape(g, e, "text/plain")
+		g.Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+		appLabels := `app_guid="app-a",app_name="my-app",instance_id="%s",org_guid="org-guid",org_name="my-org",space_guid="space-guid",space_name="my-space"`
+		for _, instanceID := range []string{"0", "1"} {
+			g.Expect(body).To(ContainSubstring(`cf_app_cpu_percent{`+appLabels+`} 42`, instanceID))
+			g.Expect(body).To(ContainSubstring(`cf_app_memory_bytes{`+appLabels+`} 1024`, instanceID))
+			g.Expect(body).To(ContainSubstring(`cf_app_instance_state{`+appLabels+`} 2`, instanceID))
+			g.Expect(body).To(ContainSubstring(`cf_app_instance_restarts{`+appLabels+`} 2`, instanceID))
+		}
+		g.Expect(body).To(ContainSubstring("# TYPE cf_app_cpu_percent gauge"))
+		g.Expect(body).To(MatchRegexp(`cf_app_instance_age_seconds{[^}]*} 6\d`))
+		g.Expect(body).ToNot(ContainSubstring("app-b"))
+		g.Expect(f.calls).To(Equal([][]string{{"app-a", "app-b"}}))
+	})
+
+	t.Run("it speaks OpenMetrics", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		f := newFakeBatchMetricsFetcher(map[string][]string{"app-a": {"app-a-0"}})
+		e := newExporter(newBatchProxy(f.GetMetrics, newPodLister()), newFakeSourceLister("app-a").List, newPodLister(), labels)
+
+		resp, body := scrape(g, e, "application/openmetrics-text; version=0.0.1")
+		g.Expect(resp.Header.Get("Content-Type")).To(HavePrefix("application/openmetrics-text"))
+		g.Expect(body).To(ContainSubstring(`cf_app_cpu_percent{app_guid="app-a",app_name="",instance_id="0"`))
+		g.Expect(body).To(HaveSuffix("# EOF\n"))
+	})
+
+	t.Run("it reuses a recent collection", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		f := newFakeBatchMetricsFetcher(map[string][]string{"app-a": {"app-a-0"}})
+		e := newCachingExporter(newBatchProxy(f.GetMetrics, newPodLister()), newFakeSourceLister("app-a").List, newPodLister(), labels, time.Hour)
+
+		_, first := scrape(g, e, "text/plain")
+		_, second := scrape(g, e, "text/plain")
+		g.Expect(second).To(Equal(first))
+		g.Expect(f.calls).To(HaveLen(1))
+	})
+
+	t.Run("it fails the scrape when it takes longer than Prometheus waits", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		slowFetcher := func(ctx context.Context, _ []string) (map[string]*v1beta1.PodMetricsList, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		e := newExporter(newBatchProxy(slowFetcher, newPodLister()), newFakeSourceLister("app-a").List, newPodLister(), labels)
+
+		server := httptest.NewServer(e.Handler())
+		defer server.Close()
+		req, err := http.NewRequest(http.MethodGet, server.URL+"/app-metrics", nil)
+		g.Expect(err).ToNot(HaveOccurred())
+		req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", "0.05")
+
+		resp, err := http.DefaultClient.Do(req)
+		g.Expect(err).ToNot(HaveOccurred())
+		defer resp.Body.Close()
+		g.Expect(resp.StatusCode).To(Equal(http.StatusInternalServerError))
+	})
+
+	t.Run("it fails the scrape when apps can't be listed", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		failingLister := func(map[string]string) ([]string, error) {
+			return nil, errors.New("informer not synced")
+		}
+		e := newExporter(newBatchProxy(newFakeBatchMetricsFetcher(nil).GetMetrics, newPodLister()), failingLister, newPodLister(), labels)
+
+		resp, body := scrape(g, e, "text/plain")
+		g.Expect(resp.StatusCode).To(Equal(http.StatusInternalServerError))
+		g.Expect(body).To(ContainSubstring("informer not synced"))
+	})
+}
+
+func newExporter(b *metrics.BatchProxy, s metrics.SourceListerFn, l metrics.PodListerFn, labels metrics.ExporterLabels) *metrics.Exporter {
+	return newCachingExporter(b, s, l, labels, 0)
+}
+
+func newCachingExporter(b *metrics.BatchProxy, s metrics.SourceListerFn, l metrics.PodListerFn, labels metrics.ExporterLabels, cacheTTL time.Duration) *metrics.Exporter {
+	logger := logging.New(os.Stderr, logging.Debug)
+	return metrics.NewExporter(logger, b, s, l, labels, time.Minute, cacheTTL)
+}
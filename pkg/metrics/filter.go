@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"regexp"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/log-cache/pkg/rpc/logcache_v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// envelopeFilter keeps the envelopes matching a Read's envelope_types and
+// name_filter, the way log-cache applies them. Clients such as
+// app-autoscaler read timers and gauges separately and count whatever comes
+// back. There is no history, so start_time, end_time, limit and descending
+// don't apply: every Read returns the current sample.
+type envelopeFilter struct {
+	types      []logcache_v1.EnvelopeType
+	nameFilter *regexp.Regexp
+}
+
+func newEnvelopeFilter(req *logcache_v1.ReadRequest) (*envelopeFilter, error) {
+	f := &envelopeFilter{types: req.GetEnvelopeTypes()}
+	if req.GetNameFilter() != "" {
+		nameFilter, err := regexp.Compile(req.GetNameFilter())
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid name_filter: %s", err)
+		}
+		f.nameFilter = nameFilter
+	}
+	return f, nil
+}
+
+func (f *envelopeFilter) apply(envelopes []*loggregator_v2.Envelope) []*loggregator_v2.Envelope {
+	if f.nameFilter == nil && !filtersTypes(f.types) {
+		return envelopes
+	}
+
+	filtered := make([]*loggregator_v2.Envelope, 0, len(envelopes))
+	for _, e := range envelopes {
+		if matchesType(e, f.types) && matchesName(e, f.nameFilter) {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
+
+func filtersTypes(types []logcache_v1.EnvelopeType) bool {
+	for _, t := range types {
+		if t == logcache_v1.EnvelopeType_ANY {
+			return false
+		}
+	}
+	return len(types) > 0
+}
+
+func matchesType(e *loggregator_v2.Envelope, types []logcache_v1.EnvelopeType) bool {
+	if !filtersTypes(types) {
+		return true
+	}
+
+	var envelopeType logcache_v1.EnvelopeType
+	switch e.GetMessage().(type) {
+	case *loggregator_v2.Envelope_Log:
+		envelopeType = logcache_v1.EnvelopeType_LOG
+	case *loggregator_v2.Envelope_Counter:
+		envelopeType = logcache_v1.EnvelopeType_COUNTER
+	case *loggregator_v2.Envelope_Gauge:
+		envelopeType = logcache_v1.EnvelopeType_GAUGE
+	case *loggregator_v2.Envelope_Timer:
+		envelopeType = logcache_v1.EnvelopeType_TIMER
+	case *loggregator_v2.Envelope_Event:
+		envelopeType = logcache_v1.EnvelopeType_EVENT
+	}
+	for _, t := range types {
+		if t == envelopeType {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesName reports whether a counter or timer's name, or any of a
+// gauge's metric names, matches nameFilter.
+func matchesName(e *loggregator_v2.Envelope, nameFilter *regexp.Regexp) bool {
+	if nameFilter == nil {
+		return true
+	}
+
+	switch e.GetMessage().(type) {
+	case *loggregator_v2.Envelope_Counter:
+		return nameFilter.MatchString(e.GetCounter().GetName())
+	case *loggregator_v2.Envelope_Timer:
+		return nameFilter.MatchString(e.GetTimer().GetName())
+	case *loggregator_v2.Envelope_Gauge:
+		for name := range e.GetGauge().GetMetrics() {
+			if nameFilter.MatchString(name) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package metrics
+
+import (
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	v1 "k8s.io/api/core/v1"
+)
+
+// InstanceState is the numeric value reported in the "state" gauge.
+type InstanceState int
+
+const (
+	InstanceStateUnknown InstanceState = iota
+	InstanceStateStarting
+	InstanceStateRunning
+	InstanceStateCrashed
+)
+
+func (s InstanceState) String() string {
+	switch s {
+	case InstanceStateStarting:
+		return "STARTING"
+	case InstanceStateRunning:
+		return "RUNNING"
+	case InstanceStateCrashed:
+		return "CRASHED"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+func instanceGauges(pod *v1.Pod, now time.Time) map[string]*loggregator_v2.GaugeValue {
+	gauges := map[string]*loggregator_v2.GaugeValue{
+		"state": {
+			Unit:  "state",
+			Value: float64(instanceState(pod)),
+		},
+		"restart_count": {
+			Unit:  "count",
+			Value: float64(restartCount(pod)),
+		},
+		"container_age": {
+			Unit:  "nanoseconds",
+			Value: float64(containerAge(pod, now).Nanoseconds()),
+		},
+	}
+
+	if quota, ok := limit(pod, v1.ResourceEphemeralStorage); ok {
+		gauges["disk_quota"] = &loggregator_v2.GaugeValue{
+			Unit:  "bytes",
+			Value: float64(quota),
+		}
+	}
+
+	return gauges
+}
+
+// addMemoryQuota adds the pod's memory limit and the memory usage as a
+// percentage of it to the gauges holding "memory". app-autoscaler expects
+// memory, memory_quota and memoryutil in the same envelope, as Diego emits
+// them.
+func addMemoryQuota(gauges map[string]*loggregator_v2.GaugeValue, pod *v1.Pod) {
+	memory, ok := gauges["memory"]
+	if !ok {
+		return
+	}
+	quota, ok := limit(pod, v1.ResourceMemory)
+	if !ok {
+		return
+	}
+
+	gauges["memory_quota"] = &loggregator_v2.GaugeValue{
+		Unit:  "bytes",
+		Value: float64(quota),
+	}
+	if quota > 0 {
+		gauges["memoryutil"] = &loggregator_v2.GaugeValue{
+			Unit:  "percentage",
+			Value: memory.GetValue() / float64(quota) * 100,
+		}
+	}
+}
+
+// limit sums the given resource limit across app containers. It reports
+// false when no app container sets the limit.
+func limit(pod *v1.Pod, resourceName v1.ResourceName) (int64, bool) {
+	var (
+		sum   int64
+		found bool
+	)
+	for _, container := range pod.Spec.Containers {
+		if isIstio(container.Name) {
+			continue
+		}
+		if quantity, ok := container.Resources.Limits[resourceName]; ok {
+			sum += quantity.Value()
+			found = true
+		}
+	}
+	return sum, found
+}
+
+func appContainerStatuses(pod *v1.Pod) []v1.ContainerStatus {
+	var statuses []v1.ContainerStatus
+	for _, status := range pod.Status.ContainerStatuses {
+		if isIstio(status.Name) {
+			continue
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+func instanceState(pod *v1.Pod) InstanceState {
+	switch pod.Status.Phase {
+	case v1.PodFailed:
+		return InstanceStateCrashed
+	case v1.PodUnknown, "":
+		return InstanceStateUnknown
+	}
+
+	statuses := appContainerStatuses(pod)
+	if len(statuses) == 0 {
+		return InstanceStateStarting
+	}
+
+	state := InstanceStateRunning
+	for _, status := range statuses {
+		switch {
+		case status.State.Terminated != nil && status.State.Terminated.ExitCode != 0:
+			return InstanceStateCrashed
+		case status.State.Waiting != nil && status.State.Waiting.Reason == "CrashLoopBackOff":
+			return InstanceStateCrashed
+		case status.State.Running == nil || !status.Ready:
+			state = InstanceStateStarting
+		}
+	}
+	return state
+}
+
+func restartCount(pod *v1.Pod) int32 {
+	var sum int32
+	for _, status := range appContainerStatuses(pod) {
+		sum += status.RestartCount
+	}
+	return sum
+}
+
+// containerAge is the time since the most recently started app container
+// began running, or zero if any app container is not running. Like Diego's
+// container_age, it starts again from zero when the instance restarts.
+func containerAge(pod *v1.Pod, now time.Time) time.Duration {
+	statuses := appContainerStatuses(pod)
+	if len(statuses) == 0 {
+		return 0
+	}
+
+	var latest time.Time
+	for _, status := range statuses {
+		if status.State.Running == nil {
+			return 0
+		}
+		if startedAt := status.State.Running.StartedAt.Time; startedAt.After(latest) {
+			latest = startedAt
+		}
+	}
+	return now.Sub(latest)
+}
@@ -5,13 +5,15 @@ import (
 	"sync"
 
 	"code.cloudfoundry.org/metric-proxy/pkg/metrics"
+	"golang.org/x/net/context"
 )
 
 type FakeDiskUsageFetcher struct {
-	DiskUsageStub        func(string) (int64, error)
+	DiskUsageStub        func(context.Context, string) (int64, error)
 	diskUsageMutex       sync.RWMutex
 	diskUsageArgsForCall []struct {
-		arg1 string
+		arg1 context.Context
+		arg2 string
 	}
 	diskUsageReturns struct {
 		result1 int64
@@ -25,18 +27,19 @@ type FakeDiskUsageFetcher struct {
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *FakeDiskUsageFetcher) DiskUsage(arg1 string) (int64, error) {
+func (fake *FakeDiskUsageFetcher) DiskUsage(arg1 context.Context, arg2 string) (int64, error) {
 	fake.diskUsageMutex.Lock()
 	ret, specificReturn := fake.diskUsageReturnsOnCall[len(fake.diskUsageArgsForCall)]
 	fake.diskUsageArgsForCall = append(fake.diskUsageArgsForCall, struct {
-		arg1 string
-	}{arg1})
+		arg1 context.Context
+		arg2 string
+	}{arg1, arg2})
 	stub := fake.DiskUsageStub
 	fakeReturns := fake.diskUsageReturns
-	fake.recordInvocation("DiskUsage", []interface{}{arg1})
+	fake.recordInvocation("DiskUsage", []interface{}{arg1, arg2})
 	fake.diskUsageMutex.Unlock()
 	if stub != nil {
-		return stub(arg1)
+		return stub(arg1, arg2)
 	}
 	if specificReturn {
 		return ret.result1, ret.result2
@@ -50,17 +53,17 @@ func (fake *FakeDiskUsageFetcher) DiskUsageCallCount() int {
 	return len(fake.diskUsageArgsForCall)
 }
 
-func (fake *FakeDiskUsageFetcher) DiskUsageCalls(stub func(string) (int64, error)) {
+func (fake *FakeDiskUsageFetcher) DiskUsageCalls(stub func(context.Context, string) (int64, error)) {
 	fake.diskUsageMutex.Lock()
 	defer fake.diskUsageMutex.Unlock()
 	fake.DiskUsageStub = stub
 }
 
-func (fake *FakeDiskUsageFetcher) DiskUsageArgsForCall(i int) string {
+func (fake *FakeDiskUsageFetcher) DiskUsageArgsForCall(i int) (context.Context, string) {
 	fake.diskUsageMutex.RLock()
 	defer fake.diskUsageMutex.RUnlock()
 	argsForCall := fake.diskUsageArgsForCall[i]
-	return argsForCall.arg1
+	return argsForCall.arg1, argsForCall.arg2
 }
 
 func (fake *FakeDiskUsageFetcher) DiskUsageReturns(result1 int64, result2 error) {
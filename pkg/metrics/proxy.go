@@ -2,8 +2,8 @@ package metrics
 
 import (
 	"fmt"
-	"log"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -11,9 +11,14 @@ import (
 	"golang.org/x/net/context"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/cache"
 	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
 
 	"code.cloudfoundry.org/log-cache/pkg/rpc/logcache_v1"
+	"code.cloudfoundry.org/metric-proxy/pkg/logging"
+	"code.cloudfoundry.org/metric-proxy/pkg/selfmetrics"
+	"code.cloudfoundry.org/metric-proxy/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 //go:generate go run github.com/maxbrunsfeld/counterfeiter/v6 -generate
@@ -21,61 +26,231 @@ import (
 //counterfeiter:generate . DiskUsageFetcher
 
 type DiskUsageFetcher interface {
-	DiskUsage(podName string) (int64, error)
+	DiskUsage(ctx context.Context, podName string) (int64, error)
 }
 
-type MetricsFetcherFn func(guid string) (*v1beta1.PodMetricsList, error)
+//counterfeiter:generate . CPUTimeFetcher
+
+// CPUTimeFetcher returns the CPU time, in nanoseconds, a pod's app
+// containers have used since they started.
+type CPUTimeFetcher interface {
+	CPUTime(ctx context.Context, pod *v1.Pod) (uint64, error)
+}
+
+//counterfeiter:generate . DiskIOFetcher
+
+// DiskIOFetcher returns the bytes a pod's app containers have read from and
+// written to their filesystems since they started.
+type DiskIOFetcher interface {
+	DiskIO(ctx context.Context, pod *v1.Pod) (readBytes, writeBytes uint64, err error)
+}
+
+//counterfeiter:generate . PodGetter
+
+type PodGetter interface {
+	Get(ctx context.Context, podName string) (*v1.Pod, error)
+}
+
+type MetricsFetcherFn func(ctx context.Context, guid string) (*v1beta1.PodMetricsList, error)
+
+// PodListerFn returns every pod that belongs to the given app guid, whether
+// or not metrics-server has metrics for it yet.
+type PodListerFn func(guid string) ([]*v1.Pod, error)
+
+// CustomEnvelopesFn returns the envelopes an app emits itself, such as the
+// metrics scraped from its Prometheus endpoint, to be read alongside its
+// container metrics.
+type CustomEnvelopesFn func(sourceID string) []*loggregator_v2.Envelope
+
+// CPUTimeCounter is the counter holding the CPU time, in nanoseconds, an
+// instance has used. It keeps counting up across container restarts.
+const CPUTimeCounter = "cpu_time"
+
+// DiskReadBytesCounter and DiskWriteBytesCounter are the counters holding
+// the bytes an instance has read from and written to its filesystems. Like
+// CPUTimeCounter, they keep counting up across container restarts.
+const (
+	DiskReadBytesCounter  = "disk_read_bytes"
+	DiskWriteBytesCounter = "disk_write_bytes"
+)
 
 type Proxy struct {
-	logger           *log.Logger
+	logger           *logging.Logger
+	selfMetrics      *selfmetrics.Metrics
+	metricsCache     *MetricsCache
 	metricsFetcherFn MetricsFetcherFn
+	podListerFn      PodListerFn
 	diskUsageFetcher DiskUsageFetcher
+	cpuTimeFetcher   CPUTimeFetcher
+	diskIOFetcher    DiskIOFetcher
+	podGetter        PodGetter
+	customEnvelopes  CustomEnvelopesFn
+	counters         *counterTracker
 }
 
-func NewProxy(logger *log.Logger, metricsFetcherFn MetricsFetcherFn, diskUsageFetcher DiskUsageFetcher) *Proxy {
+// ProxyOptions holds the optional dependencies of a Proxy. The zero value
+// adds nothing to Reads.
+type ProxyOptions struct {
+	// CPUTimeFetcher adds a CPU time counter to each instance.
+	CPUTimeFetcher CPUTimeFetcher
+	// DiskIOFetcher adds disk read and write counters to each instance.
+	DiskIOFetcher DiskIOFetcher
+	// CustomEnvelopes adds an app's own envelopes after its container
+	// metrics.
+	CustomEnvelopes CustomEnvelopesFn
+}
+
+func NewProxy(logger *logging.Logger, selfMetrics *selfmetrics.Metrics, metricsCache *MetricsCache, metricsFetcherFn MetricsFetcherFn, podListerFn PodListerFn, diskUsageFetcher DiskUsageFetcher, podGetter PodGetter, opts ProxyOptions) *Proxy {
 	return &Proxy{
 		logger:           logger,
+		selfMetrics:      selfMetrics,
+		metricsCache:     metricsCache,
 		metricsFetcherFn: metricsFetcherFn,
+		podListerFn:      podListerFn,
 		diskUsageFetcher: diskUsageFetcher,
+		cpuTimeFetcher:   opts.CPUTimeFetcher,
+		diskIOFetcher:    opts.DiskIOFetcher,
+		podGetter:        podGetter,
+		customEnvelopes:  opts.CustomEnvelopes,
+		counters:         newCounterTracker(cache.NewExpiring()),
+	}
+}
+
+func (m *Proxy) Read(ctx context.Context, req *logcache_v1.ReadRequest) (*logcache_v1.ReadResponse, error) {
+	filter, err := newEnvelopeFilter(req)
+	if err != nil {
+		return nil, err
+	}
+
+	sourceID := strings.TrimSuffix(req.GetSourceId(), RollupSourceIDSuffix)
+	rollup := sourceID != req.GetSourceId()
+	if rollup {
+		req = &logcache_v1.ReadRequest{SourceId: sourceID}
+	}
+
+	logger := logging.FromContext(ctx, m.logger).With("source_id", sourceID)
+	ctx = logging.NewContext(ctx, logger)
+
+	podMetrics, err := m.fetchMetrics(ctx, req.SourceId)
+	if err != nil {
+		logger.Error("failed to get metrics", "error", err)
+		return nil, err
 	}
+
+	envelopes, err := m.createEnvelopes(ctx, req, podMetrics)
+	if err != nil {
+		return nil, err
+	}
+	if rollup {
+		envelopes = append(envelopes, createRollupEnvelopes(sourceID, envelopes)...)
+	}
+	envelopes = filter.apply(envelopes)
+
+	resp := &logcache_v1.ReadResponse{
+		Envelopes: &loggregator_v2.EnvelopeBatch{
+			Batch: envelopes,
+		},
+	}
+
+	return resp, nil
 }
 
-func (m *Proxy) Read(_ context.Context, req *logcache_v1.ReadRequest) (*logcache_v1.ReadResponse, error) {
+// createEnvelopes converts the source's pod metrics into envelopes, adding
+// placeholders for its pods that have no metrics yet.
+func (m *Proxy) createEnvelopes(ctx context.Context, req *logcache_v1.ReadRequest, podMetrics *v1beta1.PodMetricsList) ([]*loggregator_v2.Envelope, error) {
 	var envelopes []*loggregator_v2.Envelope
 
-	podMetrics, err := m.metricsFetcherFn(req.SourceId)
+	logger := logging.FromContext(ctx, m.logger)
+
+	pods, err := m.podListerFn(req.SourceId)
 	if err != nil {
-		m.logger.Printf("failed to get metrics: %v", err)
+		logger.Error("failed to list pods", "error", err)
 		return nil, err
 	}
 
+	podsByName := make(map[string]*v1.Pod, len(pods))
+	for _, pod := range pods {
+		podsByName[pod.Name] = pod
+	}
+
 	for _, podMetric := range podMetrics.Items {
+		pod, ok := podsByName[podMetric.Name]
+		if !ok {
+			pod, err = m.getPod(ctx, podMetric.Name)
+			if err != nil {
+				logger.Error("error fetching pod", "pod", podMetric.Name, "error", err)
+				return nil, fmt.Errorf("failed getting instance status: %w", err)
+			}
+		}
+
 		metrics := aggregateContainerMetrics(podMetric.Containers)
 
 		for k, v := range metrics {
+			gauges := m.createGaugeMap(v1.ResourceName(k), v)
+			addMemoryQuota(gauges, pod)
 			envelopes = append(envelopes,
 				m.createLoggregatorEnvelope(
 					req,
-					m.createGaugeMap(v1.ResourceName(k), v),
-					getInstanceID(podMetric),
+					gauges,
+					InstanceID(podMetric.Name),
 				),
 			)
 		}
 
-		diskEnvelope, err := m.createDiskEnvelope(req, podMetric)
+		diskEnvelope, err := m.createDiskEnvelope(ctx, req, podMetric)
 		if err != nil {
 			return nil, fmt.Errorf("failed getting disk usage: %w", err)
 		}
 		envelopes = append(envelopes, diskEnvelope)
+
+		if cpuTimeEnvelope, ok := m.createCPUTimeEnvelope(ctx, req, pod); ok {
+			envelopes = append(envelopes, cpuTimeEnvelope)
+		}
+		envelopes = append(envelopes, m.createDiskIOEnvelopes(ctx, req, pod)...)
+
+		envelopes = append(envelopes, m.createInstanceEnvelope(req, pod))
+		delete(podsByName, podMetric.Name)
 	}
 
-	resp := &logcache_v1.ReadResponse{
-		Envelopes: &loggregator_v2.EnvelopeBatch{
-			Batch: envelopes,
-		},
+	missing := podsWithoutMetrics(podsByName)
+	for _, pod := range missing {
+		logger.Debug("pod has no metrics yet", "pod", pod.Name)
+		envelopes = append(envelopes, m.createPlaceholderEnvelopes(req, pod)...)
 	}
+	m.selfMetrics.PodsWithoutMetrics(len(missing))
 
-	return resp, nil
+	if m.customEnvelopes != nil {
+		envelopes = append(envelopes, m.customEnvelopes(req.SourceId)...)
+	}
+	m.selfMetrics.ObserveEnvelopes(len(envelopes))
+
+	logger.Debug("read complete", "pods", len(podMetrics.Items), "envelopes", len(envelopes))
+
+	return envelopes, nil
+}
+
+func (m *Proxy) fetchMetrics(ctx context.Context, guid string) (*v1beta1.PodMetricsList, error) {
+	return m.metricsCache.Fetch(ctx, guid, m.listMetrics)
+}
+
+func (m *Proxy) listMetrics(ctx context.Context, guid string) (*v1beta1.PodMetricsList, error) {
+	start := time.Now()
+	ctx, span := tracing.StartSpan(ctx, "metrics-api.list", attribute.String("source_id", guid))
+	podMetrics, err := m.metricsFetcherFn(ctx, guid)
+	tracing.End(span, err)
+	m.selfMetrics.ObserveUpstream(selfmetrics.DependencyMetricsAPI, start, err)
+
+	return podMetrics, err
+}
+
+func (m *Proxy) getPod(ctx context.Context, podName string) (*v1.Pod, error) {
+	start := time.Now()
+	ctx, span := tracing.StartSpan(ctx, "pods.get", attribute.String("pod", podName))
+	pod, err := m.podGetter.Get(ctx, podName)
+	tracing.End(span, err)
+	m.selfMetrics.ObserveUpstream(selfmetrics.DependencyPods, start, err)
+
+	return pod, err
 }
 
 func (m *Proxy) Meta(context.Context, *logcache_v1.MetaRequest) (*logcache_v1.MetaResponse, error) {
@@ -111,12 +286,12 @@ func isIstio(podName string) bool {
 	return b
 }
 
-func (m *Proxy) createDiskEnvelope(req *logcache_v1.ReadRequest, podMetric v1beta1.PodMetrics) (*loggregator_v2.Envelope, error) {
-	instanceID := getInstanceID(podMetric)
+func (m *Proxy) createDiskEnvelope(ctx context.Context, req *logcache_v1.ReadRequest, podMetric v1beta1.PodMetrics) (*loggregator_v2.Envelope, error) {
+	instanceID := InstanceID(podMetric.Name)
 
-	podDiskUsage, err := m.diskUsageFetcher.DiskUsage(podMetric.Name)
+	podDiskUsage, err := m.diskUsageFetcher.DiskUsage(ctx, podMetric.Name)
 	if err != nil {
-		m.logger.Printf("error fetching disk usage: %v", err)
+		logging.FromContext(ctx, m.logger).Error("error fetching disk usage", "pod", podMetric.Name, "error", err)
 		return nil, err
 	}
 
@@ -129,6 +304,113 @@ func (m *Proxy) createDiskEnvelope(req *logcache_v1.ReadRequest, podMetric v1bet
 	), nil
 }
 
+// createCPUTimeEnvelope reports the CPU time the pod has used as a counter
+// with its total only: Reads by different clients can't share a delta.
+// Failing to fetch it only loses the counter, not the Read.
+func (m *Proxy) createCPUTimeEnvelope(ctx context.Context, req *logcache_v1.ReadRequest, pod *v1.Pod) (*loggregator_v2.Envelope, bool) {
+	if m.cpuTimeFetcher == nil {
+		return nil, false
+	}
+
+	cpuTime, err := m.cpuTimeFetcher.CPUTime(ctx, pod)
+	if err != nil {
+		logging.FromContext(ctx, m.logger).Warn("error fetching cpu time", "pod", pod.Name, "error", err)
+		return nil, false
+	}
+
+	return m.createCounterEnvelope(req, pod, CPUTimeCounter, cpuTime), true
+}
+
+// createDiskIOEnvelopes reports the bytes the pod has read from and written
+// to its filesystems as counters, like createCPUTimeEnvelope.
+func (m *Proxy) createDiskIOEnvelopes(ctx context.Context, req *logcache_v1.ReadRequest, pod *v1.Pod) []*loggregator_v2.Envelope {
+	if m.diskIOFetcher == nil {
+		return nil
+	}
+
+	readBytes, writeBytes, err := m.diskIOFetcher.DiskIO(ctx, pod)
+	if err != nil {
+		logging.FromContext(ctx, m.logger).Warn("error fetching disk io", "pod", pod.Name, "error", err)
+		return nil
+	}
+
+	return []*loggregator_v2.Envelope{
+		m.createCounterEnvelope(req, pod, DiskReadBytesCounter, readBytes),
+		m.createCounterEnvelope(req, pod, DiskWriteBytesCounter, writeBytes),
+	}
+}
+
+// createCounterEnvelope reports value, which restarts from zero with the
+// pod's containers, as the named counter's total.
+func (m *Proxy) createCounterEnvelope(req *logcache_v1.ReadRequest, pod *v1.Pod, name string, value uint64) *loggregator_v2.Envelope {
+	instanceID := InstanceID(pod.Name)
+	total := m.counters.observe(req.GetSourceId()+"/"+instanceID+"/"+name, containerGeneration(pod), value)
+
+	return &loggregator_v2.Envelope{
+		Timestamp:  time.Now().UnixNano(),
+		SourceId:   req.GetSourceId(),
+		InstanceId: instanceID,
+		Tags: map[string]string{
+			"process_id": req.GetSourceId(),
+			"origin":     "rep",
+		},
+		Message: &loggregator_v2.Envelope_Counter{
+			Counter: &loggregator_v2.Counter{
+				Name:  name,
+				Total: total,
+			},
+		},
+	}
+}
+
+func (m *Proxy) createInstanceEnvelope(req *logcache_v1.ReadRequest, pod *v1.Pod) *loggregator_v2.Envelope {
+	return m.createLoggregatorEnvelope(
+		req,
+		instanceGauges(pod, time.Now()),
+		InstanceID(pod.Name),
+	)
+}
+
+// createPlaceholderEnvelopes reports zero usage for a pod that metrics-server
+// hasn't scraped yet, so that starting or crashing instances still show up.
+func (m *Proxy) createPlaceholderEnvelopes(req *logcache_v1.ReadRequest, pod *v1.Pod) []*loggregator_v2.Envelope {
+	instanceID := InstanceID(pod.Name)
+	placeholders := []struct {
+		name  v1.ResourceName
+		value resource.Quantity
+	}{
+		{v1.ResourceCPU, *resource.NewScaledQuantity(0, resource.Nano)},
+		{v1.ResourceMemory, *resource.NewQuantity(0, resource.BinarySI)},
+		{"disk", *resource.NewQuantity(0, resource.BinarySI)},
+	}
+
+	var envelopes []*loggregator_v2.Envelope
+	for _, p := range placeholders {
+		gauges := m.createGaugeMap(p.name, p.value)
+		addMemoryQuota(gauges, pod)
+		envelopes = append(envelopes,
+			m.createLoggregatorEnvelope(req, gauges, instanceID),
+		)
+	}
+
+	return append(envelopes, m.createInstanceEnvelope(req, pod))
+}
+
+func podsWithoutMetrics(podsByName map[string]*v1.Pod) []*v1.Pod {
+	var pods []*v1.Pod
+	for _, pod := range podsByName {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		pods = append(pods, pod)
+	}
+
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].Name < pods[j].Name
+	})
+	return pods
+}
+
 func (m *Proxy) createLoggregatorEnvelope(
 	req *logcache_v1.ReadRequest,
 	gauges map[string]*loggregator_v2.GaugeValue,
@@ -172,7 +454,9 @@ func (m *Proxy) createGaugeMap(k v1.ResourceName, v resource.Quantity) map[strin
 	return gauges
 }
 
-func getInstanceID(podMetric v1beta1.PodMetrics) string {
-	s := strings.Split(podMetric.Name, "-")
+// InstanceID is the app instance index of a pod, the last part of its
+// name.
+func InstanceID(podName string) string {
+	s := strings.Split(podName, "-")
 	return s[len(s)-1]
 }
@@ -1,20 +1,29 @@
 package metrics_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"io/ioutil"
 	"net"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
 	"code.cloudfoundry.org/log-cache/pkg/rpc/logcache_v1"
+	"code.cloudfoundry.org/metric-proxy/pkg/logging"
 	"code.cloudfoundry.org/metric-proxy/pkg/metrics"
 	"code.cloudfoundry.org/metric-proxy/pkg/metrics/metricsfakes"
+	"code.cloudfoundry.org/metric-proxy/pkg/selfmetrics"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -29,7 +38,7 @@ func TestMetricsProxyRead(t *testing.T) {
 		f := newFakeMetricsFetcher(corev1.ResourceList{
 			"cpu": *resource.NewScaledQuantity(420000000, resource.Nano),
 		})
-		stop, err := startGRPCServer(f.GetMetrics, fakeDiskUsageFetcher)
+		stop, err := startGRPCServer(f.GetMetrics, fakeDiskUsageFetcher, newFakePodGetter())
 		g.Expect(err).ToNot(HaveOccurred())
 		defer stop()
 
@@ -43,7 +52,7 @@ func TestMetricsProxyRead(t *testing.T) {
 		})
 		g.Expect(err).ToNot(HaveOccurred())
 
-		g.Expect(resp.Envelopes.Batch).To(HaveLen(2))
+		g.Expect(resp.Envelopes.Batch).To(HaveLen(3))
 		g.Expect(resp.Envelopes.Batch[0].SourceId).To(Equal("fake-source"))
 		g.Expect(resp.Envelopes.Batch[0].GetGauge().Metrics).To(BeEquivalentTo(map[string]*loggregator_v2.GaugeValue{
 			"cpu": {
@@ -63,7 +72,7 @@ func TestMetricsProxyRead(t *testing.T) {
 			"metric3": *resource.NewQuantity(42, "metric3_format"),
 			"metric4": *resource.NewQuantity(42, "metric4_format"),
 		})
-		stop, err := startGRPCServer(f.GetMetrics, fakeDiskUsageFetcher)
+		stop, err := startGRPCServer(f.GetMetrics, fakeDiskUsageFetcher, newFakePodGetter())
 		g.Expect(err).ToNot(HaveOccurred())
 		defer stop()
 
@@ -77,7 +86,7 @@ func TestMetricsProxyRead(t *testing.T) {
 		})
 		g.Expect(err).ToNot(HaveOccurred())
 
-		g.Expect(resp.Envelopes.Batch).To(HaveLen(5))
+		g.Expect(resp.Envelopes.Batch).To(HaveLen(6))
 		g.Expect(resp.Envelopes.Batch[0].SourceId).To(Equal("fake-source-1"))
 	})
 
@@ -86,7 +95,7 @@ func TestMetricsProxyRead(t *testing.T) {
 
 		fakeDiskUsageFetcher := new(metricsfakes.FakeDiskUsageFetcher)
 		f := newErrorFetcher("there is a fake error")
-		stop, err := startGRPCServer(f, fakeDiskUsageFetcher)
+		stop, err := startGRPCServer(f, fakeDiskUsageFetcher, newFakePodGetter())
 		g.Expect(err).ToNot(HaveOccurred())
 		defer stop()
 
@@ -109,7 +118,7 @@ func TestMetricsProxyRead(t *testing.T) {
 		f := newFakeMetricsFetcher(corev1.ResourceList{
 			"cpu": *resource.NewScaledQuantity(420000000, resource.Nano),
 		})
-		stop, err := startGRPCServer(f.GetMetrics, fakeDiskUsageFetcher)
+		stop, err := startGRPCServer(f.GetMetrics, fakeDiskUsageFetcher, newFakePodGetter())
 		g.Expect(err).ToNot(HaveOccurred())
 		defer stop()
 
@@ -131,7 +140,7 @@ func TestMetricsProxyRead(t *testing.T) {
 		f := newFakeMetricsFetcher(corev1.ResourceList{
 			"memory": *resource.NewQuantity(420000, "BinarySI"),
 		})
-		stop, err := startGRPCServer(f.GetMetrics, fakeDiskUsageFetcher)
+		stop, err := startGRPCServer(f.GetMetrics, fakeDiskUsageFetcher, newFakePodGetter())
 		g.Expect(err).ToNot(HaveOccurred())
 		defer stop()
 
@@ -145,7 +154,7 @@ func TestMetricsProxyRead(t *testing.T) {
 		})
 		g.Expect(err).ToNot(HaveOccurred())
 
-		g.Expect(resp.Envelopes.Batch).To(HaveLen(2))
+		g.Expect(resp.Envelopes.Batch).To(HaveLen(3))
 		g.Expect(resp.Envelopes.Batch[0].SourceId).To(Equal("fake-source"))
 		g.Expect(resp.Envelopes.Batch[0].GetGauge().Metrics).To(BeEquivalentTo(map[string]*loggregator_v2.GaugeValue{
 			"memory": {
@@ -162,7 +171,7 @@ func TestMetricsProxyRead(t *testing.T) {
 		f := newFakeMetricsFetcher(corev1.ResourceList{
 			"cpu": *resource.NewScaledQuantity(500000000, resource.Nano),
 		})
-		stop, err := startGRPCServer(f.GetMetrics, fakeDiskUsageFetcher)
+		stop, err := startGRPCServer(f.GetMetrics, fakeDiskUsageFetcher, newFakePodGetter())
 		g.Expect(err).ToNot(HaveOccurred())
 		defer stop()
 
@@ -176,7 +185,7 @@ func TestMetricsProxyRead(t *testing.T) {
 		})
 		g.Expect(err).ToNot(HaveOccurred())
 
-		g.Expect(resp.Envelopes.Batch).To(HaveLen(2))
+		g.Expect(resp.Envelopes.Batch).To(HaveLen(3))
 		g.Expect(resp.Envelopes.Batch[0].SourceId).To(Equal("fake-source"))
 		g.Expect(resp.Envelopes.Batch[0].GetGauge().Metrics).To(BeEquivalentTo(map[string]*loggregator_v2.GaugeValue{
 			"cpu": {
@@ -192,7 +201,7 @@ func TestMetricsProxyRead(t *testing.T) {
 		fakeDiskUsageFetcher := new(metricsfakes.FakeDiskUsageFetcher)
 		fakeDiskUsageFetcher.DiskUsageReturns(300, nil)
 		f := newFakeMetricsFetcher(corev1.ResourceList{})
-		stop, err := startGRPCServer(f.GetMetrics, fakeDiskUsageFetcher)
+		stop, err := startGRPCServer(f.GetMetrics, fakeDiskUsageFetcher, newFakePodGetter())
 		g.Expect(err).ToNot(HaveOccurred())
 		defer stop()
 
@@ -206,7 +215,7 @@ func TestMetricsProxyRead(t *testing.T) {
 		})
 		g.Expect(err).ToNot(HaveOccurred())
 
-		g.Expect(resp.Envelopes.Batch).To(HaveLen(1))
+		g.Expect(resp.Envelopes.Batch).To(HaveLen(2))
 		g.Expect(resp.Envelopes.Batch[0].SourceId).To(Equal("fake-source"))
 		g.Expect(resp.Envelopes.Batch[0].GetGauge().Metrics).To(BeEquivalentTo(map[string]*loggregator_v2.GaugeValue{
 			"disk": {
@@ -223,7 +232,7 @@ func TestMetricsProxyRead(t *testing.T) {
 		f := newFakeMetricsFetcher(corev1.ResourceList{
 			"cpu": *resource.NewScaledQuantity(420000000, resource.Nano),
 		})
-		stop, err := startGRPCServer(f.GetMetrics, fakeDiskUsageFetcher)
+		stop, err := startGRPCServer(f.GetMetrics, fakeDiskUsageFetcher, newFakePodGetter())
 		g.Expect(err).ToNot(HaveOccurred())
 		defer stop()
 
@@ -238,7 +247,7 @@ func TestMetricsProxyRead(t *testing.T) {
 		g.Expect(err).ToNot(HaveOccurred())
 
 		g.Eventually(f.processGUID).Should(Receive(Equal("fake-source-id")))
-		g.Expect(resp.Envelopes.Batch).To(HaveLen(2))
+		g.Expect(resp.Envelopes.Batch).To(HaveLen(3))
 	})
 
 	t.Run("it sums cpu/mem metrics across containers in each pod", func(t *testing.T) {
@@ -246,7 +255,7 @@ func TestMetricsProxyRead(t *testing.T) {
 
 		fakeDiskUsageFetcher := new(metricsfakes.FakeDiskUsageFetcher)
 		fakeDiskUsageFetcher.DiskUsageReturns(1234, nil)
-		f := func(string) (*v1beta1.PodMetricsList, error) {
+		f := func(context.Context, string) (*v1beta1.PodMetricsList, error) {
 			return &v1beta1.PodMetricsList{
 				TypeMeta: v1.TypeMeta{},
 				ListMeta: v1.ListMeta{},
@@ -272,7 +281,7 @@ func TestMetricsProxyRead(t *testing.T) {
 				},
 			}, nil
 		}
-		stop, err := startGRPCServer(f, fakeDiskUsageFetcher)
+		stop, err := startGRPCServer(f, fakeDiskUsageFetcher, newFakePodGetter())
 
 		g.Expect(err).ToNot(HaveOccurred())
 		defer stop()
@@ -287,9 +296,12 @@ func TestMetricsProxyRead(t *testing.T) {
 		})
 		g.Expect(err).ToNot(HaveOccurred())
 
-		g.Expect(resp.Envelopes.Batch).To(HaveLen(3))
+		g.Expect(resp.Envelopes.Batch).To(HaveLen(4))
 		results := map[string]*loggregator_v2.GaugeValue{}
 		for _, e := range resp.Envelopes.Batch {
+			if _, ok := e.GetGauge().Metrics["state"]; ok {
+				continue
+			}
 			for k, v := range e.GetGauge().Metrics {
 				results[k] = v
 			}
@@ -314,7 +326,7 @@ func TestMetricsProxyRead(t *testing.T) {
 	t.Run("it excludes platform containers from metric sums", func(t *testing.T) {
 		g := NewGomegaWithT(t)
 
-		f := func(string) (*v1beta1.PodMetricsList, error) {
+		f := func(context.Context, string) (*v1beta1.PodMetricsList, error) {
 			return &v1beta1.PodMetricsList{
 				TypeMeta: v1.TypeMeta{},
 				ListMeta: v1.ListMeta{},
@@ -358,7 +370,7 @@ func TestMetricsProxyRead(t *testing.T) {
 		fakeDiskUsageFetcher := new(metricsfakes.FakeDiskUsageFetcher)
 		fakeDiskUsageFetcher.DiskUsageReturns(1234, nil)
 
-		stop, err := startGRPCServer(f, fakeDiskUsageFetcher)
+		stop, err := startGRPCServer(f, fakeDiskUsageFetcher, newFakePodGetter())
 		g.Expect(err).ToNot(HaveOccurred())
 		defer stop()
 
@@ -372,9 +384,12 @@ func TestMetricsProxyRead(t *testing.T) {
 		})
 		g.Expect(err).ToNot(HaveOccurred())
 
-		g.Expect(resp.Envelopes.Batch).To(HaveLen(3))
+		g.Expect(resp.Envelopes.Batch).To(HaveLen(4))
 		results := map[string]*loggregator_v2.GaugeValue{}
 		for _, e := range resp.Envelopes.Batch {
+			if _, ok := e.GetGauge().Metrics["state"]; ok {
+				continue
+			}
 			for k, v := range e.GetGauge().Metrics {
 				results[k] = v
 			}
@@ -404,7 +419,7 @@ func TestMetricsProxyRead(t *testing.T) {
 		})
 		f.appCount = 2
 
-		stop, err := startGRPCServer(f.GetMetrics, fakeDiskUsageFetcher)
+		stop, err := startGRPCServer(f.GetMetrics, fakeDiskUsageFetcher, newFakePodGetter())
 		g.Expect(err).ToNot(HaveOccurred())
 		defer stop()
 
@@ -420,14 +435,519 @@ func TestMetricsProxyRead(t *testing.T) {
 
 		g.Expect(resp.Envelopes.Batch[0].InstanceId).To(Equal("0"))
 		g.Expect(resp.Envelopes.Batch[1].InstanceId).To(Equal("0"))
-		g.Expect(resp.Envelopes.Batch[2].InstanceId).To(Equal("1"))
+		g.Expect(resp.Envelopes.Batch[2].InstanceId).To(Equal("0"))
 		g.Expect(resp.Envelopes.Batch[3].InstanceId).To(Equal("1"))
+		g.Expect(resp.Envelopes.Batch[4].InstanceId).To(Equal("1"))
+		g.Expect(resp.Envelopes.Batch[5].InstanceId).To(Equal("1"))
+	})
+}
+
+func TestMetricsProxyInstanceStatus(t *testing.T) {
+	readInstanceGauges := func(g *GomegaWithT, p metrics.PodGetter) map[string]*loggregator_v2.GaugeValue {
+		fakeDiskUsageFetcher := new(metricsfakes.FakeDiskUsageFetcher)
+		f := newFakeMetricsFetcher(corev1.ResourceList{})
+		stop, err := startGRPCServer(f.GetMetrics, fakeDiskUsageFetcher, p)
+		g.Expect(err).ToNot(HaveOccurred())
+		defer stop()
+
+		conn, err := grpc.Dial(":8080", grpc.WithInsecure())
+		g.Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		client := logcache_v1.NewEgressClient(conn)
+		resp, err := client.Read(context.Background(), &logcache_v1.ReadRequest{
+			SourceId: "fake-source",
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(resp.Envelopes.Batch).To(HaveLen(2))
+
+		return resp.Envelopes.Batch[1].GetGauge().Metrics
+	}
+
+	t.Run("it returns instance status gauges for a running pod", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		fakePodGetter := newFakePodGetter()
+		gauges := readInstanceGauges(g, fakePodGetter)
+
+		g.Expect(fakePodGetter.GetCallCount()).To(Equal(1))
+		_, podName := fakePodGetter.GetArgsForCall(0)
+		g.Expect(podName).To(Equal("test-app-0"))
+
+		g.Expect(gauges).To(HaveLen(3))
+		g.Expect(gauges["state"]).To(Equal(&loggregator_v2.GaugeValue{
+			Unit:  "state",
+			Value: float64(metrics.InstanceStateRunning),
+		}))
+		g.Expect(gauges["restart_count"]).To(Equal(&loggregator_v2.GaugeValue{
+			Unit:  "count",
+			Value: 2,
+		}))
+		g.Expect(gauges["container_age"].Unit).To(Equal("nanoseconds"))
+		g.Expect(gauges["container_age"].Value).To(BeNumerically("~", float64(time.Minute), float64(5*time.Second)))
+	})
+
+	t.Run("it reports a pod with an unready container as starting", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		pod := newRunningPod()
+		pod.Status.ContainerStatuses[1].Ready = false
+		fakePodGetter := new(metricsfakes.FakePodGetter)
+		fakePodGetter.GetReturns(pod, nil)
+
+		gauges := readInstanceGauges(g, fakePodGetter)
+
+		g.Expect(gauges["state"].Value).To(BeEquivalentTo(metrics.InstanceStateStarting))
+	})
+
+	t.Run("it reports a pod in CrashLoopBackOff as crashed with zero container age", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		pod := newRunningPod()
+		pod.Status.ContainerStatuses[1].State = corev1.ContainerState{
+			Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"},
+		}
+		fakePodGetter := new(metricsfakes.FakePodGetter)
+		fakePodGetter.GetReturns(pod, nil)
+
+		gauges := readInstanceGauges(g, fakePodGetter)
+
+		g.Expect(gauges["state"].Value).To(BeEquivalentTo(metrics.InstanceStateCrashed))
+		g.Expect(gauges["container_age"].Value).To(BeZero())
+	})
+
+	t.Run("fails when there is an error fetching the pod", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		fakeDiskUsageFetcher := new(metricsfakes.FakeDiskUsageFetcher)
+		fakePodGetter := new(metricsfakes.FakePodGetter)
+		fakePodGetter.GetReturns(nil, errors.New("k8s problem"))
+		f := newFakeMetricsFetcher(corev1.ResourceList{})
+		stop, err := startGRPCServer(f.GetMetrics, fakeDiskUsageFetcher, fakePodGetter)
+		g.Expect(err).ToNot(HaveOccurred())
+		defer stop()
+
+		conn, err := grpc.Dial(":8080", grpc.WithInsecure())
+		g.Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		client := logcache_v1.NewEgressClient(conn)
+		_, err = client.Read(context.Background(), &logcache_v1.ReadRequest{
+			SourceId: "fake-source",
+		})
+		g.Expect(err).To(HaveOccurred())
 	})
 }
 
-func startGRPCServer(f metrics.MetricsFetcherFn, d metrics.DiskUsageFetcher) (stop func(), err error) {
-	logger := log.New(os.Stderr, "", log.LstdFlags)
-	c := metrics.NewProxy(logger, f, d)
+func TestMetricsProxyReconcilesPods(t *testing.T) {
+	read := func(g *GomegaWithT, l metrics.PodListerFn, p metrics.PodGetter) []*loggregator_v2.Envelope {
+		fakeDiskUsageFetcher := new(metricsfakes.FakeDiskUsageFetcher)
+		fakeDiskUsageFetcher.DiskUsageReturns(300, nil)
+		f := newFakeMetricsFetcher(corev1.ResourceList{
+			"cpu": *resource.NewScaledQuantity(420000000, resource.Nano),
+		})
+		stop, err := startGRPCServerWithPods(f.GetMetrics, l, fakeDiskUsageFetcher, p)
+		g.Expect(err).ToNot(HaveOccurred())
+		defer stop()
+
+		conn, err := grpc.Dial(":8080", grpc.WithInsecure())
+		g.Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		client := logcache_v1.NewEgressClient(conn)
+		resp, err := client.Read(context.Background(), &logcache_v1.ReadRequest{
+			SourceId: "fake-source",
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+
+		return resp.Envelopes.Batch
+	}
+
+	t.Run("it emits placeholder envelopes for pods without metrics", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		startingPod := newRunningPodNamed("test-app-1")
+		startingPod.Spec.Containers = []corev1.Container{{
+			Name: "test-app",
+			Resources: corev1.ResourceRequirements{
+				Limits: corev1.ResourceList{
+					corev1.ResourceMemory:           resource.MustParse("1Gi"),
+					corev1.ResourceEphemeralStorage: resource.MustParse("2Gi"),
+				},
+			},
+		}}
+		startingPod.Status.ContainerStatuses[1].State = corev1.ContainerState{
+			Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"},
+		}
+
+		envelopes := read(g, newPodLister(newRunningPod(), startingPod), newFakePodGetter())
+
+		g.Expect(envelopes).To(HaveLen(7))
+		placeholders := envelopes[3:]
+		for _, e := range placeholders {
+			g.Expect(e.SourceId).To(Equal("fake-source"))
+			g.Expect(e.InstanceId).To(Equal("1"))
+		}
+		g.Expect(placeholders[0].GetGauge().Metrics).To(BeEquivalentTo(map[string]*loggregator_v2.GaugeValue{
+			"cpu": {Unit: "percentage", Value: 0},
+		}))
+		g.Expect(placeholders[1].GetGauge().Metrics).To(BeEquivalentTo(map[string]*loggregator_v2.GaugeValue{
+			"memory":       {Unit: "bytes", Value: 0},
+			"memory_quota": {Unit: "bytes", Value: 1 << 30},
+			"memoryutil":   {Unit: "percentage", Value: 0},
+		}))
+		g.Expect(placeholders[2].GetGauge().Metrics).To(BeEquivalentTo(map[string]*loggregator_v2.GaugeValue{
+			"disk": {Unit: "bytes", Value: 0},
+		}))
+
+		instance := placeholders[3].GetGauge().Metrics
+		g.Expect(instance["state"].Value).To(BeEquivalentTo(metrics.InstanceStateStarting))
+		g.Expect(instance["disk_quota"]).To(Equal(&loggregator_v2.GaugeValue{Unit: "bytes", Value: 2 << 30}))
+	})
+
+	t.Run("it uses listed pods for instance status instead of fetching them", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		fakePodGetter := newFakePodGetter()
+		envelopes := read(g, newPodLister(newRunningPod()), fakePodGetter)
+
+		g.Expect(envelopes).To(HaveLen(3))
+		g.Expect(fakePodGetter.GetCallCount()).To(BeZero())
+	})
+
+	t.Run("it skips terminating pods without metrics", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		terminatingPod := newRunningPodNamed("test-app-1")
+		terminatingPod.DeletionTimestamp = &v1.Time{Time: time.Now()}
+
+		envelopes := read(g, newPodLister(terminatingPod), newFakePodGetter())
+
+		g.Expect(envelopes).To(HaveLen(3))
+		g.Expect(envelopes[0].InstanceId).To(Equal("0"))
+	})
+
+	t.Run("fails when there is an error listing pods", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		fakeDiskUsageFetcher := new(metricsfakes.FakeDiskUsageFetcher)
+		f := newFakeMetricsFetcher(corev1.ResourceList{})
+		l := func(string) ([]*corev1.Pod, error) {
+			return nil, errors.New("k8s problem")
+		}
+		stop, err := startGRPCServerWithPods(f.GetMetrics, l, fakeDiskUsageFetcher, newFakePodGetter())
+		g.Expect(err).ToNot(HaveOccurred())
+		defer stop()
+
+		conn, err := grpc.Dial(":8080", grpc.WithInsecure())
+		g.Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		client := logcache_v1.NewEgressClient(conn)
+		_, err = client.Read(context.Background(), &logcache_v1.ReadRequest{
+			SourceId: "fake-source",
+		})
+		g.Expect(err).To(HaveOccurred())
+	})
+}
+
+func TestMetricsProxyCPUTimeCounter(t *testing.T) {
+	// newProxy lists pod, so tests can change its status between reads.
+	newProxy := func(c metrics.CPUTimeFetcher, pod *corev1.Pod) *metrics.Proxy {
+		f := newFakeMetricsFetcher(corev1.ResourceList{})
+		// GetMetrics blocks once processGUID is full.
+		f.processGUID = make(chan string, 16)
+		logger := logging.New(ioutil.Discard, logging.Error)
+		podLister := func(string) ([]*corev1.Pod, error) {
+			return []*corev1.Pod{pod}, nil
+		}
+		return metrics.NewProxy(logger, nil, nil, f.GetMetrics, podLister, new(metricsfakes.FakeDiskUsageFetcher), newFakePodGetter(), metrics.ProxyOptions{CPUTimeFetcher: c})
+	}
+	restart := func(pod *corev1.Pod) {
+		pod.Status.ContainerStatuses[1].RestartCount++
+	}
+	readCounter := func(g *GomegaWithT, proxy *metrics.Proxy) *loggregator_v2.Counter {
+		resp, err := proxy.Read(context.Background(), &logcache_v1.ReadRequest{SourceId: "fake-source"})
+		g.Expect(err).ToNot(HaveOccurred())
+
+		var counters []*loggregator_v2.Envelope
+		for _, e := range resp.GetEnvelopes().GetBatch() {
+			if e.GetCounter() != nil {
+				counters = append(counters, e)
+			}
+		}
+		g.Expect(counters).To(HaveLen(1))
+		g.Expect(counters[0].GetInstanceId()).To(Equal("0"))
+		g.Expect(counters[0].GetTags()).To(HaveKeyWithValue("process_id", "fake-source"))
+		return counters[0].GetCounter()
+	}
+
+	t.Run("it counts cpu time by total only", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		fakeCPUTimeFetcher := new(metricsfakes.FakeCPUTimeFetcher)
+		fakeCPUTimeFetcher.CPUTimeReturnsOnCall(0, 100, nil)
+		fakeCPUTimeFetcher.CPUTimeReturnsOnCall(1, 250, nil)
+		proxy := newProxy(fakeCPUTimeFetcher, newRunningPod())
+
+		counter := readCounter(g, proxy)
+		g.Expect(counter.GetName()).To(Equal(metrics.CPUTimeCounter))
+		g.Expect(counter.GetTotal()).To(BeNumerically("==", 100))
+		g.Expect(counter.GetDelta()).To(BeZero())
+
+		counter = readCounter(g, proxy)
+		g.Expect(counter.GetTotal()).To(BeNumerically("==", 250))
+		g.Expect(counter.GetDelta()).To(BeZero())
+
+		_, pod := fakeCPUTimeFetcher.CPUTimeArgsForCall(0)
+		g.Expect(pod.Name).To(Equal("test-app-0"))
+	})
+
+	t.Run("it keeps counting up when the container restarts", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		fakeCPUTimeFetcher := new(metricsfakes.FakeCPUTimeFetcher)
+		fakeCPUTimeFetcher.CPUTimeReturnsOnCall(0, 1000, nil)
+		fakeCPUTimeFetcher.CPUTimeReturnsOnCall(1, 40, nil)
+		fakeCPUTimeFetcher.CPUTimeReturnsOnCall(2, 90, nil)
+		pod := newRunningPod()
+		proxy := newProxy(fakeCPUTimeFetcher, pod)
+
+		readCounter(g, proxy)
+
+		restart(pod)
+		g.Expect(readCounter(g, proxy).GetTotal()).To(BeNumerically("==", 1040))
+		g.Expect(readCounter(g, proxy).GetTotal()).To(BeNumerically("==", 1090))
+	})
+
+	t.Run("it counts a restart to a higher value", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		fakeCPUTimeFetcher := new(metricsfakes.FakeCPUTimeFetcher)
+		fakeCPUTimeFetcher.CPUTimeReturnsOnCall(0, 1000, nil)
+		fakeCPUTimeFetcher.CPUTimeReturnsOnCall(1, 1500, nil)
+		pod := newRunningPod()
+		proxy := newProxy(fakeCPUTimeFetcher, pod)
+
+		readCounter(g, proxy)
+
+		restart(pod)
+		g.Expect(readCounter(g, proxy).GetTotal()).To(BeNumerically("==", 2500))
+	})
+
+	t.Run("it counts a restart once when the cpu time drops before the restart count changes", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		fakeCPUTimeFetcher := new(metricsfakes.FakeCPUTimeFetcher)
+		fakeCPUTimeFetcher.CPUTimeReturnsOnCall(0, 1000, nil)
+		fakeCPUTimeFetcher.CPUTimeReturnsOnCall(1, 40, nil)
+		fakeCPUTimeFetcher.CPUTimeReturnsOnCall(2, 90, nil)
+		pod := newRunningPod()
+		proxy := newProxy(fakeCPUTimeFetcher, pod)
+
+		readCounter(g, proxy)
+
+		g.Expect(readCounter(g, proxy).GetTotal()).To(BeNumerically("==", 1040))
+		restart(pod)
+		g.Expect(readCounter(g, proxy).GetTotal()).To(BeNumerically("==", 1090))
+	})
+
+	t.Run("it waits for a cached cpu time to catch up with a restart", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		fakeCPUTimeFetcher := new(metricsfakes.FakeCPUTimeFetcher)
+		fakeCPUTimeFetcher.CPUTimeReturnsOnCall(0, 1000, nil)
+		fakeCPUTimeFetcher.CPUTimeReturnsOnCall(1, 1000, nil)
+		fakeCPUTimeFetcher.CPUTimeReturnsOnCall(2, 200, nil)
+		pod := newRunningPod()
+		proxy := newProxy(fakeCPUTimeFetcher, pod)
+
+		readCounter(g, proxy)
+
+		restart(pod)
+		g.Expect(readCounter(g, proxy).GetTotal()).To(BeNumerically("==", 1000))
+		g.Expect(readCounter(g, proxy).GetTotal()).To(BeNumerically("==", 1200))
+	})
+
+	t.Run("it omits the counter when cpu time can't be fetched", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		fakeCPUTimeFetcher := new(metricsfakes.FakeCPUTimeFetcher)
+		fakeCPUTimeFetcher.CPUTimeReturns(0, errors.New("k8s problem"))
+		proxy := newProxy(fakeCPUTimeFetcher, newRunningPod())
+
+		resp, err := proxy.Read(context.Background(), &logcache_v1.ReadRequest{SourceId: "fake-source"})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(resp.GetEnvelopes().GetBatch()).ToNot(BeEmpty())
+		for _, e := range resp.GetEnvelopes().GetBatch() {
+			g.Expect(e.GetCounter()).To(BeNil())
+		}
+	})
+}
+
+func TestMetricsProxyDiskIOCounters(t *testing.T) {
+	newProxy := func(d metrics.DiskIOFetcher, pod *corev1.Pod) *metrics.Proxy {
+		f := newFakeMetricsFetcher(corev1.ResourceList{})
+		// GetMetrics blocks once processGUID is full.
+		f.processGUID = make(chan string, 16)
+		logger := logging.New(ioutil.Discard, logging.Error)
+		return metrics.NewProxy(logger, nil, nil, f.GetMetrics, newPodLister(pod), new(metricsfakes.FakeDiskUsageFetcher), newFakePodGetter(), metrics.ProxyOptions{DiskIOFetcher: d})
+	}
+	readCounters := func(g *GomegaWithT, proxy *metrics.Proxy) map[string]uint64 {
+		resp, err := proxy.Read(context.Background(), &logcache_v1.ReadRequest{SourceId: "fake-source"})
+		g.Expect(err).ToNot(HaveOccurred())
+
+		totals := map[string]uint64{}
+		for _, e := range resp.GetEnvelopes().GetBatch() {
+			if c := e.GetCounter(); c != nil {
+				g.Expect(e.GetInstanceId()).To(Equal("0"))
+				totals[c.GetName()] = c.GetTotal()
+			}
+		}
+		return totals
+	}
+
+	t.Run("it counts bytes read and written across restarts", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		fakeDiskIOFetcher := new(metricsfakes.FakeDiskIOFetcher)
+		fakeDiskIOFetcher.DiskIOReturnsOnCall(0, 4096, 1024, nil)
+		fakeDiskIOFetcher.DiskIOReturnsOnCall(1, 8192, 256, nil)
+		pod := newRunningPod()
+		proxy := newProxy(fakeDiskIOFetcher, pod)
+
+		g.Expect(readCounters(g, proxy)).To(Equal(map[string]uint64{
+			metrics.DiskReadBytesCounter:  4096,
+			metrics.DiskWriteBytesCounter: 1024,
+		}))
+
+		pod.Status.ContainerStatuses[1].RestartCount++
+		g.Expect(readCounters(g, proxy)).To(Equal(map[string]uint64{
+			metrics.DiskReadBytesCounter:  12288,
+			metrics.DiskWriteBytesCounter: 1280,
+		}))
+	})
+
+	t.Run("it omits the counters when disk io can't be fetched", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		fakeDiskIOFetcher := new(metricsfakes.FakeDiskIOFetcher)
+		fakeDiskIOFetcher.DiskIOReturns(0, 0, errors.New("k8s problem"))
+		proxy := newProxy(fakeDiskIOFetcher, newRunningPod())
+
+		g.Expect(readCounters(g, proxy)).To(BeEmpty())
+	})
+}
+
+func TestMetricsProxyLogging(t *testing.T) {
+	t.Run("it logs with the request ID and source ID", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		var logs bytes.Buffer
+		logger := logging.New(&logs, logging.Debug)
+
+		fakeDiskUsageFetcher := new(metricsfakes.FakeDiskUsageFetcher)
+		fakeDiskUsageFetcher.DiskUsageReturns(0, errors.New("k8s problem"))
+		f := newFakeMetricsFetcher(corev1.ResourceList{})
+		c := metrics.NewProxy(logger, nil, nil, f.GetMetrics, newPodLister(), fakeDiskUsageFetcher, newFakePodGetter(), metrics.ProxyOptions{})
+
+		s := grpc.NewServer(grpc.UnaryInterceptor(logging.UnaryServerInterceptor(logger)))
+		logcache_v1.RegisterEgressServer(s, c)
+		lis, err := net.Listen("tcp", ":8080")
+		g.Expect(err).ToNot(HaveOccurred())
+		go s.Serve(lis)
+		defer s.Stop()
+
+		conn, err := grpc.Dial(":8080", grpc.WithInsecure())
+		g.Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		client := logcache_v1.NewEgressClient(conn)
+		ctx := metadata.AppendToOutgoingContext(context.Background(), logging.RequestIDMetadataKey, "some-request-id")
+		_, err = client.Read(ctx, &logcache_v1.ReadRequest{
+			SourceId: "fake-source",
+		})
+		g.Expect(err).To(HaveOccurred())
+
+		var line map[string]interface{}
+		g.Expect(json.Unmarshal(logs.Bytes(), &line)).To(Succeed())
+		g.Expect(line).To(HaveKeyWithValue("level", "error"))
+		g.Expect(line).To(HaveKeyWithValue("message", "error fetching disk usage"))
+		g.Expect(line).To(HaveKeyWithValue("request_id", "some-request-id"))
+		g.Expect(line).To(HaveKeyWithValue("source_id", "fake-source"))
+		g.Expect(line).To(HaveKeyWithValue("pod", "test-app-0"))
+		g.Expect(line).To(HaveKeyWithValue("error", "k8s problem"))
+
+		g.Expect(fakeDiskUsageFetcher.DiskUsageCallCount()).To(Equal(1))
+		diskCtx, _ := fakeDiskUsageFetcher.DiskUsageArgsForCall(0)
+		logging.FromContext(diskCtx, nil).Info("from the disk usage fetcher")
+		g.Expect(logs.String()).To(ContainSubstring(`"source_id":"fake-source"`))
+	})
+}
+
+func TestMetricsProxySelfMetrics(t *testing.T) {
+	t.Run("it records envelopes, pods without metrics and upstream calls", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		registry := prometheus.NewRegistry()
+		selfMetrics := selfmetrics.New(registry, prometheus.DefBuckets)
+
+		fakeDiskUsageFetcher := new(metricsfakes.FakeDiskUsageFetcher)
+		f := newFakeMetricsFetcher(corev1.ResourceList{
+			"cpu": *resource.NewScaledQuantity(420000000, resource.Nano),
+		})
+		l := newPodLister(newRunningPodNamed("test-app-1"))
+		stop, err := startGRPCServerWithSelfMetrics(selfMetrics, f.GetMetrics, l, fakeDiskUsageFetcher, newFakePodGetter())
+		g.Expect(err).ToNot(HaveOccurred())
+		defer stop()
+
+		conn, err := grpc.Dial(":8080", grpc.WithInsecure())
+		g.Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		client := logcache_v1.NewEgressClient(conn)
+		_, err = client.Read(context.Background(), &logcache_v1.ReadRequest{
+			SourceId: "fake-source",
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+
+		g.Expect(testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP pods_without_metrics_total Pods reported with placeholder envelopes because metrics-server had no metrics for them
+# TYPE pods_without_metrics_total counter
+pods_without_metrics_total 1
+`), "pods_without_metrics_total")).To(Succeed())
+
+		families, err := registry.Gather()
+		g.Expect(err).ToNot(HaveOccurred())
+		counts := map[string]uint64{}
+		for _, family := range families {
+			for _, metric := range family.GetMetric() {
+				if h := metric.GetHistogram(); h != nil {
+					name := family.GetName()
+					for _, label := range metric.GetLabel() {
+						name += "/" + label.GetValue()
+					}
+					counts[name] = h.GetSampleCount()
+				}
+			}
+		}
+		g.Expect(counts).To(HaveKeyWithValue("envelopes_per_request", uint64(1)))
+		g.Expect(counts).To(HaveKeyWithValue("upstream_request_duration_seconds/metrics-api", uint64(1)))
+		g.Expect(counts).To(HaveKeyWithValue("upstream_request_duration_seconds/pods", uint64(1)))
+	})
+}
+
+func startGRPCServer(f metrics.MetricsFetcherFn, d metrics.DiskUsageFetcher, p metrics.PodGetter) (stop func(), err error) {
+	return startGRPCServerWithPods(f, newPodLister(), d, p)
+}
+
+func startGRPCServerWithPods(f metrics.MetricsFetcherFn, l metrics.PodListerFn, d metrics.DiskUsageFetcher, p metrics.PodGetter) (stop func(), err error) {
+	return startGRPCServerWithSelfMetrics(nil, f, l, d, p)
+}
+
+func startGRPCServerWithSelfMetrics(m *selfmetrics.Metrics, f metrics.MetricsFetcherFn, l metrics.PodListerFn, d metrics.DiskUsageFetcher, p metrics.PodGetter) (stop func(), err error) {
+	logger := logging.New(os.Stderr, logging.Debug)
+	c := metrics.NewProxy(logger, m, nil, f, l, d, p, metrics.ProxyOptions{})
 
 	s := grpc.NewServer()
 	logcache_v1.RegisterEgressServer(s, c)
@@ -447,6 +967,56 @@ func startGRPCServer(f metrics.MetricsFetcherFn, d metrics.DiskUsageFetcher) (st
 	return s.GracefulStop, nil
 }
 
+func newPodLister(pods ...*corev1.Pod) metrics.PodListerFn {
+	return func(string) ([]*corev1.Pod, error) {
+		return pods, nil
+	}
+}
+
+func newFakePodGetter() *metricsfakes.FakePodGetter {
+	fakePodGetter := new(metricsfakes.FakePodGetter)
+	fakePodGetter.GetStub = func(_ context.Context, podName string) (*corev1.Pod, error) {
+		return newRunningPodNamed(podName), nil
+	}
+	return fakePodGetter
+}
+
+func newRunningPod() *corev1.Pod {
+	return newRunningPodNamed("test-app-0")
+}
+
+func newRunningPodNamed(name string) *corev1.Pod {
+	now := time.Now()
+	return &corev1.Pod{
+		ObjectMeta: v1.ObjectMeta{
+			Name:              name,
+			CreationTimestamp: v1.NewTime(now.Add(-2 * time.Hour)),
+		},
+		Status: corev1.PodStatus{
+			Phase:     corev1.PodRunning,
+			StartTime: &v1.Time{Time: now.Add(-time.Hour)},
+			ContainerStatuses: []corev1.ContainerStatus{
+				{
+					Name:         "istio-proxy",
+					Ready:        true,
+					RestartCount: 7,
+					State: corev1.ContainerState{
+						Running: &corev1.ContainerStateRunning{StartedAt: v1.NewTime(now.Add(-time.Hour))},
+					},
+				},
+				{
+					Name:         "test-app",
+					Ready:        true,
+					RestartCount: 2,
+					State: corev1.ContainerState{
+						Running: &corev1.ContainerStateRunning{StartedAt: v1.NewTime(now.Add(-time.Minute))},
+					},
+				},
+			},
+		},
+	}
+}
+
 type fakeMetricsFetcher struct {
 	appCount    int
 	processGUID chan string
@@ -463,7 +1033,7 @@ func newFakeMetricsFetcher(resources corev1.ResourceList) *fakeMetricsFetcher {
 	}
 }
 
-func (f *fakeMetricsFetcher) GetMetrics(processGUID string) (*v1beta1.PodMetricsList, error) {
+func (f *fakeMetricsFetcher) GetMetrics(_ context.Context, processGUID string) (*v1beta1.PodMetricsList, error) {
 	f.processGUID <- processGUID
 	return &v1beta1.PodMetricsList{
 		TypeMeta: v1.TypeMeta{},
@@ -489,7 +1059,7 @@ func (f *fakeMetricsFetcher) podMetrics() []v1beta1.PodMetrics {
 }
 
 func newErrorFetcher(s string) metrics.MetricsFetcherFn {
-	return func(_ string) (*v1beta1.PodMetricsList, error) {
+	return func(context.Context, string) (*v1beta1.PodMetricsList, error) {
 		return nil, fmt.Errorf(s)
 	}
 }
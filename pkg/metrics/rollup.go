@@ -0,0 +1,138 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+)
+
+// RollupSourceIDSuffix opts a Read into app-level rollups: reading
+// "<guid>:rollup" returns guid's per-instance envelopes followed by rollup
+// envelopes.
+const RollupSourceIDSuffix = ":rollup"
+
+// RollupTag holds the statistic of a rollup envelope: "sum", "avg" or "max".
+// Per-instance envelopes never carry it.
+const RollupTag = "rollup"
+
+var rollupStatistics = []string{"sum", "avg", "max"}
+
+type gaugeSamples struct {
+	unit   string
+	values []float64
+}
+
+// rollupGroup holds the gauges of the envelopes sharing one set of tags.
+type rollupGroup struct {
+	tags    map[string]string
+	samples map[string]*gaugeSamples
+}
+
+// createRollupEnvelopes returns, for each set of tags among the instance
+// envelopes, one envelope per statistic holding that statistic of every
+// gauge with those tags across instances. Gauges are only rolled up with the
+// same-named gauges of the same series, so scraped series that differ by
+// label stay apart. The "state" gauge is an enum and isn't rolled up.
+func createRollupEnvelopes(sourceID string, envelopes []*loggregator_v2.Envelope) []*loggregator_v2.Envelope {
+	groups := map[string]*rollupGroup{}
+	for _, e := range envelopes {
+		for name, gauge := range e.GetGauge().GetMetrics() {
+			if name == "state" {
+				continue
+			}
+			key := seriesKey(e.GetTags())
+			group, ok := groups[key]
+			if !ok {
+				group = &rollupGroup{tags: e.GetTags(), samples: map[string]*gaugeSamples{}}
+				groups[key] = group
+			}
+			s, ok := group.samples[name]
+			if !ok {
+				s = &gaugeSamples{unit: gauge.GetUnit()}
+				group.samples[name] = s
+			}
+			s.values = append(s.values, gauge.GetValue())
+		}
+	}
+	if len(groups) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	timestamp := time.Now().UnixNano()
+	rollups := make([]*loggregator_v2.Envelope, 0, len(keys)*len(rollupStatistics))
+	for _, key := range keys {
+		group := groups[key]
+		for _, statistic := range rollupStatistics {
+			gauges := make(map[string]*loggregator_v2.GaugeValue, len(group.samples))
+			for name, s := range group.samples {
+				gauges[name] = &loggregator_v2.GaugeValue{
+					Unit:  s.unit,
+					Value: rollup(statistic, s.values),
+				}
+			}
+
+			tags := make(map[string]string, len(group.tags)+2)
+			for k, v := range group.tags {
+				tags[k] = v
+			}
+			tags["process_id"] = sourceID
+			tags[RollupTag] = statistic
+
+			rollups = append(rollups, &loggregator_v2.Envelope{
+				Timestamp: timestamp,
+				SourceId:  sourceID,
+				Tags:      tags,
+				Message: &loggregator_v2.Envelope_Gauge{
+					Gauge: &loggregator_v2.Gauge{
+						Metrics: gauges,
+					},
+				},
+			})
+		}
+	}
+
+	return rollups
+}
+
+// seriesKey identifies a set of tags independently of map order.
+func seriesKey(tags map[string]string) string {
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&b, "%q=%q,", name, tags[name])
+	}
+	return b.String()
+}
+
+func rollup(statistic string, values []float64) float64 {
+	var sum, max float64
+	for i, v := range values {
+		sum += v
+		if i == 0 || v > max {
+			max = v
+		}
+	}
+
+	switch statistic {
+	case "avg":
+		return sum / float64(len(values))
+	case "max":
+		return max
+	default:
+		return sum
+	}
+}
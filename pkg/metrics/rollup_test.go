@@ -0,0 +1,91 @@
+package metrics_test
+
+import (
+	"context"
+	"testing"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/log-cache/pkg/rpc/logcache_v1"
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics"
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics/metricsfakes"
+	. "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestMetricsProxyRollups(t *testing.T) {
+	read := func(g *GomegaWithT, sourceID string) []*loggregator_v2.Envelope {
+		fakeDiskUsageFetcher := new(metricsfakes.FakeDiskUsageFetcher)
+		fakeDiskUsageFetcher.DiskUsageStub = func(_ context.Context, podName string) (int64, error) {
+			return map[string]int64{"test-app-0": 100, "test-app-1": 300}[podName], nil
+		}
+		f := newFakeMetricsFetcher(corev1.ResourceList{
+			"cpu": *resource.NewScaledQuantity(420000000, resource.Nano),
+		})
+		f.appCount = 2
+		stop, err := startGRPCServer(f.GetMetrics, fakeDiskUsageFetcher, newFakePodGetter())
+		g.Expect(err).ToNot(HaveOccurred())
+		defer stop()
+
+		conn, err := grpc.Dial(":8080", grpc.WithInsecure())
+		g.Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+
+		client := logcache_v1.NewEgressClient(conn)
+		resp, err := client.Read(context.Background(), &logcache_v1.ReadRequest{
+			SourceId: sourceID,
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(<-f.processGUID).To(Equal("fake-source"))
+
+		return resp.Envelopes.Batch
+	}
+
+	rollups := func(envelopes []*loggregator_v2.Envelope) map[string]*loggregator_v2.Envelope {
+		byStatistic := map[string]*loggregator_v2.Envelope{}
+		for _, e := range envelopes {
+			if statistic, ok := e.Tags[metrics.RollupTag]; ok {
+				byStatistic[statistic] = e
+			}
+		}
+		return byStatistic
+	}
+
+	t.Run("it appends sum, avg and max envelopes with the rollup suffix", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		envelopes := read(g, "fake-source"+metrics.RollupSourceIDSuffix)
+
+		// cpu, disk and instance envelopes for two pods, then three rollups
+		g.Expect(envelopes).To(HaveLen(9))
+		for _, e := range envelopes {
+			g.Expect(e.SourceId).To(Equal("fake-source"))
+		}
+
+		byStatistic := rollups(envelopes)
+		g.Expect(byStatistic).To(HaveLen(3))
+
+		sum, avg, max := byStatistic["sum"], byStatistic["avg"], byStatistic["max"]
+		g.Expect(sum.InstanceId).To(BeEmpty())
+		g.Expect(sum.Tags).To(HaveKeyWithValue("process_id", "fake-source"))
+
+		g.Expect(sum.GetGauge().GetMetrics()["disk"]).To(Equal(&loggregator_v2.GaugeValue{Unit: "bytes", Value: 400}))
+		g.Expect(avg.GetGauge().GetMetrics()["disk"].Value).To(Equal(200.0))
+		g.Expect(max.GetGauge().GetMetrics()["disk"].Value).To(Equal(300.0))
+
+		g.Expect(sum.GetGauge().GetMetrics()["cpu"].Value).To(BeNumerically("~", 84))
+		g.Expect(avg.GetGauge().GetMetrics()["cpu"].Value).To(BeNumerically("~", 42))
+		g.Expect(max.GetGauge().GetMetrics()["restart_count"].Value).To(Equal(2.0))
+		g.Expect(sum.GetGauge().GetMetrics()).ToNot(HaveKey("state"))
+	})
+
+	t.Run("it returns no rollups without the suffix", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		envelopes := read(g, "fake-source")
+
+		g.Expect(envelopes).To(HaveLen(6))
+		g.Expect(rollups(envelopes)).To(BeEmpty())
+	})
+}
@@ -0,0 +1,33 @@
+package metrics
+
+import (
+	"context"
+	"time"
+)
+
+// RunRounds calls round every interval until stop is closed. Each round's
+// context is done after the interval, so a round must finish within it, or
+// as soon as stop is closed.
+func RunRounds(interval time.Duration, stop <-chan struct{}, round func(ctx context.Context)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	for {
+		roundCtx, roundCancel := context.WithTimeout(ctx, interval)
+		round(roundCtx)
+		roundCancel()
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
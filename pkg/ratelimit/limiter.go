@@ -0,0 +1,373 @@
+// Package ratelimit throttles gRPC calls and HTTP requests per client and
+// per source ID with token buckets.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// RetryAfterMetadataKey is the response header carrying the number of
+// seconds a throttled client should wait.
+const RetryAfterMetadataKey = "retry-after"
+
+// DefaultIdleTimeout is used when Config.IdleTimeout is zero.
+const DefaultIdleTimeout = 10 * time.Minute
+
+const healthServicePrefix = "/grpc.health.v1.Health/"
+
+// Limit is a token bucket refilling at Rate tokens per second up to Burst. A
+// zero Rate disables the limit.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Config sets the per-client and per-source-ID limits. Idle buckets are
+// forgotten after IdleTimeout.
+type Config struct {
+	Client      Limit
+	Source      Limit
+	IdleTimeout time.Duration
+}
+
+type Limiter struct {
+	clients  *keyedLimiter
+	sources  *keyedLimiter
+	rejected *prometheus.CounterVec
+	keys     *prometheus.GaugeVec
+}
+
+func New(cfg Config, registerer prometheus.Registerer) *Limiter {
+	if cfg.IdleTimeout == 0 {
+		cfg.IdleTimeout = DefaultIdleTimeout
+	}
+
+	l := &Limiter{
+		clients: newKeyedLimiter(cfg.Client, cfg.IdleTimeout),
+		sources: newKeyedLimiter(cfg.Source, cfg.IdleTimeout),
+		rejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rate_limited_requests_total",
+			Help: "Requests rejected with ResourceExhausted by the rate limiter",
+		}, []string{"limit"}),
+		keys: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "rate_limiter_keys",
+			Help: "Clients or source IDs currently tracked by the rate limiter",
+		}, []string{"limit"}),
+	}
+	registerer.MustRegister(l.rejected, l.keys)
+
+	return l
+}
+
+// SetLimits changes the client and source limits, including for buckets
+// that already exist.
+func (l *Limiter) SetLimits(client, source Limit) {
+	l.clients.setLimit(client)
+	l.sources.setLimit(source)
+}
+
+// UnaryServerInterceptor rejects calls that exceed either limit with
+// codes.ResourceExhausted, a RetryInfo detail and a retry-after header.
+// Calls naming several source IDs take a token from each of them, and
+// sourceIDs, which may be nil, finds the source IDs of requests that don't
+// name them, like reads of a whole space. Health checks are never limited.
+func (l *Limiter) UnaryServerInterceptor(sourceIDs func(req interface{}) []string) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if strings.HasPrefix(info.FullMethod, healthServicePrefix) {
+			return handler(ctx, req)
+		}
+
+		var ids []string
+		switch r := req.(type) {
+		case interface{ GetSourceId() string }:
+			ids = []string{r.GetSourceId()}
+		case interface{ GetSourceIds() []string }:
+			ids = r.GetSourceIds()
+		default:
+			if sourceIDs != nil {
+				ids = sourceIDs(req)
+			}
+		}
+
+		if limit, delay := l.allow(ClientIdentity(ctx), ids, time.Now()); delay > 0 {
+			return nil, l.reject(ctx, limit, delay)
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// HTTPMiddleware applies the same limits to HTTP requests, identifying
+// clients like ClientIdentity and taking a source token for each ID
+// sourceIDs finds in the request. sourceIDs may be nil. Throttled requests
+// get a 429 with a Retry-After header.
+func (l *Limiter) HTTPMiddleware(sourceIDs func(*http.Request) []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var ids []string
+			if sourceIDs != nil {
+				ids = sourceIDs(r)
+			}
+
+			if limit, delay := l.allow(httpClientIdentity(r), ids, time.Now()); delay > 0 {
+				l.rejected.WithLabelValues(limit).Inc()
+				w.Header().Set("Retry-After", fmt.Sprint(retryAfter(delay)))
+				http.Error(w, rejectMessage(limit, delay), http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// allow takes a token from the client's bucket and from the bucket of each
+// distinct source ID. If any of them is empty, none are taken, and allow
+// returns the limit that was exceeded and how long to wait.
+func (l *Limiter) allow(client string, sourceIDs []string, now time.Time) (string, time.Duration) {
+	clientReservation := l.clients.reserve(client, now)
+	if delay := clientReservation.DelayFrom(now); delay > 0 {
+		clientReservation.CancelAt(now)
+		return "client", delay
+	}
+
+	var (
+		reservations []*rate.Reservation
+		seen         = make(map[string]bool, len(sourceIDs))
+	)
+	for _, id := range sourceIDs {
+		id = strings.TrimSpace(id)
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		r := l.sources.reserve(id, now)
+		if delay := r.DelayFrom(now); delay > 0 {
+			r.CancelAt(now)
+			for _, taken := range reservations {
+				taken.CancelAt(now)
+			}
+			clientReservation.CancelAt(now)
+			return "source", delay
+		}
+		reservations = append(reservations, r)
+	}
+
+	l.keys.WithLabelValues("client").Set(float64(l.clients.len()))
+	l.keys.WithLabelValues("source").Set(float64(l.sources.len()))
+
+	return "", 0
+}
+
+func (l *Limiter) reject(ctx context.Context, limit string, delay time.Duration) error {
+	l.rejected.WithLabelValues(limit).Inc()
+
+	_ = grpc.SetHeader(ctx, metadata.Pairs(RetryAfterMetadataKey, fmt.Sprint(retryAfter(delay))))
+
+	st := status.New(codes.ResourceExhausted, rejectMessage(limit, delay))
+	if withDetails, err := st.WithDetails(&errdetails.RetryInfo{
+		RetryDelay: ptypes.DurationProto(delay),
+	}); err == nil {
+		st = withDetails
+	}
+
+	return st.Err()
+}
+
+func retryAfter(delay time.Duration) int {
+	return int(math.Ceil(delay.Seconds()))
+}
+
+func rejectMessage(limit string, delay time.Duration) string {
+	return fmt.Sprintf("%s rate limit exceeded, retry in %s", limit, delay.Round(time.Millisecond))
+}
+
+// ForwardedClientCertHeader is set by Envoy, including Istio's sidecar, to
+// describe the client certificate of the mutual TLS connection it
+// terminated.
+const ForwardedClientCertHeader = "x-forwarded-client-cert"
+
+// ClientIdentity identifies the caller by the common name of its verified
+// TLS client certificate, then by the URI SAN (an Istio workload's SPIFFE
+// ID) its sidecar forwarded in x-forwarded-client-cert, and otherwise by its
+// peer IP address. Behind a sidecar every caller shares the sidecar's peer
+// IP, so without the forwarded certificate they would share one bucket.
+// Anything the client sends unverified, like a bearer token, would let it
+// pick a fresh key for every call.
+func ClientIdentity(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "unknown"
+	}
+
+	if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+		if chains := tlsInfo.State.VerifiedChains; len(chains) > 0 && len(chains[0]) > 0 && chains[0][0].Subject.CommonName != "" {
+			return "cn:" + chains[0][0].Subject.CommonName
+		}
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(ForwardedClientCertHeader); len(values) > 0 {
+		if uri := forwardedClientURI(values[len(values)-1]); uri != "" {
+			return "uri:" + uri
+		}
+	}
+
+	if p.Addr == nil {
+		return "unknown"
+	}
+	if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+		return "ip:" + host
+	}
+	return "ip:" + p.Addr.String()
+}
+
+func httpClientIdentity(r *http.Request) string {
+	if r.TLS != nil {
+		if chains := r.TLS.VerifiedChains; len(chains) > 0 && len(chains[0]) > 0 && chains[0][0].Subject.CommonName != "" {
+			return "cn:" + chains[0][0].Subject.CommonName
+		}
+	}
+
+	if values := r.Header[http.CanonicalHeaderKey(ForwardedClientCertHeader)]; len(values) > 0 {
+		if uri := forwardedClientURI(values[len(values)-1]); uri != "" {
+			return "uri:" + uri
+		}
+	}
+
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return "ip:" + host
+	}
+	return "ip:" + r.RemoteAddr
+}
+
+// forwardedClientURI returns the URI field of the last element of an
+// x-forwarded-client-cert header, which the nearest proxy appended.
+// Elements are separated by commas and fields by semicolons, and quoted
+// values may contain either.
+func forwardedClientURI(header string) string {
+	elements := splitUnquoted(header, ',')
+	for _, field := range splitUnquoted(elements[len(elements)-1], ';') {
+		key, value := field, ""
+		if i := strings.IndexByte(field, '='); i >= 0 {
+			key, value = field[:i], field[i+1:]
+		}
+		if strings.EqualFold(strings.TrimSpace(key), "URI") {
+			return strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return ""
+}
+
+func splitUnquoted(s string, sep byte) []string {
+	var (
+		parts  []string
+		quoted bool
+		start  int
+	)
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+type keyedLimiter struct {
+	limit       Limit
+	idleTimeout time.Duration
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastPrune time.Time
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newKeyedLimiter(limit Limit, idleTimeout time.Duration) *keyedLimiter {
+	return &keyedLimiter{
+		limit:       limit,
+		idleTimeout: idleTimeout,
+		buckets:     map[string]*bucket{},
+	}
+}
+
+func (k *keyedLimiter) reserve(key string, now time.Time) *rate.Reservation {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.limit.Rate <= 0 {
+		return rate.NewLimiter(rate.Inf, 0).ReserveN(now, 1)
+	}
+
+	if now.Sub(k.lastPrune) > k.idleTimeout {
+		for key, b := range k.buckets {
+			if now.Sub(b.lastSeen) > k.idleTimeout {
+				delete(k.buckets, key)
+			}
+		}
+		k.lastPrune = now
+	}
+
+	b, ok := k.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(k.limit.Rate), k.limit.Burst)}
+		k.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	return b.limiter.ReserveN(now, 1)
+}
+
+func (k *keyedLimiter) setLimit(limit Limit) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.limit = limit
+	for _, b := range k.buckets {
+		b.limiter.SetLimit(rate.Limit(limit.Rate))
+		b.limiter.SetBurst(limit.Burst)
+	}
+}
+
+func (k *keyedLimiter) len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return len(k.buckets)
+}
@@ -0,0 +1,332 @@
+package ratelimit_test
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/log-cache/pkg/rpc/logcache_v1"
+	"code.cloudfoundry.org/metric-proxy/pkg/ratelimit"
+	"code.cloudfoundry.org/metric-proxy/pkg/rpc/metricproxy_v1"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	readInfo := &grpc.UnaryServerInfo{FullMethod: "/logcache.v1.Egress/Read"}
+	ok := func(context.Context, interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	fromIP := func(ip string) context.Context {
+		return peer.NewContext(context.Background(), &peer.Peer{
+			Addr: &net.TCPAddr{IP: net.ParseIP(ip), Port: 51234},
+		})
+	}
+
+	t.Run("it rejects clients over their limit with retry hints", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		registry := prometheus.NewRegistry()
+		interceptor := ratelimit.New(ratelimit.Config{
+			Client: ratelimit.Limit{Rate: 0.5, Burst: 1},
+		}, registry).UnaryServerInterceptor(nil)
+
+		_, err := interceptor(fromIP("10.0.0.1"), &logcache_v1.ReadRequest{SourceId: "app-1"}, readInfo, ok)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		_, err = interceptor(fromIP("10.0.0.1"), &logcache_v1.ReadRequest{SourceId: "app-2"}, readInfo, ok)
+		st := status.Convert(err)
+		g.Expect(st.Code()).To(Equal(codes.ResourceExhausted))
+		g.Expect(st.Message()).To(ContainSubstring("client rate limit exceeded"))
+		g.Expect(st.Details()).To(HaveLen(1))
+		retryInfo, isRetryInfo := st.Details()[0].(*errdetails.RetryInfo)
+		g.Expect(isRetryInfo).To(BeTrue())
+		g.Expect(retryInfo.GetRetryDelay().GetSeconds()).To(BeNumerically("~", 2, 1))
+
+		_, err = interceptor(fromIP("10.0.0.2"), &logcache_v1.ReadRequest{SourceId: "app-3"}, readInfo, ok)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		g.Expect(testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP rate_limited_requests_total Requests rejected with ResourceExhausted by the rate limiter
+# TYPE rate_limited_requests_total counter
+rate_limited_requests_total{limit="client"} 1
+`), "rate_limited_requests_total")).To(Succeed())
+	})
+
+	t.Run("it limits a source ID across clients", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		registry := prometheus.NewRegistry()
+		interceptor := ratelimit.New(ratelimit.Config{
+			Client: ratelimit.Limit{Rate: 0.5, Burst: 1},
+			Source: ratelimit.Limit{Rate: 0.5, Burst: 1},
+		}, registry).UnaryServerInterceptor(nil)
+
+		_, err := interceptor(fromIP("10.0.0.1"), &logcache_v1.ReadRequest{SourceId: "app-1"}, readInfo, ok)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		_, err = interceptor(fromIP("10.0.0.2"), &logcache_v1.ReadRequest{SourceId: "app-1"}, readInfo, ok)
+		g.Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+		g.Expect(err.Error()).To(ContainSubstring("source rate limit exceeded"))
+
+		// The rejected call must not have used up 10.0.0.2's client token.
+		_, err = interceptor(fromIP("10.0.0.2"), &logcache_v1.ReadRequest{SourceId: "app-2"}, readInfo, ok)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		g.Expect(testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP rate_limiter_keys Clients or source IDs currently tracked by the rate limiter
+# TYPE rate_limiter_keys gauge
+rate_limiter_keys{limit="client"} 2
+rate_limiter_keys{limit="source"} 2
+`), "rate_limiter_keys")).To(Succeed())
+	})
+
+	t.Run("it takes a source token for each source ID of a batch read", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		interceptor := ratelimit.New(ratelimit.Config{
+			Source: ratelimit.Limit{Rate: 0.5, Burst: 1},
+		}, prometheus.NewRegistry()).UnaryServerInterceptor(nil)
+		batchInfo := &grpc.UnaryServerInfo{FullMethod: "/metricproxy.v1.Egress/BatchRead"}
+
+		_, err := interceptor(fromIP("10.0.0.1"), &metricproxy_v1.BatchReadRequest{SourceIds: []string{"app-1", "app-2", "app-1"}}, batchInfo, ok)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		_, err = interceptor(fromIP("10.0.0.1"), &metricproxy_v1.BatchReadRequest{SourceIds: []string{"app-3", "app-2"}}, batchInfo, ok)
+		g.Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+		g.Expect(err.Error()).To(ContainSubstring("source rate limit exceeded"))
+
+		// The rejected batch must not have used up app-3's token.
+		_, err = interceptor(fromIP("10.0.0.1"), &logcache_v1.ReadRequest{SourceId: "app-3"}, readInfo, ok)
+		g.Expect(err).ToNot(HaveOccurred())
+	})
+
+	t.Run("it takes a source token for each source ID found for other requests", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		interceptor := ratelimit.New(ratelimit.Config{
+			Source: ratelimit.Limit{Rate: 0.5, Burst: 1},
+		}, prometheus.NewRegistry()).UnaryServerInterceptor(func(req interface{}) []string {
+			if r, ok := req.(*metricproxy_v1.AggregateReadRequest); ok && r.GetSpaceGuid() == "space-1" {
+				return []string{"app-1", "app-2"}
+			}
+			return nil
+		})
+		aggregateInfo := &grpc.UnaryServerInfo{FullMethod: "/metricproxy.v1.Aggregator/AggregateRead"}
+
+		_, err := interceptor(fromIP("10.0.0.1"), &metricproxy_v1.AggregateReadRequest{SpaceGuid: "space-1"}, aggregateInfo, ok)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		_, err = interceptor(fromIP("10.0.0.2"), &logcache_v1.ReadRequest{SourceId: "app-2"}, readInfo, ok)
+		g.Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+	})
+
+	t.Run("it never limits health checks", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		interceptor := ratelimit.New(ratelimit.Config{
+			Client: ratelimit.Limit{Rate: 0.5, Burst: 1},
+		}, prometheus.NewRegistry()).UnaryServerInterceptor(nil)
+		healthInfo := &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}
+
+		for i := 0; i < 3; i++ {
+			_, err := interceptor(fromIP("10.0.0.1"), nil, healthInfo, ok)
+			g.Expect(err).ToNot(HaveOccurred())
+		}
+	})
+
+	t.Run("it applies new limits to existing clients", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		limiter := ratelimit.New(ratelimit.Config{
+			Client: ratelimit.Limit{Rate: 0.5, Burst: 1},
+		}, prometheus.NewRegistry())
+		interceptor := limiter.UnaryServerInterceptor(nil)
+
+		_, err := interceptor(fromIP("10.0.0.1"), nil, readInfo, ok)
+		g.Expect(err).ToNot(HaveOccurred())
+		_, err = interceptor(fromIP("10.0.0.1"), nil, readInfo, ok)
+		g.Expect(status.Code(err)).To(Equal(codes.ResourceExhausted))
+
+		limiter.SetLimits(ratelimit.Limit{}, ratelimit.Limit{})
+		_, err = interceptor(fromIP("10.0.0.1"), nil, readInfo, ok)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		// Existing buckets keep their tokens and refill at the new rate.
+		limiter.SetLimits(ratelimit.Limit{Rate: 1000, Burst: 5}, ratelimit.Limit{})
+		time.Sleep(10 * time.Millisecond)
+		for i := 0; i < 5; i++ {
+			_, err = interceptor(fromIP("10.0.0.1"), nil, readInfo, ok)
+			g.Expect(err).ToNot(HaveOccurred())
+		}
+	})
+
+	t.Run("a zero rate disables the limit", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		interceptor := ratelimit.New(ratelimit.Config{}, prometheus.NewRegistry()).UnaryServerInterceptor(nil)
+
+		for i := 0; i < 3; i++ {
+			_, err := interceptor(fromIP("10.0.0.1"), &logcache_v1.ReadRequest{SourceId: "app-1"}, readInfo, ok)
+			g.Expect(err).ToNot(HaveOccurred())
+		}
+	})
+}
+
+func TestHTTPMiddleware(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	sourceIDs := func(r *http.Request) []string {
+		return strings.Split(r.URL.Query().Get("source_id"), ",")
+	}
+	get := func(handler http.Handler, remoteAddr, sourceID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/batch-read?source_id="+sourceID, nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("it rejects clients over their limit with a retry-after header", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		registry := prometheus.NewRegistry()
+		handler := ratelimit.New(ratelimit.Config{
+			Client: ratelimit.Limit{Rate: 0.5, Burst: 1},
+		}, registry).HTTPMiddleware(nil)(ok)
+
+		g.Expect(get(handler, "10.0.0.1:51234", "app-1").Code).To(Equal(http.StatusOK))
+
+		rec := get(handler, "10.0.0.1:51235", "app-2")
+		g.Expect(rec.Code).To(Equal(http.StatusTooManyRequests))
+		g.Expect(rec.Header().Get("Retry-After")).To(Equal("2"))
+		g.Expect(rec.Body.String()).To(ContainSubstring("client rate limit exceeded"))
+
+		g.Expect(get(handler, "10.0.0.2:51234", "app-3").Code).To(Equal(http.StatusOK))
+
+		g.Expect(testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP rate_limited_requests_total Requests rejected with ResourceExhausted by the rate limiter
+# TYPE rate_limited_requests_total counter
+rate_limited_requests_total{limit="client"} 1
+`), "rate_limited_requests_total")).To(Succeed())
+	})
+
+	t.Run("it limits clients behind one sidecar by their forwarded SPIFFE IDs", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		handler := ratelimit.New(ratelimit.Config{
+			Client: ratelimit.Limit{Rate: 0.5, Burst: 1},
+		}, prometheus.NewRegistry()).HTTPMiddleware(nil)(ok)
+
+		getAs := func(uri string) int {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/read/app-1", nil)
+			req.RemoteAddr = "127.0.0.1:51234"
+			req.Header.Set(ratelimit.ForwardedClientCertHeader, "Hash=abc;URI="+uri)
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec.Code
+		}
+
+		g.Expect(getAs("spiffe://cluster.local/ns/cf-system/sa/log-cache")).To(Equal(http.StatusOK))
+		g.Expect(getAs("spiffe://cluster.local/ns/cf-system/sa/log-cache")).To(Equal(http.StatusTooManyRequests))
+		g.Expect(getAs("spiffe://cluster.local/ns/cf-system/sa/cloud-controller")).To(Equal(http.StatusOK))
+	})
+
+	t.Run("it takes a source token for each source ID in the request", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		handler := ratelimit.New(ratelimit.Config{
+			Source: ratelimit.Limit{Rate: 0.5, Burst: 1},
+		}, prometheus.NewRegistry()).HTTPMiddleware(sourceIDs)(ok)
+
+		g.Expect(get(handler, "10.0.0.1:51234", "app-1,app-2").Code).To(Equal(http.StatusOK))
+		g.Expect(get(handler, "10.0.0.2:51234", "app-2").Code).To(Equal(http.StatusTooManyRequests))
+		g.Expect(get(handler, "10.0.0.2:51234", "app-3").Code).To(Equal(http.StatusOK))
+	})
+}
+
+func TestClientIdentity(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 51234}
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "cloud-controller"}}
+
+	t.Run("it prefers the verified client certificate common name", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		ctx := peer.NewContext(context.Background(), &peer.Peer{
+			Addr: addr,
+			AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{cert},
+				VerifiedChains:   [][]*x509.Certificate{{cert}},
+			}},
+		})
+
+		g.Expect(ratelimit.ClientIdentity(ctx)).To(Equal("cn:cloud-controller"))
+	})
+
+	t.Run("it ignores client certificates that weren't verified", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		ctx := peer.NewContext(context.Background(), &peer.Peer{
+			Addr: addr,
+			AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{cert},
+			}},
+		})
+
+		g.Expect(ratelimit.ClientIdentity(ctx)).To(Equal("ip:10.0.0.1"))
+	})
+
+	t.Run("it ignores bearer token subjects and uses the peer IP without the port", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		token := "Bearer header." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"cloud_controller"}`)) + ".signature"
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: addr})
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", token))
+
+		g.Expect(ratelimit.ClientIdentity(ctx)).To(Equal("ip:10.0.0.1"))
+	})
+	t.Run("it uses the SPIFFE ID an Istio sidecar forwarded", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		xfcc := `By=spiffe://cluster.local/ns/cf-system/sa/metric-proxy;Hash=abc;Subject="CN=a,O=b";URI=spiffe://cluster.local/ns/cf-system/sa/log-cache`
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: addr})
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(ratelimit.ForwardedClientCertHeader, xfcc))
+
+		g.Expect(ratelimit.ClientIdentity(ctx)).To(Equal("uri:spiffe://cluster.local/ns/cf-system/sa/log-cache"))
+	})
+
+	t.Run("it uses the URI the nearest proxy appended to the forwarded certificates", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		xfcc := `URI=spiffe://cluster.local/ns/a/sa/gateway,Subject="CN=x,O=y";URI=spiffe://cluster.local/ns/cf-system/sa/cloud-controller`
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: addr})
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(ratelimit.ForwardedClientCertHeader, xfcc))
+
+		g.Expect(ratelimit.ClientIdentity(ctx)).To(Equal("uri:spiffe://cluster.local/ns/cf-system/sa/cloud-controller"))
+	})
+
+	t.Run("it falls back to the peer IP when the forwarded certificate has no URI", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: addr})
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(ratelimit.ForwardedClientCertHeader, `Hash=abc;Subject="CN=a"`))
+
+		g.Expect(ratelimit.ClientIdentity(ctx)).To(Equal("ip:10.0.0.1"))
+	})
+}
@@ -0,0 +1,204 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics/diskusage"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+// Fixtures serves recorded responses. Its methods match the function types
+// and interfaces of packages metrics and diskusage. Apps, pods and nodes
+// without a fixture have no metrics, aren't found and have no summary or IO
+// stats respectively.
+type Fixtures struct {
+	appLabel string
+
+	metrics map[string]*v1beta1.PodMetricsList
+	pods    map[string]*v1.Pod
+	nodes   map[string]diskusage.NodeDiskUsage
+	podIO   map[string]diskusage.NodeIOStats
+}
+
+// Open reads every fixture in dir. appLabel is the pod label holding the
+// app guid, used to list an app's pods.
+func Open(dir, appLabel string) (*Fixtures, error) {
+	f := &Fixtures{
+		appLabel: appLabel,
+		metrics:  map[string]*v1beta1.PodMetricsList{},
+		pods:     map[string]*v1.Pod{},
+		nodes:    map[string]diskusage.NodeDiskUsage{},
+		podIO:    map[string]diskusage.NodeIOStats{},
+	}
+
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+
+	err := readFixtures(dir, metricsDir, func(name string, data []byte) error {
+		list := &v1beta1.PodMetricsList{}
+		f.metrics[name] = list
+		return json.Unmarshal(data, list)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = readFixtures(dir, podsDir, func(name string, data []byte) error {
+		pod := &v1.Pod{}
+		f.pods[name] = pod
+		return json.Unmarshal(data, pod)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = readFixtures(dir, nodesDir, func(name string, data []byte) error {
+		var summary diskusage.NodeDiskUsage
+		if err := json.Unmarshal(data, &summary); err != nil {
+			return err
+		}
+		f.nodes[name] = summary
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = readFixtures(dir, podIODir, func(name string, data []byte) error {
+		var stats diskusage.NodeIOStats
+		if err := json.Unmarshal(data, &stats); err != nil {
+			return err
+		}
+		f.podIO[name] = stats
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// readFixtures calls decode with the name and content of each fixture in
+// dir/sub. A missing sub directory has no fixtures.
+func readFixtures(dir, sub string, decode func(name string, data []byte) error) error {
+	paths, err := filepath.Glob(filepath.Join(dir, sub, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		name, err := url.PathUnescape(strings.TrimSuffix(filepath.Base(path), ".json"))
+		if err != nil {
+			return fmt.Errorf("invalid fixture name %s: %w", path, err)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := decode(name, data); err != nil {
+			return fmt.Errorf("invalid fixture %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// Metrics returns the recorded metrics of app guid.
+func (f *Fixtures) Metrics(_ context.Context, guid string) (*v1beta1.PodMetricsList, error) {
+	list, ok := f.metrics[guid]
+	if !ok {
+		return &v1beta1.PodMetricsList{}, nil
+	}
+	return list.DeepCopy(), nil
+}
+
+// BatchMetrics returns Metrics for each of guids that has any.
+func (f *Fixtures) BatchMetrics(ctx context.Context, guids []string) (map[string]*v1beta1.PodMetricsList, error) {
+	byGUID := make(map[string]*v1beta1.PodMetricsList, len(guids))
+	for _, guid := range guids {
+		list, _ := f.Metrics(ctx, guid)
+		if len(list.Items) > 0 {
+			byGUID[guid] = list
+		}
+	}
+	return byGUID, nil
+}
+
+// Pods returns the recorded pods of app guid.
+func (f *Fixtures) Pods(guid string) ([]*v1.Pod, error) {
+	var pods []*v1.Pod
+	for _, pod := range f.sortedPods() {
+		if pod.Labels[f.appLabel] == guid {
+			pods = append(pods, pod.DeepCopy())
+		}
+	}
+	return pods, nil
+}
+
+// Get returns the named recorded pod.
+func (f *Fixtures) Get(_ context.Context, podName string) (*v1.Pod, error) {
+	pod, ok := f.pods[podName]
+	if !ok {
+		return nil, fmt.Errorf("pod %q not found", podName)
+	}
+	return pod.DeepCopy(), nil
+}
+
+// Summary returns the recorded stats summary of the named node.
+func (f *Fixtures) Summary(_ context.Context, nodeName string) (diskusage.NodeDiskUsage, error) {
+	summary, ok := f.nodes[nodeName]
+	if !ok {
+		return diskusage.NodeDiskUsage{}, fmt.Errorf("summary for node %q not found", nodeName)
+	}
+	return summary, nil
+}
+
+// IOStats returns the recorded IO stats of the named pod.
+func (f *Fixtures) IOStats(_ context.Context, _, podName string) (diskusage.NodeIOStats, error) {
+	stats, ok := f.podIO[podName]
+	if !ok {
+		return diskusage.NodeIOStats{}, fmt.Errorf("io stats for pod %q not found", podName)
+	}
+	return stats, nil
+}
+
+// Sources returns the guids of apps with a recorded pod carrying all of the
+// given labels.
+func (f *Fixtures) Sources(set map[string]string) ([]string, error) {
+	selector := labels.SelectorFromSet(set)
+
+	seen := map[string]bool{}
+	var guids []string
+	for _, pod := range f.sortedPods() {
+		guid, ok := pod.Labels[f.appLabel]
+		if !ok || seen[guid] || !selector.Matches(labels.Set(pod.Labels)) {
+			continue
+		}
+		seen[guid] = true
+		guids = append(guids, guid)
+	}
+	sort.Strings(guids)
+	return guids, nil
+}
+
+func (f *Fixtures) sortedPods() []*v1.Pod {
+	pods := make([]*v1.Pod, 0, len(f.pods))
+	for _, pod := range f.pods {
+		pods = append(pods, pod)
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].Name < pods[j].Name
+	})
+	return pods
+}
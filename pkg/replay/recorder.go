@@ -0,0 +1,208 @@
+// Package replay records the Kubernetes API responses metric-proxy builds
+// envelopes from, and serves them back in place of a cluster. A fixture
+// directory holds one JSON file per response:
+//
+//	metrics/<app guid>.json  PodMetricsList from metrics-server
+//	pods/<pod name>.json     Pod
+//	nodes/<node name>.json   kubelet stats summary
+//	pod-io/<pod name>.json   the pod's filesystem IO from cAdvisor
+//
+// Recording a live cluster and replaying it makes bugs that depend on
+// metrics-server output reproducible in tests. Recorded pods are redacted:
+// container environments can hold service credentials, so they are never
+// written, and neither are managed fields or annotations metric-proxy
+// doesn't read.
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"code.cloudfoundry.org/metric-proxy/pkg/logging"
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics"
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics/diskusage"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+const (
+	metricsDir = "metrics"
+	podsDir    = "pods"
+	nodesDir   = "nodes"
+	podIODir   = "pod-io"
+)
+
+// Recorder wraps the upstream calls of a backend, writing each successful
+// response to a fixture directory. A later response for the same app, pod or
+// node replaces the earlier one.
+type Recorder struct {
+	logger      *logging.Logger
+	dir         string
+	annotations map[string]bool
+}
+
+// NewRecorder creates the fixture directory dir if needed. Recorded pods keep
+// only the given annotations.
+func NewRecorder(logger *logging.Logger, dir string, annotations ...string) (*Recorder, error) {
+	for _, sub := range []string{metricsDir, podsDir, nodesDir, podIODir} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	keep := make(map[string]bool, len(annotations))
+	for _, annotation := range annotations {
+		keep[annotation] = true
+	}
+	return &Recorder{logger: logger, dir: dir, annotations: keep}, nil
+}
+
+// MetricsFetcher records the lists fetch returns.
+func (r *Recorder) MetricsFetcher(fetch metrics.MetricsFetcherFn) metrics.MetricsFetcherFn {
+	return func(ctx context.Context, guid string) (*v1beta1.PodMetricsList, error) {
+		list, err := fetch(ctx, guid)
+		if err == nil {
+			r.write(metricsDir, guid, list)
+		}
+		return list, err
+	}
+}
+
+// BatchMetricsFetcher records the list of each app fetch returns, the same
+// way MetricsFetcher would.
+func (r *Recorder) BatchMetricsFetcher(fetch metrics.BatchMetricsFetcherFn) metrics.BatchMetricsFetcherFn {
+	return func(ctx context.Context, guids []string) (map[string]*v1beta1.PodMetricsList, error) {
+		byGUID, err := fetch(ctx, guids)
+		if err == nil {
+			for guid, list := range byGUID {
+				r.write(metricsDir, guid, list)
+			}
+		}
+		return byGUID, err
+	}
+}
+
+// PodLister records the pods list returns.
+func (r *Recorder) PodLister(list metrics.PodListerFn) metrics.PodListerFn {
+	return func(guid string) ([]*v1.Pod, error) {
+		pods, err := list(guid)
+		if err == nil {
+			for _, pod := range pods {
+				r.write(podsDir, pod.Name, r.redact(pod))
+			}
+		}
+		return pods, err
+	}
+}
+
+// PodGetter records the pods getter returns.
+func (r *Recorder) PodGetter(getter diskusage.PodGetter) diskusage.PodGetter {
+	return &recordingPodGetter{recorder: r, getter: getter}
+}
+
+// NodeStatter records the node summaries and IO stats statter returns.
+func (r *Recorder) NodeStatter(statter diskusage.NodeStatter) diskusage.NodeStatter {
+	return &recordingNodeStatter{recorder: r, statter: statter}
+}
+
+type recordingPodGetter struct {
+	recorder *Recorder
+	getter   diskusage.PodGetter
+}
+
+func (g *recordingPodGetter) Get(ctx context.Context, podName string) (*v1.Pod, error) {
+	pod, err := g.getter.Get(ctx, podName)
+	if err == nil {
+		g.recorder.write(podsDir, podName, g.recorder.redact(pod))
+	}
+	return pod, err
+}
+
+type recordingNodeStatter struct {
+	recorder *Recorder
+	statter  diskusage.NodeStatter
+}
+
+func (s *recordingNodeStatter) Summary(ctx context.Context, nodeName string) (diskusage.NodeDiskUsage, error) {
+	summary, err := s.statter.Summary(ctx, nodeName)
+	if err == nil {
+		s.recorder.write(nodesDir, nodeName, summary)
+	}
+	return summary, err
+}
+
+func (s *recordingNodeStatter) IOStats(ctx context.Context, nodeName, podName string) (diskusage.NodeIOStats, error) {
+	stats, err := s.statter.IOStats(ctx, nodeName, podName)
+	if err == nil {
+		s.recorder.write(podIODir, podName, stats)
+	}
+	return stats, err
+}
+
+// redact returns a copy of pod without container environments, managed
+// fields or annotations the recorder wasn't told to keep.
+func (r *Recorder) redact(pod *v1.Pod) *v1.Pod {
+	redacted := pod.DeepCopy()
+	redacted.ManagedFields = nil
+
+	var annotations map[string]string
+	for key, value := range pod.Annotations {
+		if !r.annotations[key] {
+			continue
+		}
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[key] = value
+	}
+	redacted.Annotations = annotations
+
+	for _, containers := range [][]v1.Container{redacted.Spec.InitContainers, redacted.Spec.Containers} {
+		for i := range containers {
+			containers[i].Env = nil
+			containers[i].EnvFrom = nil
+		}
+	}
+	return redacted
+}
+
+// write replaces the fixture atomically so a replay never reads half a
+// file. Failing to record doesn't fail the request.
+func (r *Recorder) write(sub, name string, v interface{}) {
+	if err := r.writeFile(sub, name, v); err != nil {
+		r.logger.Error("cannot record fixture", "fixture", filepath.Join(sub, name), "error", err)
+	}
+}
+
+func (r *Recorder) writeFile(sub, name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(r.dir, sub)
+	f, err := ioutil.TempFile(dir, ".fixture-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(dir, fixtureFile(name)))
+}
+
+// fixtureFile escapes name so it can't leave the fixture directory.
+func fixtureFile(name string) string {
+	return fmt.Sprintf("%s.json", url.PathEscape(name))
+}
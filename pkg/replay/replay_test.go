@@ -0,0 +1,279 @@
+package replay_test
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/log-cache/pkg/rpc/logcache_v1"
+	"code.cloudfoundry.org/metric-proxy/pkg/logging"
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics"
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics/diskusage"
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics/diskusage/diskusagefakes"
+	"code.cloudfoundry.org/metric-proxy/pkg/replay"
+	"code.cloudfoundry.org/metric-proxy/pkg/selfmetrics"
+	"github.com/golang/protobuf/jsonpb"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+const appLabel = "cloudfoundry.org/app_guid"
+
+func TestRecordAndReplay(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "cf-workloads",
+			Name:      "app-name-0",
+			Labels:    map[string]string{appLabel: "app-guid", "cloudfoundry.org/space_guid": "space-guid"},
+		},
+		Spec: corev1.PodSpec{NodeName: "node-1"},
+	}
+	list := &v1beta1.PodMetricsList{Items: []v1beta1.PodMetrics{{
+		ObjectMeta: pod.ObjectMeta,
+		Containers: []v1beta1.ContainerMetrics{{
+			Name: "opi",
+			Usage: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("420000000n"),
+				corev1.ResourceMemory: resource.MustParse("27868Ki"),
+			},
+		}},
+	}}}
+	summary := diskusage.NodeDiskUsage{Pods: []diskusage.PodDiskUsage{{
+		PodRef:     diskusage.PodRef{Name: "app-name-0", Namespace: "cf-workloads"},
+		Containers: []diskusage.ContainerDiskUsage{{Name: "opi", RootFS: diskusage.DiskUsage{UsedBytes: 1024}}},
+	}}}
+	ioStats := diskusage.NodeIOStats{Pods: []diskusage.PodIOStats{{
+		PodRef:     diskusage.PodRef{Name: "app-name-0", Namespace: "cf-workloads"},
+		Containers: []diskusage.ContainerIOStats{{Name: "opi", ReadBytes: 4096, WriteBytes: 512}},
+	}}}
+
+	t.Run("it replays what it recorded", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		dir, err := ioutil.TempDir("", "replay")
+		g.Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		recorder, err := replay.NewRecorder(quietLogger(), dir)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		podGetter := &diskusagefakes.FakePodGetter{}
+		podGetter.GetReturns(pod, nil)
+		nodeStatter := &diskusagefakes.FakeNodeStatter{}
+		nodeStatter.SummaryReturns(summary, nil)
+		nodeStatter.IOStatsReturns(ioStats, nil)
+
+		_, err = recorder.MetricsFetcher(func(context.Context, string) (*v1beta1.PodMetricsList, error) {
+			return list, nil
+		})(context.Background(), "app-guid")
+		g.Expect(err).ToNot(HaveOccurred())
+		_, err = recorder.PodLister(func(string) ([]*corev1.Pod, error) {
+			return []*corev1.Pod{pod}, nil
+		})("app-guid")
+		g.Expect(err).ToNot(HaveOccurred())
+		_, err = recorder.PodGetter(podGetter).Get(context.Background(), "app-name-0")
+		g.Expect(err).ToNot(HaveOccurred())
+		_, err = recorder.NodeStatter(nodeStatter).Summary(context.Background(), "node-1")
+		g.Expect(err).ToNot(HaveOccurred())
+		_, err = recorder.NodeStatter(nodeStatter).IOStats(context.Background(), "node-1", "app-name-0")
+		g.Expect(err).ToNot(HaveOccurred())
+
+		fixtures, err := replay.Open(dir, appLabel)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		replayedList, err := fixtures.Metrics(context.Background(), "app-guid")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(replayedList.Items).To(HaveLen(1))
+		cpu := replayedList.Items[0].Containers[0].Usage[corev1.ResourceCPU]
+		g.Expect(cpu.ScaledValue(resource.Nano)).To(BeEquivalentTo(420000000))
+
+		pods, err := fixtures.Pods("app-guid")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(pods).To(ConsistOf(pod))
+
+		replayedPod, err := fixtures.Get(context.Background(), "app-name-0")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(replayedPod).To(Equal(pod))
+
+		replayedSummary, err := fixtures.Summary(context.Background(), "node-1")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(replayedSummary).To(Equal(summary))
+
+		replayedIOStats, err := fixtures.IOStats(context.Background(), "node-1", "app-name-0")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(replayedIOStats).To(Equal(ioStats))
+
+		guids, err := fixtures.Sources(map[string]string{"cloudfoundry.org/space_guid": "space-guid"})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(guids).To(ConsistOf("app-guid"))
+	})
+
+	t.Run("it doesn't record container environments or unused annotations", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		dir, err := ioutil.TempDir("", "replay")
+		g.Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		recorder, err := replay.NewRecorder(quietLogger(), dir, "cloudfoundry.org/application_name")
+		g.Expect(err).ToNot(HaveOccurred())
+
+		secretPod := pod.DeepCopy()
+		secretPod.Annotations = map[string]string{
+			"cloudfoundry.org/application_name":                "app-name",
+			"kubectl.kubernetes.io/last-applied-configuration": `{"env":"VCAP_SERVICES"}`,
+		}
+		secretPod.ManagedFields = []metav1.ManagedFieldsEntry{{Manager: "eirini"}}
+		secretPod.Spec.InitContainers = []corev1.Container{{
+			Name: "init",
+			Env:  []corev1.EnvVar{{Name: "VCAP_SERVICES", Value: `{"password":"hunter2"}`}},
+		}}
+		secretPod.Spec.Containers = []corev1.Container{{
+			Name:    "opi",
+			Env:     []corev1.EnvVar{{Name: "VCAP_SERVICES", Value: `{"password":"hunter2"}`}},
+			EnvFrom: []corev1.EnvFromSource{{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "app-secrets"}}}},
+		}}
+		podGetter := &diskusagefakes.FakePodGetter{}
+		podGetter.GetReturns(secretPod, nil)
+
+		got, err := recorder.PodGetter(podGetter).Get(context.Background(), "app-name-0")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(got).To(Equal(secretPod), "the caller gets the pod as it was")
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, "pods", "app-name-0.json"))
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(string(data)).ToNot(ContainSubstring("VCAP_SERVICES"))
+		g.Expect(string(data)).ToNot(ContainSubstring("app-secrets"))
+		g.Expect(string(data)).ToNot(ContainSubstring("eirini"))
+
+		fixtures, err := replay.Open(dir, appLabel)
+		g.Expect(err).ToNot(HaveOccurred())
+		recorded, err := fixtures.Get(context.Background(), "app-name-0")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(recorded.Annotations).To(Equal(map[string]string{"cloudfoundry.org/application_name": "app-name"}))
+		g.Expect(recorded.Spec.InitContainers[0].Env).To(BeEmpty())
+		g.Expect(recorded.Spec.Containers[0].Env).To(BeEmpty())
+		g.Expect(recorded.Spec.Containers[0].EnvFrom).To(BeEmpty())
+	})
+
+	t.Run("it doesn't record failed calls", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		dir, err := ioutil.TempDir("", "replay")
+		g.Expect(err).ToNot(HaveOccurred())
+		defer os.RemoveAll(dir)
+
+		recorder, err := replay.NewRecorder(quietLogger(), dir)
+		g.Expect(err).ToNot(HaveOccurred())
+
+		nodeStatter := &diskusagefakes.FakeNodeStatter{}
+		nodeStatter.SummaryReturns(diskusage.NodeDiskUsage{}, errors.New("node unreachable"))
+		_, err = recorder.NodeStatter(nodeStatter).Summary(context.Background(), "node-1")
+		g.Expect(err).To(MatchError("node unreachable"))
+
+		fixtures, err := replay.Open(dir, appLabel)
+		g.Expect(err).ToNot(HaveOccurred())
+		_, err = fixtures.Summary(context.Background(), "node-1")
+		g.Expect(err).To(MatchError(`summary for node "node-1" not found`))
+	})
+
+	t.Run("it fails to open a missing directory", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		_, err := replay.Open("testdata/missing", appLabel)
+		g.Expect(err).To(HaveOccurred())
+	})
+}
+
+// TestGolden replays each testdata/<case>/fixtures directory through a Proxy
+// and compares every app's envelopes to testdata/<case>/envelopes.golden.
+// Run with -update to accept changes.
+func TestGolden(t *testing.T) {
+	cases, err := filepath.Glob("testdata/*/fixtures")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fixturesDir := range cases {
+		dir := filepath.Dir(fixturesDir)
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			g := NewGomegaWithT(t)
+
+			fixtures, err := replay.Open(fixturesDir, appLabel)
+			g.Expect(err).ToNot(HaveOccurred())
+
+			selfMetrics := selfmetrics.New(prometheus.NewRegistry(), []float64{1})
+			fetcher := diskusage.NewFetcher(quietLogger(), selfMetrics, cache.NewExpiring(), time.Minute, fixtures, fixtures)
+			proxy := metrics.NewProxy(
+				quietLogger(),
+				selfMetrics,
+				metrics.NewMetricsCache(selfMetrics, cache.NewExpiring(), 0),
+				fixtures.Metrics,
+				fixtures.Pods,
+				fetcher,
+				fixtures,
+				metrics.ProxyOptions{CPUTimeFetcher: fetcher, DiskIOFetcher: fetcher},
+			)
+
+			guids, err := fixtures.Sources(map[string]string{})
+			g.Expect(err).ToNot(HaveOccurred())
+
+			var envelopes []*loggregator_v2.Envelope
+			for _, guid := range guids {
+				resp, err := proxy.Read(context.Background(), &logcache_v1.ReadRequest{SourceId: guid})
+				g.Expect(err).ToNot(HaveOccurred())
+				envelopes = append(envelopes, resp.GetEnvelopes().GetBatch()...)
+			}
+			actual := marshalEnvelopes(g, envelopes)
+
+			goldenFile := filepath.Join(dir, "envelopes.golden")
+			if *update {
+				g.Expect(ioutil.WriteFile(goldenFile, actual, 0644)).To(Succeed())
+			}
+			expected, err := ioutil.ReadFile(goldenFile)
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(string(actual)).To(Equal(string(expected)))
+		})
+	}
+}
+
+// marshalEnvelopes writes envelopes as a sorted JSON array. Timestamps and
+// the gauges measured from the current time are zeroed so replays are
+// repeatable.
+func marshalEnvelopes(g *GomegaWithT, envelopes []*loggregator_v2.Envelope) []byte {
+	marshaler := &jsonpb.Marshaler{OrigName: true, Indent: "  "}
+
+	var marshaled []string
+	for _, e := range envelopes {
+		e.Timestamp = 0
+		if gauge, ok := e.GetGauge().GetMetrics()["container_age"]; ok {
+			gauge.Value = 0
+		}
+
+		s, err := marshaler.MarshalToString(e)
+		g.Expect(err).ToNot(HaveOccurred())
+		marshaled = append(marshaled, s)
+	}
+	sort.Strings(marshaled)
+
+	return []byte("[\n" + strings.Join(marshaled, ",\n") + "\n]\n")
+}
+
+func quietLogger() *logging.Logger {
+	return logging.New(ioutil.Discard, logging.Error)
+}
@@ -0,0 +1,17 @@
+#!/bin/bash
+
+set -e
+
+cd "$(dirname "$0")"
+
+go install github.com/golang/protobuf/protoc-gen-go@v1.4.2
+
+loggregator_api=$(mktemp -d)
+trap 'rm -rf "$loggregator_api"' EXIT
+git clone --quiet https://github.com/cloudfoundry/loggregator-api "$loggregator_api"
+
+protoc \
+    metricproxy.proto \
+    --go_out=plugins=grpc,paths=source_relative,Mv2/envelope.proto=code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2:. \
+    --proto_path=. \
+    --proto_path="$loggregator_api"
@@ -0,0 +1,289 @@
+// Package scraper collects the Prometheus metrics app instances expose, so
+// they can be read alongside their container metrics the way Diego's metric
+// registrar forwards them.
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/metric-proxy/pkg/logging"
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics"
+	"code.cloudfoundry.org/metric-proxy/pkg/selfmetrics"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	v1 "k8s.io/api/core/v1"
+)
+
+// The pod annotations that opt an instance into scraping, as understood by
+// Prometheus' Kubernetes service discovery examples.
+const (
+	ScrapeAnnotation = "prometheus.io/scrape"
+	PortAnnotation   = "prometheus.io/port"
+	PathAnnotation   = "prometheus.io/path"
+)
+
+const (
+	// defaultPort is the port Cloud Foundry apps listen on.
+	defaultPort = "8080"
+	defaultPath = "/metrics"
+
+	// maxResponseBytes bounds how much of a response is parsed.
+	maxResponseBytes = 10 << 20
+	// maxConcurrentScrapes bounds how many instances are scraped at once.
+	maxConcurrentScrapes = 16
+)
+
+// reservedNames are the metrics Proxy creates. Scraped metrics with these
+// names are dropped so they can't be mistaken for container metrics.
+var reservedNames = map[string]bool{
+	"cpu":           true,
+	"memory":        true,
+	"memory_quota":  true,
+	"memoryutil":    true,
+	"disk":          true,
+	"disk_quota":    true,
+	"state":         true,
+	"restart_count": true,
+	"container_age": true,
+
+	metrics.CPUTimeCounter:        true,
+	metrics.DiskReadBytesCounter:  true,
+	metrics.DiskWriteBytesCounter: true,
+}
+
+// reservedTags are the tags Proxy sets on its own envelopes. Scraped labels
+// with these names are renamed with an "exported_" prefix, as Prometheus
+// does for conflicting target labels, so a scraped series can't pass for a
+// container metric or a rollup.
+var reservedTags = map[string]bool{
+	"process_id":      true,
+	"origin":          true,
+	metrics.RollupTag: true,
+}
+
+// Scraper scrapes every annotated app instance and keeps the envelopes of
+// the latest round. Gauges and untyped metrics become gauge envelopes and
+// counters become counter envelopes, tagged with their labels. Other metric
+// types are ignored.
+type Scraper struct {
+	logger         *logging.Logger
+	selfMetrics    *selfmetrics.Metrics
+	sourceListerFn metrics.SourceListerFn
+	podListerFn    metrics.PodListerFn
+	client         *http.Client
+
+	mu        sync.RWMutex
+	envelopes map[string][]*loggregator_v2.Envelope
+}
+
+func New(logger *logging.Logger, selfMetrics *selfmetrics.Metrics, sourceListerFn metrics.SourceListerFn, podListerFn metrics.PodListerFn, client *http.Client) *Scraper {
+	return &Scraper{
+		logger:         logger,
+		selfMetrics:    selfMetrics,
+		sourceListerFn: sourceListerFn,
+		podListerFn:    podListerFn,
+		client:         client,
+		envelopes:      map[string][]*loggregator_v2.Envelope{},
+	}
+}
+
+// Envelopes returns the envelopes scraped from sourceID's instances in the
+// latest round. It is a metrics.CustomEnvelopesFn.
+func (s *Scraper) Envelopes(sourceID string) []*loggregator_v2.Envelope {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.envelopes[sourceID]
+}
+
+// Run scrapes every interval until stop is closed. Each round must finish
+// within the interval.
+func (s *Scraper) Run(interval time.Duration, stop <-chan struct{}) {
+	metrics.RunRounds(interval, stop, func(ctx context.Context) {
+		if err := s.Scrape(ctx); err != nil {
+			s.logger.Error("failed to scrape app metrics", "error", err)
+		}
+	})
+}
+
+// Scrape scrapes every annotated, running app instance once and replaces
+// the kept envelopes. An instance that fails to be scraped is logged and has
+// no envelopes until it succeeds again; only failing to list apps and pods
+// is returned.
+func (s *Scraper) Scrape(ctx context.Context) error {
+	sourceIDs, err := s.sourceListerFn(map[string]string{})
+	if err != nil {
+		return err
+	}
+
+	type target struct {
+		sourceID string
+		pod      *v1.Pod
+	}
+	var targets []target
+	for _, sourceID := range sourceIDs {
+		pods, err := s.podListerFn(sourceID)
+		if err != nil {
+			return err
+		}
+		for _, pod := range pods {
+			if scrapeable(pod) {
+				targets = append(targets, target{sourceID: sourceID, pod: pod})
+			}
+		}
+	}
+
+	var (
+		mu        sync.Mutex
+		wg        sync.WaitGroup
+		envelopes = map[string][]*loggregator_v2.Envelope{}
+		limit     = make(chan struct{}, maxConcurrentScrapes)
+	)
+	for _, t := range targets {
+		wg.Add(1)
+		limit <- struct{}{}
+		go func(sourceID string, pod *v1.Pod) {
+			defer wg.Done()
+			defer func() { <-limit }()
+
+			scraped, err := s.scrapePod(ctx, sourceID, pod)
+			if err != nil {
+				s.logger.Warn("failed to scrape app instance", "source_id", sourceID, "pod", pod.Name, "error", err)
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			envelopes[sourceID] = append(envelopes[sourceID], scraped...)
+		}(t.sourceID, t.pod)
+	}
+	wg.Wait()
+
+	s.mu.Lock()
+	s.envelopes = envelopes
+	s.mu.Unlock()
+
+	s.logger.Debug("app metrics scraped", "instances", len(targets), "sources", len(envelopes))
+	return nil
+}
+
+func scrapeable(pod *v1.Pod) bool {
+	return pod.Annotations[ScrapeAnnotation] == "true" &&
+		pod.Status.Phase == v1.PodRunning &&
+		pod.Status.PodIP != "" &&
+		pod.DeletionTimestamp == nil
+}
+
+func (s *Scraper) scrapePod(ctx context.Context, sourceID string, pod *v1.Pod) ([]*loggregator_v2.Envelope, error) {
+	start := time.Now()
+	families, err := s.fetch(ctx, targetURL(pod))
+	s.selfMetrics.ObserveUpstream(selfmetrics.DependencyAppScrape, start, err)
+	if err != nil {
+		return nil, err
+	}
+
+	return createEnvelopes(sourceID, metrics.InstanceID(pod.Name), families, time.Now()), nil
+}
+
+func targetURL(pod *v1.Pod) string {
+	port := pod.Annotations[PortAnnotation]
+	if port == "" {
+		port = defaultPort
+	}
+	path := pod.Annotations[PathAnnotation]
+	if path == "" {
+		path = defaultPath
+	}
+	if path[0] != '/' {
+		path = "/" + path
+	}
+
+	return "http://" + net.JoinHostPort(pod.Status.PodIP, port) + path
+}
+
+func (s *Scraper) fetch(ctx context.Context, url string) (map[string]*dto.MetricFamily, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", string(expfmt.FmtText))
+
+	resp, err := s.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s from %s", resp.Status, url)
+	}
+
+	var parser expfmt.TextParser
+	return parser.TextToMetricFamilies(io.LimitReader(resp.Body, maxResponseBytes))
+}
+
+func createEnvelopes(sourceID, instanceID string, families map[string]*dto.MetricFamily, now time.Time) []*loggregator_v2.Envelope {
+	var envelopes []*loggregator_v2.Envelope
+	for name, family := range families {
+		if reservedNames[name] {
+			continue
+		}
+
+		for _, m := range family.GetMetric() {
+			e := &loggregator_v2.Envelope{
+				Timestamp:  now.UnixNano(),
+				SourceId:   sourceID,
+				InstanceId: instanceID,
+				Tags:       tags(sourceID, m.GetLabel()),
+			}
+
+			switch family.GetType() {
+			case dto.MetricType_GAUGE:
+				e.Message = gauge(name, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				e.Message = gauge(name, m.GetUntyped().GetValue())
+			case dto.MetricType_COUNTER:
+				e.Message = &loggregator_v2.Envelope_Counter{
+					Counter: &loggregator_v2.Counter{
+						Name:  name,
+						Total: uint64(m.GetCounter().GetValue()),
+					},
+				}
+			default:
+				continue
+			}
+			envelopes = append(envelopes, e)
+		}
+	}
+	return envelopes
+}
+
+func gauge(name string, value float64) *loggregator_v2.Envelope_Gauge {
+	return &loggregator_v2.Envelope_Gauge{
+		Gauge: &loggregator_v2.Gauge{
+			Metrics: map[string]*loggregator_v2.GaugeValue{
+				name: {Value: value},
+			},
+		},
+	}
+}
+
+func tags(sourceID string, labels []*dto.LabelPair) map[string]string {
+	tags := make(map[string]string, len(labels)+1)
+	for _, label := range labels {
+		name := label.GetName()
+		if reservedTags[name] {
+			name = "exported_" + name
+		}
+		tags[name] = label.GetValue()
+	}
+	tags["process_id"] = sourceID
+	return tags
+}
@@ -0,0 +1,240 @@
+package scraper_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/go-loggregator/rpc/loggregator_v2"
+	"code.cloudfoundry.org/log-cache/pkg/rpc/logcache_v1"
+	"code.cloudfoundry.org/metric-proxy/pkg/logging"
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics"
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics/metricsfakes"
+	"code.cloudfoundry.org/metric-proxy/pkg/scraper"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+const exposition = `# TYPE requests_total counter
+requests_total{method="GET"} 42
+requests_total{method="POST"} 7
+# TYPE queue_depth gauge
+queue_depth 3.5
+connections{pool="a",origin="rep",rollup="sum"} 2
+connections{pool="b"} 5
+custom_untyped 1
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="+Inf"} 1
+latency_seconds_sum 0.1
+latency_seconds_count 1
+# TYPE cpu gauge
+cpu 99
+`
+
+func TestScraper(t *testing.T) {
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/custom-metrics" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, exposition)
+	}))
+	defer app.Close()
+	_, port, _ := net.SplitHostPort(app.Listener.Addr().String())
+
+	annotatedPod := func(name string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: v1.ObjectMeta{
+				Name: name,
+				Annotations: map[string]string{
+					scraper.ScrapeAnnotation: "true",
+					scraper.PortAnnotation:   port,
+					scraper.PathAnnotation:   "/custom-metrics",
+				},
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning, PodIP: "127.0.0.1"},
+		}
+	}
+	sourceLister := func(map[string]string) ([]string, error) {
+		return []string{"app-guid"}, nil
+	}
+	newScraper := func(pods ...*corev1.Pod) *scraper.Scraper {
+		podLister := func(string) ([]*corev1.Pod, error) {
+			return pods, nil
+		}
+		return scraper.New(quietLogger(), nil, sourceLister, podLister, &http.Client{Timeout: time.Second})
+	}
+
+	t.Run("it converts gauges and counters into envelopes", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		s := newScraper(annotatedPod("app-name-1"))
+		g.Expect(s.Scrape(context.Background())).To(Succeed())
+
+		envelopes := s.Envelopes("app-guid")
+		g.Expect(envelopes).To(HaveLen(6))
+		for _, e := range envelopes {
+			g.Expect(e.GetSourceId()).To(Equal("app-guid"))
+			g.Expect(e.GetInstanceId()).To(Equal("1"))
+			g.Expect(e.GetTags()).To(HaveKeyWithValue("process_id", "app-guid"))
+		}
+
+		g.Expect(counters(envelopes)).To(ConsistOf(
+			"requests_total method=GET 42",
+			"requests_total method=POST 7",
+		))
+		g.Expect(gauges(envelopes)).To(ConsistOf(
+			"queue_depth 3.5",
+			"connections 2",
+			"connections 5",
+			"custom_untyped 1",
+		))
+		g.Expect(s.Envelopes("other-guid")).To(BeEmpty())
+	})
+
+	t.Run("labels named like reserved tags are exported under a prefix", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		s := newScraper(annotatedPod("app-name-1"))
+		g.Expect(s.Scrape(context.Background())).To(Succeed())
+
+		var tags map[string]string
+		for _, e := range s.Envelopes("app-guid") {
+			if e.GetTags()["pool"] == "a" {
+				tags = e.GetTags()
+			}
+		}
+		g.Expect(tags).To(Equal(map[string]string{
+			"process_id":      "app-guid",
+			"pool":            "a",
+			"exported_origin": "rep",
+			"exported_rollup": "sum",
+		}))
+	})
+
+	t.Run("it only scrapes running instances annotated for scraping", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		unannotated := annotatedPod("app-name-0")
+		delete(unannotated.Annotations, scraper.ScrapeAnnotation)
+		pending := annotatedPod("app-name-1")
+		pending.Status = corev1.PodStatus{Phase: corev1.PodPending}
+
+		s := newScraper(unannotated, pending)
+		g.Expect(s.Scrape(context.Background())).To(Succeed())
+		g.Expect(s.Envelopes("app-guid")).To(BeEmpty())
+	})
+
+	t.Run("an instance that fails to be scraped has no envelopes", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		wrongPath := annotatedPod("app-name-1")
+		wrongPath.Annotations[scraper.PathAnnotation] = "/metrics"
+
+		s := newScraper(annotatedPod("app-name-0"), wrongPath)
+		g.Expect(s.Scrape(context.Background())).To(Succeed())
+
+		envelopes := s.Envelopes("app-guid")
+		g.Expect(envelopes).To(HaveLen(6))
+		for _, e := range envelopes {
+			g.Expect(e.GetInstanceId()).To(Equal("0"))
+		}
+	})
+
+	t.Run("it fails when apps can't be listed", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		failingLister := func(map[string]string) ([]string, error) {
+			return nil, errors.New("informer not synced")
+		}
+		s := scraper.New(quietLogger(), nil, failingLister, nil, http.DefaultClient)
+		g.Expect(s.Scrape(context.Background())).To(MatchError("informer not synced"))
+	})
+
+	t.Run("Read returns scraped envelopes alongside container metrics", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		pod := annotatedPod("app-name-0")
+		s := newScraper(pod)
+		g.Expect(s.Scrape(context.Background())).To(Succeed())
+
+		noMetrics := func(context.Context, string) (*v1beta1.PodMetricsList, error) {
+			return &v1beta1.PodMetricsList{}, nil
+		}
+		podLister := func(string) ([]*corev1.Pod, error) {
+			return []*corev1.Pod{pod}, nil
+		}
+		proxy := metrics.NewProxy(quietLogger(), nil, nil, noMetrics, podLister, new(metricsfakes.FakeDiskUsageFetcher), new(metricsfakes.FakePodGetter), metrics.ProxyOptions{CustomEnvelopes: s.Envelopes})
+
+		resp, err := proxy.Read(context.Background(), &logcache_v1.ReadRequest{SourceId: "app-guid"})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(gauges(resp.GetEnvelopes().GetBatch())).To(ContainElements("queue_depth 3.5", "memory 0"))
+		g.Expect(counters(resp.GetEnvelopes().GetBatch())).To(HaveLen(2))
+	})
+
+	t.Run("rollups keep scraped series with different labels apart", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		pods := []*corev1.Pod{annotatedPod("app-name-0"), annotatedPod("app-name-1")}
+		s := newScraper(pods...)
+		g.Expect(s.Scrape(context.Background())).To(Succeed())
+
+		noMetrics := func(context.Context, string) (*v1beta1.PodMetricsList, error) {
+			return &v1beta1.PodMetricsList{}, nil
+		}
+		podLister := func(string) ([]*corev1.Pod, error) {
+			return pods, nil
+		}
+		proxy := metrics.NewProxy(quietLogger(), nil, nil, noMetrics, podLister, new(metricsfakes.FakeDiskUsageFetcher), new(metricsfakes.FakePodGetter), metrics.ProxyOptions{CustomEnvelopes: s.Envelopes})
+
+		resp, err := proxy.Read(context.Background(), &logcache_v1.ReadRequest{SourceId: "app-guid" + metrics.RollupSourceIDSuffix})
+		g.Expect(err).ToNot(HaveOccurred())
+
+		sums := map[string]float64{}
+		for _, e := range resp.GetEnvelopes().GetBatch() {
+			if e.GetTags()[metrics.RollupTag] != "sum" {
+				continue
+			}
+			if connections, ok := e.GetGauge().GetMetrics()["connections"]; ok {
+				sums[e.GetTags()["pool"]] = connections.GetValue()
+			}
+			if memory, ok := e.GetGauge().GetMetrics()["memory"]; ok {
+				g.Expect(e.GetTags()).To(HaveKeyWithValue("origin", "rep"))
+				g.Expect(memory.GetValue()).To(BeZero())
+			}
+		}
+		g.Expect(sums).To(Equal(map[string]float64{"a": 4, "b": 10}))
+	})
+}
+
+func counters(envelopes []*loggregator_v2.Envelope) []string {
+	var counters []string
+	for _, e := range envelopes {
+		if c := e.GetCounter(); c != nil {
+			counters = append(counters, fmt.Sprintf("%s method=%s %d", c.GetName(), e.GetTags()["method"], c.GetTotal()))
+		}
+	}
+	return counters
+}
+
+func gauges(envelopes []*loggregator_v2.Envelope) []string {
+	var gauges []string
+	for _, e := range envelopes {
+		for name, value := range e.GetGauge().GetMetrics() {
+			gauges = append(gauges, fmt.Sprintf("%s %v", name, value.GetValue()))
+		}
+	}
+	return gauges
+}
+
+func quietLogger() *logging.Logger {
+	return logging.New(ioutil.Discard, logging.Error)
+}
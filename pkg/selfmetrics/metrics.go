@@ -0,0 +1,208 @@
+// Package selfmetrics exposes metric-proxy's own operational metrics in
+// Prometheus format.
+package selfmetrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// Upstream dependencies reported in the "dependency" label.
+const (
+	DependencyMetricsAPI   = "metrics-api"
+	DependencyPods         = "pods"
+	DependencyNodeSummary  = "node-summary"
+	DependencyNodeCadvisor = "node-cadvisor"
+	DependencyAppScrape    = "app-scrape"
+)
+
+// Metrics records metric-proxy's own behaviour. A nil *Metrics discards
+// everything, so components can be used without it.
+type Metrics struct {
+	requestDurations   *prometheus.HistogramVec
+	upstreamDurations  *prometheus.HistogramVec
+	upstreamErrors     *prometheus.CounterVec
+	nodeCacheHits      prometheus.Counter
+	nodeCacheMisses    prometheus.Counter
+	nodeCacheEvictions prometheus.Counter
+	metricsCacheHits   prometheus.Counter
+	metricsCacheMisses prometheus.Counter
+	envelopes          prometheus.Histogram
+	podsWithoutMetrics prometheus.Counter
+}
+
+// New registers metric-proxy's metrics with the registerer. durationBuckets
+// are used for both gRPC request and upstream call latencies.
+func New(registerer prometheus.Registerer, durationBuckets []float64) *Metrics {
+	m := &Metrics{
+		requestDurations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "request_duration_seconds",
+			Help:    "gRPC and HTTP API request duration distribution",
+			Buckets: durationBuckets,
+		}, []string{"method", "code"}),
+		upstreamDurations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "upstream_request_duration_seconds",
+			Help:    "Duration of requests to Kubernetes APIs",
+			Buckets: durationBuckets,
+		}, []string{"dependency"}),
+		upstreamErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "upstream_request_errors_total",
+			Help: "Failed requests to Kubernetes APIs",
+		}, []string{"dependency"}),
+		nodeCacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "node_cache_hits_total",
+			Help: "Disk usage lookups served from a cached node summary",
+		}),
+		nodeCacheMisses: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "node_cache_misses_total",
+			Help: "Disk usage lookups with no cached node summary",
+		}),
+		nodeCacheEvictions: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "node_cache_evictions_total",
+			Help: "Cached node summaries discarded because they were missing a pod",
+		}),
+		metricsCacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "metrics_cache_hits_total",
+			Help: "Pod metrics lookups served from the response cache or a coalesced in-flight request",
+		}),
+		metricsCacheMisses: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "metrics_cache_misses_total",
+			Help: "Pod metrics lookups sent to metrics-server",
+		}),
+		envelopes: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "envelopes_per_request",
+			Help:    "Envelopes returned per Read request",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+		}),
+		podsWithoutMetrics: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "pods_without_metrics_total",
+			Help: "Pods reported with placeholder envelopes because metrics-server had no metrics for them",
+		}),
+	}
+
+	registerer.MustRegister(
+		m.requestDurations,
+		m.upstreamDurations,
+		m.upstreamErrors,
+		m.nodeCacheHits,
+		m.nodeCacheMisses,
+		m.nodeCacheEvictions,
+		m.metricsCacheHits,
+		m.metricsCacheMisses,
+		m.envelopes,
+		m.podsWithoutMetrics,
+	)
+
+	return m
+}
+
+// UnaryServerInterceptor records the duration of every call by method and
+// status code.
+func (m *Metrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		start := time.Now()
+
+		resp, err := handler(ctx, req)
+
+		if m != nil {
+			m.requestDurations.
+				WithLabelValues(info.FullMethod, status.Code(err).String()).
+				Observe(time.Since(start).Seconds())
+		}
+
+		return resp, err
+	}
+}
+
+// HTTPMiddleware records the duration of every HTTP request like
+// UnaryServerInterceptor, with the path as the method and the HTTP status
+// as the code.
+func (m *Metrics) HTTPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		if m != nil {
+			m.requestDurations.
+				WithLabelValues(r.URL.Path, strconv.Itoa(recorder.status)).
+				Observe(time.Since(start).Seconds())
+		}
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// ObserveUpstream records a call to dependency that started at start.
+func (m *Metrics) ObserveUpstream(dependency string, start time.Time, err error) {
+	if m == nil {
+		return
+	}
+
+	m.upstreamDurations.WithLabelValues(dependency).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.upstreamErrors.WithLabelValues(dependency).Inc()
+	}
+}
+
+func (m *Metrics) NodeCacheHit() {
+	if m != nil {
+		m.nodeCacheHits.Inc()
+	}
+}
+
+func (m *Metrics) NodeCacheMiss() {
+	if m != nil {
+		m.nodeCacheMisses.Inc()
+	}
+}
+
+func (m *Metrics) NodeCacheEviction() {
+	if m != nil {
+		m.nodeCacheEvictions.Inc()
+	}
+}
+
+func (m *Metrics) MetricsCacheHit() {
+	if m != nil {
+		m.metricsCacheHits.Inc()
+	}
+}
+
+func (m *Metrics) MetricsCacheMiss() {
+	if m != nil {
+		m.metricsCacheMisses.Inc()
+	}
+}
+
+func (m *Metrics) ObserveEnvelopes(n int) {
+	if m != nil {
+		m.envelopes.Observe(float64(n))
+	}
+}
+
+func (m *Metrics) PodsWithoutMetrics(n int) {
+	if m != nil {
+		m.podsWithoutMetrics.Add(float64(n))
+	}
+}
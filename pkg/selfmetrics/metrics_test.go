@@ -0,0 +1,128 @@
+package selfmetrics_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/metric-proxy/pkg/selfmetrics"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Run("it labels request durations by method and status code", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		registry := prometheus.NewRegistry()
+		m := selfmetrics.New(registry, []float64{1})
+		interceptor := m.UnaryServerInterceptor()
+		info := &grpc.UnaryServerInfo{FullMethod: "/logcache.v1.Egress/Read"}
+
+		_, _ = interceptor(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+			return nil, nil
+		})
+		_, _ = interceptor(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+			return nil, status.Error(codes.Unavailable, "k8s problem")
+		})
+
+		families, err := registry.Gather()
+		g.Expect(err).ToNot(HaveOccurred())
+
+		counts := map[string]uint64{}
+		for _, family := range families {
+			if family.GetName() != "request_duration_seconds" {
+				continue
+			}
+			for _, metric := range family.GetMetric() {
+				labels := map[string]string{}
+				for _, label := range metric.GetLabel() {
+					labels[label.GetName()] = label.GetValue()
+				}
+				counts[labels["method"]+" "+labels["code"]] = metric.GetHistogram().GetSampleCount()
+			}
+		}
+		g.Expect(counts).To(Equal(map[string]uint64{
+			"/logcache.v1.Egress/Read OK":          1,
+			"/logcache.v1.Egress/Read Unavailable": 1,
+		}))
+	})
+
+	t.Run("it labels HTTP request durations by path and status", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		registry := prometheus.NewRegistry()
+		handler := selfmetrics.New(registry, []float64{1}).HTTPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("source_id") == "" {
+				http.Error(w, "at least one source ID is required", http.StatusBadRequest)
+			}
+		}))
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/batch-read?source_id=app-1", nil))
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/batch-read", nil))
+
+		families, err := registry.Gather()
+		g.Expect(err).ToNot(HaveOccurred())
+
+		counts := map[string]uint64{}
+		for _, family := range families {
+			if family.GetName() != "request_duration_seconds" {
+				continue
+			}
+			for _, metric := range family.GetMetric() {
+				labels := map[string]string{}
+				for _, label := range metric.GetLabel() {
+					labels[label.GetName()] = label.GetValue()
+				}
+				counts[labels["method"]+" "+labels["code"]] = metric.GetHistogram().GetSampleCount()
+			}
+		}
+		g.Expect(counts).To(Equal(map[string]uint64{
+			"/api/v1/batch-read 200": 1,
+			"/api/v1/batch-read 400": 1,
+		}))
+	})
+
+	t.Run("it counts upstream errors per dependency", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		registry := prometheus.NewRegistry()
+		m := selfmetrics.New(registry, prometheus.DefBuckets)
+
+		m.ObserveUpstream(selfmetrics.DependencyPods, time.Now(), nil)
+		m.ObserveUpstream(selfmetrics.DependencyNodeSummary, time.Now(), errors.New("k8s problem"))
+
+		g.Expect(testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP upstream_request_errors_total Failed requests to Kubernetes APIs
+# TYPE upstream_request_errors_total counter
+upstream_request_errors_total{dependency="node-summary"} 1
+`), "upstream_request_errors_total")).To(Succeed())
+	})
+
+	t.Run("a nil Metrics discards everything", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		var m *selfmetrics.Metrics
+		g.Expect(func() {
+			m.ObserveUpstream(selfmetrics.DependencyPods, time.Now(), nil)
+			m.NodeCacheHit()
+			m.NodeCacheMiss()
+			m.NodeCacheEviction()
+			m.MetricsCacheHit()
+			m.MetricsCacheMiss()
+			m.ObserveEnvelopes(3)
+			m.PodsWithoutMetrics(1)
+			_, _ = m.UnaryServerInterceptor()(context.Background(), nil, &grpc.UnaryServerInfo{}, func(context.Context, interface{}) (interface{}, error) {
+				return nil, nil
+			})
+		}).ToNot(Panic())
+	})
+}
@@ -0,0 +1,370 @@
+// Package synthetic makes up apps and their metrics so metric-proxy can run
+// without a cluster, e.g. for working on `cf app` output locally. Everything
+// is derived from a seed and the time since the Generator was created, so a
+// given seed always produces the same apps behaving the same way.
+package synthetic
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/metrics/pkg/apis/metrics/v1beta1"
+)
+
+const (
+	// Namespace and NodeName are used for every synthetic pod.
+	Namespace = "synthetic"
+	NodeName  = "synthetic-node"
+
+	// CrashPeriod is how long a crashed instance stays crashed. Each
+	// instance may crash once per period.
+	CrashPeriod = time.Minute
+	// CPUPeriod is the period of the CPU usage sine wave.
+	CPUPeriod = 5 * time.Minute
+
+	memoryQuota = 1 << 30
+	diskQuota   = 1 << 30
+)
+
+// Config describes the apps to make up.
+type Config struct {
+	Apps      int
+	Instances int
+	Seed      int64
+	// CrashRate is the chance, from 0 to 1, that an instance crashes in any
+	// CrashPeriod.
+	CrashRate float64
+
+	// AppLabel, SpaceLabel and OrgLabel are the pod labels holding the app,
+	// space and org guids.
+	AppLabel   string
+	SpaceLabel string
+	OrgLabel   string
+}
+
+// Generator serves synthetic pods and metrics. Its methods match the
+// function types and interfaces of package metrics.
+type Generator struct {
+	cfg   Config
+	clock clock.PassiveClock
+	start time.Time
+
+	apps      []*app
+	byGUID    map[string]*app
+	byPodName map[string]*instance
+}
+
+type app struct {
+	guid, name, spaceGUID, orgGUID string
+	instances                      []*instance
+}
+
+type instance struct {
+	app   *app
+	index int
+
+	cpuBase, cpuAmplitude, cpuPhase float64 // cores and radians
+	memoryBase, memoryGrowth        float64 // bytes and bytes per second
+	diskBase, diskGrowth            float64 // bytes and bytes per second
+}
+
+// NewGenerator makes up the apps for cfg. Time starts now.
+func NewGenerator(cfg Config, clock clock.PassiveClock) *Generator {
+	r := rand.New(rand.NewSource(cfg.Seed))
+
+	orgGUID := newGUID(r)
+	spaceGUIDs := make([]string, int(math.Min(3, float64(cfg.Apps))))
+	for i := range spaceGUIDs {
+		spaceGUIDs[i] = newGUID(r)
+	}
+
+	g := &Generator{
+		cfg:       cfg,
+		clock:     clock,
+		start:     clock.Now(),
+		byGUID:    map[string]*app{},
+		byPodName: map[string]*instance{},
+	}
+	for i := 0; i < cfg.Apps; i++ {
+		a := &app{
+			guid:      newGUID(r),
+			name:      fmt.Sprintf("synthetic-app-%d", i),
+			spaceGUID: spaceGUIDs[i%len(spaceGUIDs)],
+			orgGUID:   orgGUID,
+		}
+		for j := 0; j < cfg.Instances; j++ {
+			inst := &instance{
+				app:          a,
+				index:        j,
+				cpuBase:      0.05 + 0.3*r.Float64(),
+				cpuAmplitude: 0.05 + 0.2*r.Float64(),
+				cpuPhase:     2 * math.Pi * r.Float64(),
+				memoryBase:   (64 + 128*r.Float64()) * (1 << 20),
+				memoryGrowth: (16 + 64*r.Float64()) * (1 << 10),
+				diskBase:     (100 + 100*r.Float64()) * (1 << 20),
+				diskGrowth:   (1 + 8*r.Float64()) * (1 << 10),
+			}
+			a.instances = append(a.instances, inst)
+			g.byPodName[inst.podName()] = inst
+		}
+		g.apps = append(g.apps, a)
+		g.byGUID[a.guid] = a
+	}
+
+	return g
+}
+
+// GUIDs returns the app guids in the order they were made up.
+func (g *Generator) GUIDs() []string {
+	guids := make([]string, 0, len(g.apps))
+	for _, a := range g.apps {
+		guids = append(guids, a.guid)
+	}
+	return guids
+}
+
+// Pods returns the pods of app guid.
+func (g *Generator) Pods(guid string) ([]*v1.Pod, error) {
+	a, ok := g.byGUID[guid]
+	if !ok {
+		return nil, nil
+	}
+
+	now := g.clock.Now()
+	pods := make([]*v1.Pod, 0, len(a.instances))
+	for _, inst := range a.instances {
+		pods = append(pods, g.pod(inst, now))
+	}
+	return pods, nil
+}
+
+// Get returns the named pod.
+func (g *Generator) Get(_ context.Context, podName string) (*v1.Pod, error) {
+	inst, ok := g.byPodName[podName]
+	if !ok {
+		return nil, fmt.Errorf("pod %q not found", podName)
+	}
+	return g.pod(inst, g.clock.Now()), nil
+}
+
+// Metrics returns metrics-server's view of app guid: its instances that
+// aren't crashed.
+func (g *Generator) Metrics(_ context.Context, guid string) (*v1beta1.PodMetricsList, error) {
+	list := &v1beta1.PodMetricsList{}
+	a, ok := g.byGUID[guid]
+	if !ok {
+		return list, nil
+	}
+
+	now := g.clock.Now()
+	for _, inst := range a.instances {
+		if m, ok := g.podMetrics(inst, now); ok {
+			list.Items = append(list.Items, m)
+		}
+	}
+	return list, nil
+}
+
+// BatchMetrics returns Metrics for each of guids that has any.
+func (g *Generator) BatchMetrics(ctx context.Context, guids []string) (map[string]*v1beta1.PodMetricsList, error) {
+	byGUID := make(map[string]*v1beta1.PodMetricsList, len(guids))
+	for _, guid := range guids {
+		list, _ := g.Metrics(ctx, guid)
+		if len(list.Items) > 0 {
+			byGUID[guid] = list
+		}
+	}
+	return byGUID, nil
+}
+
+// DiskUsage returns the named pod's disk usage in bytes.
+func (g *Generator) DiskUsage(_ context.Context, podName string) (int64, error) {
+	inst, ok := g.byPodName[podName]
+	if !ok {
+		return 0, fmt.Errorf("disk usage for pod %q not found", podName)
+	}
+
+	uptime := g.clock.Now().Sub(g.startedAt(inst, g.clock.Now())).Seconds()
+	return int64(math.Min(inst.diskBase+inst.diskGrowth*uptime, 0.9*diskQuota)), nil
+}
+
+// CPUTime returns the CPU time, in nanoseconds, the pod has used since it
+// last started. It accumulates at the instance's mean CPU usage.
+func (g *Generator) CPUTime(_ context.Context, pod *v1.Pod) (uint64, error) {
+	inst, ok := g.byPodName[pod.Name]
+	if !ok {
+		return 0, fmt.Errorf("cpu time for pod %q not found", pod.Name)
+	}
+
+	uptime := g.clock.Now().Sub(g.startedAt(inst, g.clock.Now()))
+	return uint64(inst.cpuBase * float64(uptime.Nanoseconds())), nil
+}
+
+// DiskIO returns the bytes the pod has read and written since it last
+// started. Writes accumulate at the rate its disk usage grows, and reads at
+// twice that.
+func (g *Generator) DiskIO(_ context.Context, pod *v1.Pod) (readBytes, writeBytes uint64, err error) {
+	inst, ok := g.byPodName[pod.Name]
+	if !ok {
+		return 0, 0, fmt.Errorf("disk io for pod %q not found", pod.Name)
+	}
+
+	uptime := g.clock.Now().Sub(g.startedAt(inst, g.clock.Now())).Seconds()
+	writeBytes = uint64(inst.diskGrowth * uptime)
+	return 2 * writeBytes, writeBytes, nil
+}
+
+// Sources returns the guids of apps whose pods carry all of the given
+// labels.
+func (g *Generator) Sources(set map[string]string) ([]string, error) {
+	selector := labels.SelectorFromSet(set)
+
+	var guids []string
+	for _, a := range g.apps {
+		if selector.Matches(labels.Set(g.labels(a))) {
+			guids = append(guids, a.guid)
+		}
+	}
+	sort.Strings(guids)
+	return guids, nil
+}
+
+func (g *Generator) pod(inst *instance, now time.Time) *v1.Pod {
+	startedAt := metav1.NewTime(g.startedAt(inst, now))
+	status := v1.ContainerStatus{
+		Name:         "opi",
+		RestartCount: g.restarts(inst, now),
+		Ready:        true,
+		State:        v1.ContainerState{Running: &v1.ContainerStateRunning{StartedAt: startedAt}},
+	}
+	if g.crashed(inst, g.window(now)) {
+		status.Ready = false
+		status.State = v1.ContainerState{Waiting: &v1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}}
+	}
+
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: Namespace,
+			Name:      inst.podName(),
+			Labels:    g.labels(inst.app),
+		},
+		Spec: v1.PodSpec{
+			NodeName: NodeName,
+			Containers: []v1.Container{{
+				Name: "opi",
+				Resources: v1.ResourceRequirements{Limits: v1.ResourceList{
+					v1.ResourceMemory:           *resource.NewQuantity(memoryQuota, resource.BinarySI),
+					v1.ResourceEphemeralStorage: *resource.NewQuantity(diskQuota, resource.BinarySI),
+				}},
+			}},
+		},
+		Status: v1.PodStatus{
+			Phase:             v1.PodRunning,
+			StartTime:         &startedAt,
+			ContainerStatuses: []v1.ContainerStatus{status},
+		},
+	}
+}
+
+func (g *Generator) podMetrics(inst *instance, now time.Time) (v1beta1.PodMetrics, bool) {
+	if g.crashed(inst, g.window(now)) {
+		return v1beta1.PodMetrics{}, false
+	}
+
+	elapsed := now.Sub(g.start).Seconds()
+	cpu := inst.cpuBase + inst.cpuAmplitude*math.Sin(2*math.Pi*elapsed/CPUPeriod.Seconds()+inst.cpuPhase)
+	uptime := now.Sub(g.startedAt(inst, now)).Seconds()
+	memory := math.Min(inst.memoryBase+inst.memoryGrowth*uptime, 0.9*memoryQuota)
+
+	return v1beta1.PodMetrics{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: Namespace,
+			Name:      inst.podName(),
+			Labels:    g.labels(inst.app),
+		},
+		Timestamp: metav1.NewTime(now),
+		Window:    metav1.Duration{Duration: 30 * time.Second},
+		Containers: []v1beta1.ContainerMetrics{{
+			Name: "opi",
+			Usage: v1.ResourceList{
+				v1.ResourceCPU:    *resource.NewScaledQuantity(int64(math.Max(cpu, 0)*1e9), resource.Nano),
+				v1.ResourceMemory: *resource.NewQuantity(int64(memory), resource.BinarySI),
+			},
+		}},
+	}, true
+}
+
+func (g *Generator) labels(a *app) map[string]string {
+	return map[string]string{
+		g.cfg.AppLabel:   a.guid,
+		g.cfg.SpaceLabel: a.spaceGUID,
+		g.cfg.OrgLabel:   a.orgGUID,
+	}
+}
+
+// window is the number of whole CrashPeriods since the Generator started.
+func (g *Generator) window(now time.Time) int64 {
+	return int64(now.Sub(g.start) / CrashPeriod)
+}
+
+// crashed reports whether the instance is crashed throughout window.
+func (g *Generator) crashed(inst *instance, window int64) bool {
+	if g.cfg.CrashRate <= 0 {
+		return false
+	}
+
+	h := fnv.New64a()
+	var buf [8]byte
+	for _, v := range []int64{g.cfg.Seed, int64(inst.index), window} {
+		binary.LittleEndian.PutUint64(buf[:], uint64(v))
+		_, _ = h.Write(buf[:])
+	}
+	_, _ = h.Write([]byte(inst.app.guid))
+
+	return float64(h.Sum64()>>11)/(1<<53) < g.cfg.CrashRate
+}
+
+// restarts counts the instance's crashes before the current window.
+func (g *Generator) restarts(inst *instance, now time.Time) int32 {
+	var n int32
+	for w := int64(0); w < g.window(now); w++ {
+		if g.crashed(inst, w) {
+			n++
+		}
+	}
+	return n
+}
+
+// startedAt is when the instance last came back from a crash, or when the
+// Generator started.
+func (g *Generator) startedAt(inst *instance, now time.Time) time.Time {
+	for w := g.window(now) - 1; w >= 0; w-- {
+		if g.crashed(inst, w) {
+			return g.start.Add(time.Duration(w+1) * CrashPeriod)
+		}
+	}
+	return g.start
+}
+
+func (inst *instance) podName() string {
+	return fmt.Sprintf("%s-%d", inst.app.name, inst.index)
+}
+
+func newGUID(r *rand.Rand) string {
+	b := make([]byte, 16)
+	_, _ = r.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
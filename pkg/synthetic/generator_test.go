@@ -0,0 +1,158 @@
+package synthetic_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"code.cloudfoundry.org/metric-proxy/pkg/synthetic"
+	. "github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+)
+
+func TestGenerator(t *testing.T) {
+	start := time.Date(2020, 3, 9, 15, 0, 0, 0, time.UTC)
+	config := func(seed int64, crashRate float64) synthetic.Config {
+		return synthetic.Config{
+			Apps:       4,
+			Instances:  2,
+			Seed:       seed,
+			CrashRate:  crashRate,
+			AppLabel:   "app",
+			SpaceLabel: "space",
+			OrgLabel:   "org",
+		}
+	}
+	usage := func(g *GomegaWithT, gen *synthetic.Generator, guid string) (cpu, memory int64) {
+		list, err := gen.Metrics(context.Background(), guid)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(list.Items).ToNot(BeEmpty())
+		u := list.Items[0].Containers[0].Usage
+		return u.Cpu().ScaledValue(-9), u.Memory().Value()
+	}
+
+	t.Run("the same seed makes up the same apps and metrics", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		a := synthetic.NewGenerator(config(42, 0), clock.NewFakeClock(start))
+		b := synthetic.NewGenerator(config(42, 0), clock.NewFakeClock(start))
+		c := synthetic.NewGenerator(config(43, 0), clock.NewFakeClock(start))
+
+		g.Expect(a.GUIDs()).To(HaveLen(4))
+		g.Expect(a.GUIDs()).To(Equal(b.GUIDs()))
+		g.Expect(a.GUIDs()).ToNot(Equal(c.GUIDs()))
+
+		aMetrics, _ := a.Metrics(context.Background(), a.GUIDs()[0])
+		bMetrics, _ := b.Metrics(context.Background(), b.GUIDs()[0])
+		g.Expect(aMetrics).To(Equal(bMetrics))
+		g.Expect(aMetrics.Items).To(HaveLen(2))
+	})
+
+	t.Run("CPU follows a sine wave and memory grows", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		fakeClock := clock.NewFakeClock(start)
+		gen := synthetic.NewGenerator(config(1, 0), fakeClock)
+		guid := gen.GUIDs()[0]
+
+		cpu0, memory0 := usage(g, gen, guid)
+		fakeClock.Step(synthetic.CPUPeriod / 2)
+		cpuHalf, memoryHalf := usage(g, gen, guid)
+		fakeClock.Step(synthetic.CPUPeriod / 2)
+		cpuFull, memoryFull := usage(g, gen, guid)
+
+		g.Expect(cpuHalf).ToNot(BeNumerically("~", cpu0, 1000))
+		g.Expect(cpuFull).To(BeNumerically("~", cpu0, 1000))
+		g.Expect(memoryHalf).To(BeNumerically(">", memory0))
+		g.Expect(memoryFull).To(BeNumerically(">", memoryHalf))
+
+		size, err := gen.DiskUsage(context.Background(), "synthetic-app-0-1")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(size).To(BeNumerically(">", 0))
+
+		pod, err := gen.Get(context.Background(), "synthetic-app-0-1")
+		g.Expect(err).ToNot(HaveOccurred())
+		cpuTime, err := gen.CPUTime(context.Background(), pod)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(cpuTime).To(BeNumerically(">", 0))
+		readBytes, writeBytes, err := gen.DiskIO(context.Background(), pod)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(writeBytes).To(BeNumerically(">", 0))
+		g.Expect(readBytes).To(Equal(2 * writeBytes))
+	})
+
+	t.Run("crashed instances have no metrics and count restarts", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		fakeClock := clock.NewFakeClock(start)
+		gen := synthetic.NewGenerator(config(1, 1), fakeClock)
+		guid := gen.GUIDs()[0]
+
+		list, err := gen.Metrics(context.Background(), guid)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(list.Items).To(BeEmpty())
+
+		pods, err := gen.Pods(guid)
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(pods).To(HaveLen(2))
+		g.Expect(pods[0].Name).To(Equal("synthetic-app-0-0"))
+		g.Expect(pods[0].Status.ContainerStatuses[0].State.Waiting.Reason).To(Equal("CrashLoopBackOff"))
+
+		fakeClock.Step(2 * synthetic.CrashPeriod)
+		pod, err := gen.Get(context.Background(), "synthetic-app-0-1")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(pod.Status.ContainerStatuses[0].RestartCount).To(BeEquivalentTo(2))
+		g.Expect(pod.Status.StartTime.Time).To(Equal(start.Add(2 * synthetic.CrashPeriod)))
+	})
+
+	t.Run("instances stay running without a crash rate", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		fakeClock := clock.NewFakeClock(start)
+		gen := synthetic.NewGenerator(config(1, 0), fakeClock)
+		fakeClock.Step(time.Hour)
+
+		pods, err := gen.Pods(gen.GUIDs()[1])
+		g.Expect(err).ToNot(HaveOccurred())
+		for _, pod := range pods {
+			g.Expect(pod.Status.Phase).To(Equal(v1.PodRunning))
+			g.Expect(pod.Status.ContainerStatuses[0].Ready).To(BeTrue())
+			g.Expect(pod.Status.ContainerStatuses[0].RestartCount).To(BeZero())
+		}
+	})
+
+	t.Run("it lists apps by label", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		gen := synthetic.NewGenerator(config(1, 0), clock.NewFakeClock(start))
+		pods, err := gen.Pods(gen.GUIDs()[0])
+		g.Expect(err).ToNot(HaveOccurred())
+
+		guids, err := gen.Sources(map[string]string{"space": pods[0].Labels["space"]})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(guids).To(ConsistOf(gen.GUIDs()[0], gen.GUIDs()[3]))
+
+		guids, err = gen.Sources(map[string]string{"org": pods[0].Labels["org"]})
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(guids).To(HaveLen(4))
+	})
+
+	t.Run("unknown apps and pods", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		gen := synthetic.NewGenerator(config(1, 0), clock.NewFakeClock(start))
+
+		pods, err := gen.Pods("unknown")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(pods).To(BeEmpty())
+
+		_, err = gen.Get(context.Background(), "unknown-0")
+		g.Expect(err).To(MatchError(`pod "unknown-0" not found`))
+		_, err = gen.DiskUsage(context.Background(), "unknown-0")
+		g.Expect(err).To(HaveOccurred())
+		_, err = gen.CPUTime(context.Background(), &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "unknown-0"}})
+		g.Expect(err).To(HaveOccurred())
+	})
+}
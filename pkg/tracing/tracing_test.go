@@ -0,0 +1,150 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"code.cloudfoundry.org/metric-proxy/pkg/tracing"
+	. "github.com/onsi/gomega"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	var (
+		g        *GomegaWithT
+		recorder *tracetest.SpanRecorder
+	)
+
+	setUp := func(t *testing.T) {
+		g = NewGomegaWithT(t)
+
+		recorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	}
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/logcache.v1.Egress/Read"}
+
+	t.Run("it continues incoming W3C trace context", func(t *testing.T) {
+		setUp(t)
+
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+			"traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		))
+		_, err := tracing.UnaryServerInterceptor()(ctx, nil, info, func(ctx context.Context, _ interface{}) (interface{}, error) {
+			_, span := tracing.StartSpan(ctx, "metrics-api.list")
+			tracing.End(span, nil)
+			return nil, nil
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+
+		spans := recorder.Ended()
+		g.Expect(spans).To(HaveLen(2))
+
+		child, server := spans[0], spans[1]
+		g.Expect(server.Name()).To(Equal("/logcache.v1.Egress/Read"))
+		g.Expect(server.SpanKind()).To(Equal(trace.SpanKindServer))
+		g.Expect(server.SpanContext().TraceID().String()).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+		g.Expect(server.Parent().SpanID().String()).To(Equal("00f067aa0ba902b7"))
+		g.Expect(server.Parent().IsRemote()).To(BeTrue())
+
+		g.Expect(child.Name()).To(Equal("metrics-api.list"))
+		g.Expect(child.SpanKind()).To(Equal(trace.SpanKindClient))
+		g.Expect(child.Parent().SpanID()).To(Equal(server.SpanContext().SpanID()))
+	})
+
+	t.Run("it starts a new trace without incoming context", func(t *testing.T) {
+		setUp(t)
+
+		_, err := tracing.UnaryServerInterceptor()(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+			return nil, nil
+		})
+		g.Expect(err).ToNot(HaveOccurred())
+
+		spans := recorder.Ended()
+		g.Expect(spans).To(HaveLen(1))
+		g.Expect(spans[0].Parent().IsValid()).To(BeFalse())
+	})
+
+	t.Run("it records handler errors on the span", func(t *testing.T) {
+		setUp(t)
+
+		_, err := tracing.UnaryServerInterceptor()(context.Background(), nil, info, func(context.Context, interface{}) (interface{}, error) {
+			return nil, errors.New("k8s problem")
+		})
+		g.Expect(err).To(MatchError("k8s problem"))
+
+		spans := recorder.Ended()
+		g.Expect(spans).To(HaveLen(1))
+		g.Expect(spans[0].Status().Code).To(Equal(codes.Error))
+		g.Expect(spans[0].Status().Description).To(Equal("k8s problem"))
+	})
+}
+
+func TestHTTPMiddleware(t *testing.T) {
+	g := NewGomegaWithT(t)
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	handler := tracing.HTTPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := tracing.StartSpan(r.Context(), "metrics-api.list")
+		tracing.End(span, nil)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/batch-read?source_id=app-1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	g.Expect(spans).To(HaveLen(2))
+
+	child, server := spans[0], spans[1]
+	g.Expect(server.Name()).To(Equal("/api/v1/batch-read"))
+	g.Expect(server.SpanKind()).To(Equal(trace.SpanKindServer))
+	g.Expect(server.SpanContext().TraceID().String()).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+	g.Expect(server.Status().Code).To(Equal(codes.Error))
+	g.Expect(child.Parent().SpanID()).To(Equal(server.SpanContext().SpanID()))
+}
+
+func TestNewTracerProvider(t *testing.T) {
+	t.Run("it returns no provider when tracing is disabled", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		tp, err := tracing.NewTracerProvider(tracing.ExporterNone, 1, nil, "dev")
+		g.Expect(err).ToNot(HaveOccurred())
+		g.Expect(tp).To(BeNil())
+	})
+
+	t.Run("it exports spans to the writer with the stdout exporter", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		var buf bytes.Buffer
+		tp, err := tracing.NewTracerProvider(tracing.ExporterStdout, 1, &buf, "dev")
+		g.Expect(err).ToNot(HaveOccurred())
+
+		_, span := tp.Tracer("test").Start(context.Background(), "some-span")
+		span.End()
+		g.Expect(tp.Shutdown(context.Background())).To(Succeed())
+
+		g.Expect(buf.String()).To(ContainSubstring("some-span"))
+		g.Expect(buf.String()).To(ContainSubstring("metric-proxy"))
+	})
+
+	t.Run("it rejects unknown exporters", func(t *testing.T) {
+		g := NewGomegaWithT(t)
+
+		_, err := tracing.NewTracerProvider("zipkin", 1, nil, "dev")
+		g.Expect(err).To(MatchError(`unknown tracing exporter "zipkin"`))
+	})
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"code.cloudfoundry.org/log-cache/pkg/rpc/logcache_v1"
+	"code.cloudfoundry.org/metric-proxy/pkg/emitter"
+	"code.cloudfoundry.org/metric-proxy/pkg/health"
+	"code.cloudfoundry.org/metric-proxy/pkg/logging"
+	"code.cloudfoundry.org/metric-proxy/pkg/metrics"
+	"code.cloudfoundry.org/metric-proxy/pkg/ratelimit"
+	"code.cloudfoundry.org/metric-proxy/pkg/replay"
+	"code.cloudfoundry.org/metric-proxy/pkg/rpc/metricproxy_v1"
+	"code.cloudfoundry.org/metric-proxy/pkg/scraper"
+	"code.cloudfoundry.org/metric-proxy/pkg/selfmetrics"
+	"code.cloudfoundry.org/metric-proxy/pkg/synthetic"
+	"code.cloudfoundry.org/metric-proxy/pkg/tracing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/client-go/rest"
+)
+
+// backend is where the proxy gets pods and metrics from.
+type backend struct {
+	metricsFetcher      metrics.MetricsFetcherFn
+	batchMetricsFetcher metrics.BatchMetricsFetcherFn
+	podLister           metrics.PodListerFn
+	podGetter           metrics.PodGetter
+	diskUsageFetcher    metrics.DiskUsageFetcher
+	cpuTimeFetcher      metrics.CPUTimeFetcher
+	diskIOFetcher       metrics.DiskIOFetcher
+	sourceLister        metrics.SourceListerFn
+	checks              map[string]health.Check
+}
+
+// newKubernetesBackend reads the Kubernetes APIs at restConfig. Pod changes
+// invalidate metricsCache.
+func newKubernetesBackend(
+	cfg *Config,
+	restConfig *rest.Config,
+	loggr *logging.Logger,
+	selfMetrics *selfmetrics.Metrics,
+	metricsCache *metrics.MetricsCache,
+	stop <-chan struct{},
+) (*backend, error) {
+	resolver, podsSynced, err := createResolver(cfg, restConfig, metricsCache, stop)
+	if err != nil {
+		return nil, fmt.Errorf("cannot initialize pod discovery: %w", err)
+	}
+
+	fetcher, err := createMetricsFetcher(cfg, restConfig, resolver)
+	if err != nil {
+		return nil, fmt.Errorf("cannot initialize metric fetcher: %w", err)
+	}
+
+	batchFetcher, err := createBatchMetricsFetcher(cfg, restConfig, resolver)
+	if err != nil {
+		return nil, fmt.Errorf("cannot initialize batch metric fetcher: %w", err)
+	}
+
+	podGetter, err := createPodGetter(restConfig, resolver)
+	if err != nil {
+		return nil, fmt.Errorf("cannot initialize pod getter: %w", err)
+	}
+
+	nodeStatter, err := createNodeStatter(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("cannot initialize node statter: %w", err)
+	}
+
+	var podLister metrics.PodListerFn = resolver.Pods
+	if cfg.RecordDir != "" {
+		recorder, err := replay.NewRecorder(loggr, cfg.RecordDir,
+			cfg.AppNameAnnotation,
+			cfg.SpaceNameAnnotation,
+			cfg.OrgNameAnnotation,
+			scraper.ScrapeAnnotation,
+			scraper.PortAnnotation,
+			scraper.PathAnnotation,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("cannot initialize recorder: %w", err)
+		}
+		loggr.Info("recording Kubernetes API responses", "dir", cfg.RecordDir)
+
+		fetcher = recorder.MetricsFetcher(fetcher)
+		batchFetcher = recorder.BatchMetricsFetcher(batchFetcher)
+		podLister = recorder.PodLister(podLister)
+		podGetter = recorder.PodGetter(podGetter)
+		nodeStatter = recorder.NodeStatter(nodeStatter)
+	}
+
+	diskUsageFetcher, err := createDiskUsageFetcher(cfg, loggr, selfMetrics, podGetter, nodeStatter)
+	if err != nil {
+		return nil, fmt.Errorf("cannot initialize disk usage fetcher: %w", err)
+	}
+
+	checks, err := createHealthChecks(restConfig, podsSynced)
+	if err != nil {
+		return nil, fmt.Errorf("cannot initialize health checks: %w", err)
+	}
+
+	return &backend{
+		metricsFetcher:      fetcher,
+		batchMetricsFetcher: batchFetcher,
+		podLister:           podLister,
+		podGetter:           podGetter,
+		diskUsageFetcher:    diskUsageFetcher,
+		cpuTimeFetcher:      diskUsageFetcher,
+		diskIOFetcher:       diskUsageFetcher,
+		sourceLister:        resolver.Sources,
+		checks:              checks,
+	}, nil
+}
+
+// newSyntheticBackend makes up apps and metrics instead of reading a
+// cluster.
+func newSyntheticBackend(cfg *Config, loggr *logging.Logger) *backend {
+	generator := synthetic.NewGenerator(synthetic.Config{
+		Apps:       cfg.SyntheticApps,
+		Instances:  cfg.SyntheticInstances,
+		Seed:       cfg.SyntheticSeed,
+		CrashRate:  cfg.SyntheticCrashRate,
+		AppLabel:   cfg.AppSelector,
+		SpaceLabel: cfg.SpaceLabel,
+		OrgLabel:   cfg.OrgLabel,
+	}, clock.RealClock{})
+	loggr.Info("serving synthetic metrics", "seed", cfg.SyntheticSeed, "source_ids", generator.GUIDs())
+
+	return &backend{
+		metricsFetcher:      generator.Metrics,
+		batchMetricsFetcher: generator.BatchMetrics,
+		podLister:           generator.Pods,
+		podGetter:           generator,
+		diskUsageFetcher:    generator,
+		cpuTimeFetcher:      generator,
+		diskIOFetcher:       generator,
+		sourceLister:        generator.Sources,
+	}
+}
+
+// newReplayBackend serves the fixtures recorded in cfg.ReplayDir instead of
+// reading a cluster. Disk usage goes through the same fetcher as in a
+// cluster, using the recorded pods and node summaries.
+func newReplayBackend(cfg *Config, loggr *logging.Logger, selfMetrics *selfmetrics.Metrics) (*backend, error) {
+	fixtures, err := replay.Open(cfg.ReplayDir, cfg.AppSelector)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read fixtures: %w", err)
+	}
+
+	diskUsageFetcher, err := createDiskUsageFetcher(cfg, loggr, selfMetrics, fixtures, fixtures)
+	if err != nil {
+		return nil, fmt.Errorf("cannot initialize disk usage fetcher: %w", err)
+	}
+	loggr.Info("replaying recorded metrics", "dir", cfg.ReplayDir)
+
+	return &backend{
+		metricsFetcher:      fixtures.Metrics,
+		batchMetricsFetcher: fixtures.BatchMetrics,
+		podLister:           fixtures.Pods,
+		podGetter:           fixtures,
+		diskUsageFetcher:    diskUsageFetcher,
+		cpuTimeFetcher:      diskUsageFetcher,
+		diskIOFetcher:       diskUsageFetcher,
+		sourceLister:        fixtures.Sources,
+	}, nil
+}
+
+// server holds the gRPC server, HTTP handlers and emitter, along with the
+// parts a config reload changes. Nothing listens or emits until the caller
+// starts them. emitter is nil without destinations or app drains and
+// scraper is nil when scraping is disabled.
+type server struct {
+	grpc         *grpc.Server
+	api          http.Handler
+	appMetrics   http.Handler
+	emitter      *emitter.Emitter
+	scraper      *scraper.Scraper
+	checker      *health.Checker
+	metricsCache *metrics.MetricsCache
+	limiter      *ratelimit.Limiter
+}
+
+func newServer(
+	cfg *Config,
+	b *backend,
+	loggr *logging.Logger,
+	registry prometheus.Registerer,
+	selfMetrics *selfmetrics.Metrics,
+	metricsCache *metrics.MetricsCache,
+	destinations map[string]emitter.Destination,
+	appDrains emitter.AppDrainsFn,
+) *server {
+	checker := health.NewChecker(loggr, clock.RealClock{}, cfg.HealthCheckInterval, cfg.UnreachableTimeout, b.checks, "logcache.v1.Egress")
+
+	var (
+		appScraper      *scraper.Scraper
+		customEnvelopes metrics.CustomEnvelopesFn
+	)
+	if cfg.ScrapeInterval > 0 {
+		appScraper = scraper.New(loggr, selfMetrics, b.sourceLister, b.podLister, &http.Client{Timeout: cfg.ScrapeTimeout})
+		customEnvelopes = appScraper.Envelopes
+	}
+
+	c := metrics.NewProxy(loggr, selfMetrics, metricsCache, b.metricsFetcher, b.podLister, b.diskUsageFetcher, b.podGetter, metrics.ProxyOptions{
+		CPUTimeFetcher:  b.cpuTimeFetcher,
+		DiskIOFetcher:   b.diskIOFetcher,
+		CustomEnvelopes: customEnvelopes,
+	})
+	limiter := ratelimit.New(ratelimit.Config{
+		Client: ratelimit.Limit{Rate: cfg.RateLimitClientRPS, Burst: cfg.RateLimitClientBurst},
+		Source: ratelimit.Limit{Rate: cfg.RateLimitSourceRPS, Burst: cfg.RateLimitSourceBurst},
+	}, registry)
+
+	batchProxy := metrics.NewBatchProxy(c, b.batchMetricsFetcher)
+	aggregator := metrics.NewAggregator(loggr, batchProxy, b.sourceLister, metrics.AggregatorLabels{
+		Space: cfg.SpaceLabel,
+		Org:   cfg.OrgLabel,
+	})
+	exporter := metrics.NewExporter(loggr, batchProxy, b.sourceLister, b.podLister, metrics.ExporterLabels{
+		Space:     cfg.SpaceLabel,
+		Org:       cfg.OrgLabel,
+		AppName:   cfg.AppNameAnnotation,
+		SpaceName: cfg.SpaceNameAnnotation,
+		OrgName:   cfg.OrgNameAnnotation,
+	}, cfg.AppMetricsTimeout, cfg.AppMetricsCacheTTL)
+	var appEmitter *emitter.Emitter
+	if len(destinations) > 0 || appDrains != nil {
+		appEmitter = emitter.New(loggr, batchProxy, b.sourceLister, destinations, appDrains)
+	}
+	apiMiddleware := func(sourceIDs func(*http.Request) []string) func(http.Handler) http.Handler {
+		return chainHTTPMiddleware(
+			tracing.HTTPMiddleware,
+			selfMetrics.HTTPMiddleware,
+			logging.HTTPMiddleware(loggr),
+			limiter.HTTPMiddleware(sourceIDs),
+		)
+	}
+	apiMux := http.NewServeMux()
+	apiMux.Handle("/api/v1/batch-read", apiMiddleware(metrics.HTTPSourceIDs)(batchProxy.Handler()))
+	apiMux.Handle("/api/v1/aggregate", apiMiddleware(aggregator.HTTPSourceIDs)(aggregator.Handler()))
+
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(chainUnaryInterceptors(
+			tracing.UnaryServerInterceptor(),
+			selfMetrics.UnaryServerInterceptor(),
+			logging.UnaryServerInterceptor(loggr),
+			limiter.UnaryServerInterceptor(aggregator.SourceIDs),
+		)),
+	)
+	logcache_v1.RegisterEgressServer(s, c)
+	metricproxy_v1.RegisterEgressServer(s, batchProxy)
+	metricproxy_v1.RegisterAggregatorServer(s, aggregator)
+	healthpb.RegisterHealthServer(s, checker.HealthServer())
+
+	return &server{
+		grpc:         s,
+		api:          apiMux,
+		appMetrics:   exporter.Handler(),
+		emitter:      appEmitter,
+		scraper:      appScraper,
+		checker:      checker,
+		metricsCache: metricsCache,
+		limiter:      limiter,
+	}
+}